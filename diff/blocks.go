@@ -0,0 +1,64 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+// Limits on the size of the blocks returned by SplitBlocks
+const (
+	MinBlockSize = 16
+	MaxBlockSize = 1024
+)
+
+// the block ends where the rolling hash has these bits cleared, about every 64 bytes
+const blockMask = 0xfc000000
+
+// random values for each byte, for the rolling hash
+var gearTable = func() (t [256]uint32) {
+	x := uint32(2463534242)
+	for i := range t {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		t[i] = x
+	}
+	return t
+}()
+
+// SplitBlocks splits binary data into blocks, to be compared like the lines of a text file.
+// The end of each block is chosen from the bytes before it (content defined chunking),
+// so inserting or removing bytes only changes the blocks around them,
+// the following blocks are the same in both files.
+func SplitBlocks(data []byte) [][]byte {
+
+	blocks := make([][]byte, 0, len(data)/64+1)
+	var h uint32
+	start := 0
+
+	for i, b := range data {
+		// only the last 32 bytes are part of the hash, older bytes are shifted out
+		h = h<<1 + gearTable[b]
+		n := i + 1 - start
+		if (n >= MinBlockSize && h&blockMask == 0) || n >= MaxBlockSize {
+			blocks = append(blocks, data[start:i+1])
+			start = i + 1
+		}
+	}
+
+	if start < len(data) {
+		blocks = append(blocks, data[start:])
+	}
+	return blocks
+}
@@ -0,0 +1,96 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// The blocks must cover the data, and their size must be within the limits
+func checkBlocks(t *testing.T, name string, data []byte) [][]byte {
+	t.Helper()
+
+	blocks := SplitBlocks(data)
+	if got := bytes.Join(blocks, nil); !bytes.Equal(got, data) {
+		t.Fatalf("%s: blocks do not add up to the data", name)
+	}
+	for i, block := range blocks {
+		if len(block) > MaxBlockSize || len(block) == 0 || (len(block) < MinBlockSize && i < len(blocks)-1) {
+			t.Fatalf("%s: block %d of %d has %d bytes", name, i, len(blocks), len(block))
+		}
+	}
+	return blocks
+}
+
+func TestSplitBlocks(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	r.Read(random)
+
+	if blocks := checkBlocks(t, "empty", nil); len(blocks) != 0 {
+		t.Errorf("empty: got %d blocks", len(blocks))
+	}
+	if blocks := checkBlocks(t, "short", []byte("abc")); len(blocks) != 1 {
+		t.Errorf("short: got %d blocks, want 1", len(blocks))
+	}
+	checkBlocks(t, "zeros", make([]byte, 10000))
+	checkBlocks(t, "text", bytes.Repeat([]byte("a line of text\n"), 1000))
+
+	blocks := checkBlocks(t, "random", random)
+	if n := len(random) / len(blocks); n < 32 || n > 128 {
+		t.Errorf("random: average block size %d, want about 64", n)
+	}
+}
+
+// Inserting or removing bytes only changes the blocks around them
+func TestSplitBlocksChange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		data1 := make([]byte, 1000+r.Intn(10000))
+		r.Read(data1)
+
+		pos := r.Intn(len(data1))
+		data2 := append([]byte(nil), data1[:pos]...)
+		if i%2 == 0 {
+			insert := make([]byte, 1+r.Intn(20))
+			r.Read(insert)
+			data2 = append(append(data2, insert...), data1[pos:]...)
+		} else {
+			data2 = append(data2, data1[minInt(pos+1+r.Intn(20), len(data1)):]...)
+		}
+
+		blocks1, blocks2 := checkBlocks(t, "data1", data1), checkBlocks(t, "data2", data2)
+		removed, added := 0, 0
+		info1, info2 := Compare(blocks1, blocks2, &Options{})
+		for _, c := range info1.Change {
+			if c {
+				removed++
+			}
+		}
+		for _, c := range info2.Change {
+			if c {
+				added++
+			}
+		}
+		// usually one or two blocks each, a boundary skipped for MinBlockSize can move the next ones
+		if removed+added > 8 {
+			t.Errorf("change at %d of %d bytes: %d blocks removed and %d added, of %d and %d", pos, len(data1), removed, added, len(blocks1), len(blocks2))
+		}
+	}
+}
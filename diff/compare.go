@@ -0,0 +1,409 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"bytes"
+	"hash/crc32"
+	"unicode"
+	"unicode/utf8"
+)
+
+// functions to compare line and computer hash values,
+// these are chosen based on the options: IgnoreCase, IgnoreSpaceChange etc.
+// With Substitutions, the lines are compared after the replacements.
+func (opts *Options) lineFuncs() (func([]byte, []byte) bool, func([]byte) uint32) {
+	compareLine, computeHash := opts.compareFuncs()
+	if len(opts.Substitutions) == 0 {
+		return compareLine, computeHash
+	}
+	substCompareLine := func(line1, line2 []byte) bool {
+		return compareLine(opts.substitute(line1), opts.substitute(line2))
+	}
+	substComputeHash := func(line []byte) uint32 {
+		return computeHash(opts.substitute(line))
+	}
+	return substCompareLine, substComputeHash
+}
+
+// Apply the Substitutions to the line. The line is returned as is if nothing matches.
+func (opts *Options) substitute(line []byte) []byte {
+	for _, s := range opts.Substitutions {
+		if s.Pattern.Match(line) {
+			line = s.Pattern.ReplaceAll(line, s.Replacement)
+		}
+	}
+	return line
+}
+
+// functions to compare line and computer hash values, without the Substitutions
+func (opts *Options) compareFuncs() (func([]byte, []byte) bool, func([]byte) uint32) {
+	if opts.IgnoreCase || opts.IgnoreSpaceChange || opts.IgnoreAllSpace {
+		if opts.UnicodeCaseAndSpace {
+			return opts.compareLineUnicode, opts.computeHashUnicode
+		}
+		return opts.compareLineBytes, opts.computeHashBytes
+	}
+	return bytes.Equal, computeHashExact
+}
+
+// Equal reports whether the two sets of lines are the same according to opts,
+// without running the diff algorithm. Blank lines are skipped when IgnoreBlankLines is set.
+// With IgnoreMatching, the diff is needed to find the changes where all lines match.
+func Equal(lines1, lines2 [][]byte, opts *Options) bool {
+
+	if len(opts.IgnoreMatching) > 0 {
+		info1, info2 := Compare(lines1, lines2, opts)
+		return !ReportDiff(DiffChangerFunc(func([]DiffOp) {}), info1.Ids, info2.Ids, info1.Change, info2.Change, opts)
+	}
+
+	compareLine, _ := opts.lineFuncs()
+	ignore := func(line []byte) bool {
+		return opts.IgnoreBlankLines && compareLine(line, blankLine)
+	}
+
+	i1, i2 := 0, 0
+	for {
+		for i1 < len(lines1) && ignore(lines1[i1]) {
+			i1++
+		}
+		for i2 < len(lines2) && ignore(lines2[i2]) {
+			i2++
+		}
+		if i1 == len(lines1) || i2 == len(lines2) {
+			return i1 == len(lines1) && i2 == len(lines2)
+		}
+		if !compareLine(lines1[i1], lines2[i2]) {
+			return false
+		}
+		i1++
+		i2++
+	}
+}
+
+// convert byte to lower case
+func toLowerByte(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b - 'A' + 'a'
+	}
+	return b
+}
+
+// Test for space character
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\v' || b == '\f'
+}
+
+func skipSpaceRune(line []byte, i int) int {
+	for i < len(line) {
+		b, size := utf8.DecodeRune(line[i:])
+		if !unicode.IsSpace(b) {
+			return i
+		}
+		i += size
+	}
+	return i
+}
+
+// Get the next rune, and skip spaces after it
+func getNextRuneNonspace(line []byte, i int) (rune, int) {
+	b, size := utf8.DecodeRune(line[i:])
+	return b, skipSpaceRune(line, i+size)
+}
+
+// Get the next rune, and determine if there is a space after it.
+// Also ignore trailing spaces at end-of-line
+func getNextRuneXspace(line []byte, i int) (rune, bool, int) {
+	b, size := utf8.DecodeRune(line[i:])
+	i += size
+	spaceAfter := false
+	for i < len(line) {
+		s, size := utf8.DecodeRune(line[i:])
+		if !unicode.IsSpace(s) {
+			break
+		}
+		spaceAfter = true
+		i += size
+	}
+	if spaceAfter && i >= len(line) {
+		spaceAfter = false
+	}
+	return b, spaceAfter, i
+}
+
+func skipSpaceByte(line []byte, i int) int {
+	for i < len(line) {
+		if !isSpace(line[i]) {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+func getNextByteNonSpace(line []byte, i int) (byte, int) {
+	return line[i], skipSpaceByte(line, i+1)
+}
+
+func getNextByteXSpace(line []byte, i int) (byte, bool, int) {
+	b, i := line[i], i+1
+	spaceAfter := false
+	for i < len(line) {
+		if !isSpace(line[i]) {
+			break
+		}
+		spaceAfter = true
+		i++
+	}
+	if spaceAfter && i >= len(line) {
+		spaceAfter = false
+	}
+	return b, spaceAfter, i
+}
+
+func (opts *Options) compareLineBytes(line1, line2 []byte) bool {
+	len1, len2 := len(line1), len(line2)
+	var i, j int
+	var v1, v2 byte
+	switch {
+	case opts.IgnoreAllSpace:
+		i = skipSpaceByte(line1, 0)
+		j = skipSpaceByte(line2, 0)
+		for i < len1 && j < len2 {
+			v1, i = getNextByteNonSpace(line1, i)
+			v2, j = getNextByteNonSpace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = toLowerByte(v1), toLowerByte(v2)
+			}
+			if v1 != v2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreSpaceChange:
+		var spaceAfter1, spaceAfter2 bool
+		i = skipSpaceByte(line1, 0)
+		j = skipSpaceByte(line2, 0)
+		for i < len1 && j < len2 {
+			v1, spaceAfter1, i = getNextByteXSpace(line1, i)
+			v2, spaceAfter2, j = getNextByteXSpace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = toLowerByte(v1), toLowerByte(v2)
+			}
+			if v1 != v2 || spaceAfter1 != spaceAfter2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreCase:
+		if len1 != len2 {
+			return false
+		}
+		for i < len1 && j < len2 {
+			if toLowerByte(line1[i]) != toLowerByte(line2[j]) {
+				return false
+			}
+			i, j = i+1, j+1
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts *Options) compareLineUnicode(line1, line2 []byte) bool {
+	len1, len2 := len(line1), len(line2)
+	var i, j int
+	var v1, v2 rune
+	var size1, size2 int
+	switch {
+	case opts.IgnoreAllSpace:
+		i = skipSpaceRune(line1, 0)
+		j = skipSpaceRune(line2, 0)
+		for i < len1 && j < len2 {
+			v1, i = getNextRuneNonspace(line1, i)
+			v2, j = getNextRuneNonspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
+			}
+			if v1 != v2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreSpaceChange:
+		i = skipSpaceRune(line1, 0)
+		j = skipSpaceRune(line2, 0)
+		var spaceAfter1, spaceAfter2 bool
+		for i < len1 && j < len2 {
+			v1, spaceAfter1, i = getNextRuneXspace(line1, i)
+			v2, spaceAfter2, j = getNextRuneXspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
+			}
+			if v1 != v2 || spaceAfter1 != spaceAfter2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreCase:
+		if len1 != len2 {
+			return false
+		}
+		for i < len1 && j < len2 {
+			v1, size1 = utf8.DecodeRune(line1[i:])
+			v2, size2 = utf8.DecodeRune(line2[j:])
+			if v1 != v2 && unicode.ToLower(v1) != unicode.ToLower(v2) {
+				return false
+			}
+			i, j = i+size1, j+size2
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+	}
+	return true
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func hash32(h uint32, b byte) uint32 {
+	return crcTable[byte(h)^b] ^ (h >> 8)
+}
+
+func hash32Unicode(h uint32, r rune) uint32 {
+	for r != 0 {
+		h = hash32(h, byte(r))
+		r = r >> 8
+	}
+	return h
+}
+
+func computeHashExact(data []byte) uint32 {
+	// On amd64, this will be using the SSE4.2 hardware instructions, much faster!
+	return crc32.Update(0, crcTable, data)
+}
+
+func (opts *Options) computeHashBytes(line1 []byte) uint32 {
+	var hash uint32
+	switch {
+	case opts.IgnoreAllSpace:
+		for _, v1 := range line1 {
+			if !isSpace(v1) {
+				if opts.IgnoreCase {
+					v1 = toLowerByte(v1)
+				}
+				hash = hash32(hash, v1)
+			}
+		}
+
+	case opts.IgnoreSpaceChange:
+		lastHash := hash
+		lastSpace := true
+		for _, v1 := range line1 {
+			if isSpace(v1) {
+				if !lastSpace {
+					lastHash = hash
+					hash = hash32(hash, ' ')
+				}
+				lastSpace = true
+			} else {
+				if opts.IgnoreCase {
+					v1 = toLowerByte(v1)
+				}
+				hash = hash32(hash, v1)
+				lastSpace = false
+			}
+		}
+		if lastSpace {
+			hash = lastHash
+		}
+
+	case opts.IgnoreCase:
+		for _, v1 := range line1 {
+			v1 = toLowerByte(v1)
+			hash = hash32(hash, v1)
+		}
+
+	}
+	return hash
+}
+
+func (opts *Options) computeHashUnicode(line1 []byte) uint32 {
+	var hash uint32
+	i, len1 := 0, len(line1)
+
+	switch {
+	case opts.IgnoreAllSpace:
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i = i + size
+			if !unicode.IsSpace(v1) {
+				if opts.IgnoreCase {
+					v1 = unicode.ToLower(v1)
+				}
+				hash = hash32Unicode(hash, v1)
+			}
+		}
+
+	case opts.IgnoreSpaceChange:
+		lastHash := hash
+		lastSpace := true
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i += size
+			if unicode.IsSpace(v1) {
+				if !lastSpace {
+					lastHash = hash
+					hash = hash32(hash, ' ')
+				}
+				lastSpace = true
+			} else {
+				if opts.IgnoreCase {
+					v1 = unicode.ToLower(v1)
+				}
+				hash = hash32Unicode(hash, v1)
+				lastSpace = false
+			}
+		}
+		if lastSpace {
+			hash = lastHash
+		}
+
+	case opts.IgnoreCase:
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i = i + size
+			v1 = unicode.ToLower(v1)
+			hash = hash32Unicode(hash, v1)
+		}
+	}
+	return hash
+}
@@ -0,0 +1,387 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package diff implements the line and character comparison engine used by godiff.
+//
+// It uses the algorithm from "An O(ND) Difference Algorithm and its Variations"
+// by Eugene Myers Algorithmic Vol. 1 No. 2, 1986, p 251.
+//
+// The simplest entry point is Diff, which compares two sets of lines and
+// returns the groups of changes (hunks):
+//
+//	hunks := diff.Diff(lines1, lines2, &diff.Options{ContextLines: 3})
+//	for _, hunk := range hunks {
+//		for _, op := range hunk {
+//			// op.Op is one of DiffOpSame, DiffOpModify, DiffOpInsert, DiffOpRemove
+//			// lines1[op.Start1:op.End1] and lines2[op.Start2:op.End2] are affected
+//		}
+//	}
+//
+// Compare and ReportDiff can be used instead when the changes should be
+// streamed to a DiffChanger as they are found.
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultContextLines default number of context lines to display
+const DefaultContextLines = 3
+
+// Options controls how lines are compared and how changes are grouped.
+// The zero value compares lines exactly and reports changes without context lines.
+// Options are only read during a comparison, differently configured diffs can run concurrently.
+type Options struct {
+	IgnoreCase          bool // Ignore case differences
+	IgnoreBlankLines    bool // Ignore changes whose lines are all blank
+	IgnoreSpaceChange   bool // Ignore changes in the amount of white space
+	IgnoreAllSpace      bool // Ignore all white space
+	UnicodeCaseAndSpace bool // Apply unicode rules for white space and upper/lower case
+	ContextLines        int  // Include N lines of context before and after changes
+	Algorithm           int  // Diff algorithm used to compare lines: AlgorithmMyers, AlgorithmPatience or AlgorithmHistogram
+	Minimal             bool // Always find the minimal differences, no matter how expensive it is
+	CostLimit           int  // Stop searching for the minimal differences after this many edits, 0 to choose from the size of the files
+
+	// Ignore changes whose lines all match one of these regular expressions, same as diff -I.
+	// The lines are compared as usual, only the changes where all lines match are ignored.
+	IgnoreMatching []*regexp.Regexp
+
+	// Replace the text matching the patterns before comparing lines, eg. to ignore ids or addresses.
+	// The lines are only changed for the comparison.
+	Substitutions []Substitution
+}
+
+// Substitution replaces the text matching Pattern with Replacement, which can refer to the groups
+// of the pattern as in regexp.Expand, eg. $1.
+type Substitution struct {
+	Pattern     *regexp.Regexp
+	Replacement []byte
+}
+
+// MinCostLimit the cost limit chosen from the size of the files is never smaller than this
+const MinCostLimit = 4096
+
+// Diff algorithms
+const (
+	AlgorithmMyers     = 0 // minimal differences, "An O(ND) Difference Algorithm and its Variations"
+	AlgorithmPatience  = 1 // align on lines that are unique in both files, same as git diff --patience
+	AlgorithmHistogram = 2 // align on the least common lines, same as git diff --histogram
+)
+
+// AlgorithmNames names of the diff algorithms, as used on the command line
+var AlgorithmNames = map[string]int{
+	"myers":     AlgorithmMyers,
+	"patience":  AlgorithmPatience,
+	"histogram": AlgorithmHistogram,
+}
+
+// ParseAlgorithm returns the diff algorithm with this name
+func ParseAlgorithm(name string) (int, error) {
+	if algorithm, ok := AlgorithmNames[name]; ok {
+		return algorithm, nil
+	}
+	return AlgorithmMyers, fmt.Errorf("unknown diff algorithm: %s", name)
+}
+
+// Kind of change for a DiffOp
+const (
+	DiffOpSame   = 1
+	DiffOpModify = 2
+	DiffOpInsert = 3
+	DiffOpRemove = 4
+)
+
+// DiffOp a range of lines in both files, and how they have changed.
+// Lines are numbered from 0, the End positions are exclusive.
+type DiffOp struct {
+	Op           int
+	Start1, End1 int
+	Start2, End2 int
+}
+
+// DiffChanger Interface for ReportDiff() callbacks.
+// DiffLines is called once for each group of changes, the ops slice is reused after it returns.
+type DiffChanger interface {
+	DiffLines(ops []DiffOp)
+}
+
+// DiffChangerFunc allow the use of an ordinary function as a DiffChanger
+type DiffChangerFunc func(ops []DiffOp)
+
+// DiffLines calls f(ops)
+func (f DiffChangerFunc) DiffLines(ops []DiffOp) {
+	f(ops)
+}
+
+// Diff compares two sets of lines and returns each group of changes (hunk),
+// including the context lines requested in opts.
+func Diff(lines1, lines2 [][]byte, opts *Options) [][]DiffOp {
+	info1, info2 := Compare(lines1, lines2, opts)
+
+	var hunks [][]DiffOp
+	ReportDiff(DiffChangerFunc(func(ops []DiffOp) {
+		hunks = append(hunks, append([]DiffOp(nil), ops...))
+	}), info1.Ids, info2.Ids, info1.Change, info2.Change, opts)
+
+	return hunks
+}
+
+// Compare computes the equivalent ids for each line, then run the diff algorithm
+// to find out which lines have changed.
+func Compare(lines1, lines2 [][]byte, opts *Options) (*LinesData, *LinesData) {
+
+	// Compute equiv ids for each line.
+	info1, info2 := FindEquivLines(lines1, lines2, opts)
+
+	diffEquivLines(info1, info2, opts)
+	IgnoreChanges(info1, info2)
+
+	return info1, info2
+}
+
+// Run the diff algorithm on lines already converted to equivalent ids
+func diffEquivLines(info1, info2 *LinesData, opts *Options) {
+
+	// No zidS available, no need to run diff comparison algorithm
+	// The FindEquivLines() function may have performed the comparison already.
+	if info1.zidS != nil && info2.zidS != nil {
+		// run the diff algorithm
+		zChange1, zChange2 := doDiff(info1.zidS, info2.zidS, opts.Algorithm, opts.costLimit(len(info1.zidS), len(info2.zidS)))
+
+		// expand the change list, so that change array contains changes to actual lines
+		expandChangeList(info1, info2, zChange1, zChange2)
+	}
+
+	// perform shift boundary
+	ShiftBoundaries(info1.Ids, info1.Change, nil)
+	ShiftBoundaries(info2.Ids, info2.Change, nil)
+}
+
+// DoDiff Call the diff algorithm.
+// Returns a list for each input, indicating which entries have been changed.
+func DoDiff(data1, data2 []int) ([]bool, []bool) {
+	return doDiff(data1, data2, AlgorithmMyers, 0)
+}
+
+// Maximum number of edits to search for the minimal differences, 0 for no limit.
+// Same as gnu diff, the limit is about the square root of the size of the files.
+func (opts *Options) costLimit(len1, len2 int) int {
+	if opts.Minimal {
+		return 0
+	}
+	if opts.CostLimit > 0 {
+		return opts.CostLimit
+	}
+	limit := 1
+	for diags := len1 + len2 + 3; diags != 0; diags >>= 2 {
+		limit <<= 1
+	}
+	return maxInt(limit, MinCostLimit)
+}
+
+func doDiff(data1, data2 []int, algorithm, costLimit int) ([]bool, []bool) {
+	len1, len2 := len(data1), len(data2)
+	change1, change2 := make([]bool, len1), make([]bool, len2)
+
+	size := (len1+len2+1)*2 + 2
+	v := make([]int, size*2)
+
+	// Run diff compare algorithm.
+	switch algorithm {
+	case AlgorithmPatience:
+		algorithmPatience(data1, data2, change1, change2, v, costLimit)
+	case AlgorithmHistogram:
+		algorithmHistogram(data1, data2, change1, change2, v, costLimit)
+	default:
+		algorithmLcs(data1, data2, change1, change2, v, costLimit)
+	}
+
+	return change1, change2
+}
+
+// Find the beginning/end of this 'changed' segment
+func nextChangeSegment(start int, change []bool, data []int) (int, int, int) {
+
+	// find the end of this changes segment
+	end := start + 1
+	for end < len(change) && change[end] {
+		end++
+	}
+
+	// skip blank lines in the begining and end of the changes
+	i, j := start, end
+	for i < end && data[i] == 0 {
+		i++
+	}
+	for j > i && data[j-1] == 0 {
+		j--
+	}
+
+	return end, i, j
+}
+
+// Add segment to the group of changes. Add context lines before and after if necessary
+func addChangeSegment(chg DiffChanger, ops []DiffOp, op DiffOp, contextLines int) []DiffOp {
+	last1, last2 := 0, 0
+	if len(ops) > 0 {
+		lastOp := ops[len(ops)-1]
+		last1, last2 = lastOp.End1, lastOp.End2
+	}
+
+	gap1, gap2 := op.Start1-last1, op.Start2-last2
+	if len(ops) > 0 && (op.Op == 0 || (gap1 > contextLines*2 && gap2 > contextLines*2)) {
+		e1, e2 := minInt(op.Start1, last1+contextLines), minInt(op.Start2, last2+contextLines)
+		if e1 > last1 || e2 > last2 {
+			ops = append(ops, DiffOp{DiffOpSame, last1, e1, last2, e2})
+		}
+		chg.DiffLines(ops)
+		ops = ops[:0]
+	}
+
+	// in the same group of changes, include all the lines in between
+	c1, c2 := maxInt(last1, op.Start1-contextLines), maxInt(last2, op.Start2-contextLines)
+	if len(ops) > 0 {
+		c1, c2 = last1, last2
+	}
+	if c1 < op.Start1 || c2 < op.Start2 {
+		ops = append(ops, DiffOp{DiffOpSame, c1, op.Start1, c2, op.Start2})
+	}
+
+	if op.Op != 0 {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// ReportDiff Report diff changes.
+// For each group of change, call the DiffLines() function.
+// Returns true if there are any changes.
+func ReportDiff(chg DiffChanger, data1, data2 []int, change1, change2 []bool, opts *Options) bool {
+	len1, len2 := len(change1), len(change2)
+	i1, i2 := 0, 0
+	ops := make([]DiffOp, 0, 16)
+	changed := false
+	contextLines := opts.ContextLines
+	var m1start, m1end, m2start, m2end int
+
+	// scan for changes
+	for i1 < len1 || i2 < len2 {
+		switch {
+		// no change, advance both i1 and i2 to next set of changes
+		case i1 < len1 && i2 < len2 && !change1[i1] && !change2[i2]:
+			i1++
+			i2++
+
+		// change in both lists
+		case i1 < len1 && i2 < len2 && change1[i1] && change2[i2]:
+			i1, m1start, m1end = nextChangeSegment(i1, change1, data1)
+			i2, m2start, m2end = nextChangeSegment(i2, change2, data2)
+
+			opMode := 0
+			switch {
+			case m1start < m1end && m2start < m2end:
+				opMode = DiffOpModify
+			case m1start < m1end:
+				opMode = DiffOpRemove
+			case m2start < m2end:
+				opMode = DiffOpInsert
+			}
+			if opMode != 0 {
+				ops = addChangeSegment(chg, ops, DiffOp{opMode, m1start, m1end, m2start, m2end}, contextLines)
+				changed = true
+			}
+
+		case i1 < len1 && change1[i1]:
+			i1, m1start, m1end = nextChangeSegment(i1, change1, data1)
+			if m1start < m1end {
+				ops = addChangeSegment(chg, ops, DiffOp{DiffOpRemove, m1start, m1end, i2, i2}, contextLines)
+				changed = true
+			}
+
+		case i2 < len2 && change2[i2]:
+			i2, m2start, m2end = nextChangeSegment(i2, change2, data2)
+			if m2start < m2end {
+				ops = addChangeSegment(chg, ops, DiffOp{DiffOpInsert, i1, i1, m2start, m2end}, contextLines)
+				changed = true
+			}
+
+		default: // should not reach here
+			return true
+		}
+	}
+	if len(ops) > 0 {
+		addChangeSegment(chg, ops, DiffOp{0, len1, len1, len2, len2}, contextLines)
+	}
+	return changed
+}
+
+// SplitRunes split text into array of individual rune position, and another array for comparison.
+// Use with DoDiff() to find the changes within a line.
+func SplitRunes(s []byte, opts *Options) ([]int, []int) {
+
+	pos := make([]int, len(s)+1)
+	cmp := make([]int, len(s))
+
+	var h, i, n int
+
+	for i < len(s) {
+		pos[n] = i
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if opts.IgnoreCase {
+				if opts.UnicodeCaseAndSpace {
+					h = int(unicode.ToLower(rune(b)))
+				} else {
+					h = int(toLowerByte(b))
+				}
+			} else {
+				h = int(b)
+			}
+			i++
+		} else {
+			r, rSize := utf8.DecodeRune(s[i:])
+			if opts.IgnoreCase && opts.UnicodeCaseAndSpace {
+				h = int(unicode.ToLower(r))
+			} else {
+				h = int(r)
+			}
+			i += rSize
+		}
+		cmp[n] = h
+		n = n + 1
+	}
+	pos[n] = i
+	return pos[:n+1], cmp[:n]
+}
+
+// shortcut functions. hopefully will be inlined by compiler
+func maxInt(a, b int) int {
+	if a < b {
+		return b
+	}
+	return a
+}
+
+// shortcut functions. hopefully will be inlined by compiler
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,257 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Split the words of s into lines
+func testLines(s string) [][]byte {
+	var lines [][]byte
+	for _, word := range strings.Fields(s) {
+		lines = append(lines, []byte(word))
+	}
+	return lines
+}
+
+// Edit script of the changes, "=a" for a line in both files, "-a" for a removed line and "+a" for an inserted line
+func editScript(lines1, lines2 [][]byte, opts *Options) string {
+	var script []string
+	add := func(prefix string, lines [][]byte) {
+		for _, line := range lines {
+			script = append(script, prefix+string(line))
+		}
+	}
+
+	for _, hunk := range Diff(lines1, lines2, opts) {
+		for _, op := range hunk {
+			if op.Op == DiffOpSame {
+				add("=", lines1[op.Start1:op.End1])
+			} else {
+				add("-", lines1[op.Start1:op.End1])
+				add("+", lines2[op.Start2:op.End2])
+			}
+		}
+	}
+	return strings.Join(script, " ")
+}
+
+// The edit scripts are the same as gnu diff, when there are several with the same number of changes
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name         string
+		file1, file2 string
+		want         string
+	}{
+		{
+			name:  "identical",
+			file1: "a b c", file2: "a b c",
+		},
+		{
+			name:  "empty file1",
+			file1: "", file2: "a b",
+			want: "+a +b",
+		},
+		{
+			name:  "empty file2",
+			file1: "a b", file2: "",
+			want: "-a -b",
+		},
+		{
+			name:  "remove and insert",
+			file1: "a b c d e", file2: "a c d x e",
+			want: "=a -b =c =d +x =e",
+		},
+		{
+			// example from the paper
+			name:  "myers paper",
+			file1: "a b c a b b a", file2: "c b a b a c",
+			want: "-a -b =c -a =b +a =b =a +c",
+		},
+		{
+			name:  "inserted block",
+			file1: "f1 { x1 } f2 { x2 }", file2: "f1 { x1 } fn { xn } f2 { x2 }",
+			want: "=f1 ={ =x1 =} +fn +{ +xn +} =f2 ={ =x2 =}",
+		},
+		{
+			name:  "common lines",
+			file1: "x a b c x", file2: "a x b x c",
+			want: "-x =a +x =b -c =x +c",
+		},
+		{
+			name:  "swapped blocks",
+			file1: "a b x c d", file2: "c d x a b",
+			want: "-a -b -x =c =d +x +a +b",
+		},
+	}
+
+	for _, test := range tests {
+		lines1, lines2 := testLines(test.file1), testLines(test.file2)
+		opts := &Options{ContextLines: len(lines1) + len(lines2)}
+		if got := editScript(lines1, lines2, opts); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Only the changes where all lines match are ignored, same as diff -I
+func TestIgnoreMatching(t *testing.T) {
+	tests := []struct {
+		file1, file2 string
+		want         string
+	}{
+		{"a D1 b", "a D2 b", ""},
+		{"a D1 b", "a b", ""},
+		{"a D1 b c", "a D2 B c", "=a -D1 -b +D2 +B =c"},
+		{"x D1", "D1 x", "-x =D1 +x"},
+		{"D1 D2 x", "D2 D1 x", ""},
+	}
+
+	opts := &Options{ContextLines: 10, IgnoreMatching: []*regexp.Regexp{regexp.MustCompile("^D")}}
+	for _, test := range tests {
+		lines1, lines2 := testLines(test.file1), testLines(test.file2)
+		if got := editScript(lines1, lines2, opts); got != test.want {
+			t.Errorf("%q %q: got %q, want %q", test.file1, test.file2, got, test.want)
+		}
+		if got := Equal(lines1, lines2, opts); got != (test.want == "") {
+			t.Errorf("%q %q: Equal is %v", test.file1, test.file2, got)
+		}
+	}
+}
+
+// The lines are compared after the substitutions, and the changes keep the original lines
+func TestSubstitutions(t *testing.T) {
+	tests := []struct {
+		file1, file2 string
+		opts         Options
+		want         string
+	}{
+		{"a id=1 b", "a id=22 b", Options{}, ""},
+		{"a id=1 b", "a id=2 c", Options{}, "=a =id=1 -b +c"},
+		{"a id=1 b", "a ID=2 b", Options{}, "=a -id=1 +ID=2 =b"},
+		{"a id=1 B", "a id=2 b", Options{IgnoreCase: true}, ""},
+		{"x=1 y=1", "x=1 y=2", Options{}, "=x=1 -y=1 +y=2"},
+		{"0x1f 0x2a", "0xff 0x2a", Options{}, ""},
+	}
+
+	for _, test := range tests {
+		opts := test.opts
+		opts.ContextLines = 10
+		opts.Substitutions = []Substitution{
+			{Pattern: regexp.MustCompile("id=[0-9]+"), Replacement: []byte("id=N")},
+			{Pattern: regexp.MustCompile("^0x[0-9a-f]+$"), Replacement: []byte("ADDR")},
+			{Pattern: regexp.MustCompile("^x=([0-9])$"), Replacement: []byte("$1")},
+		}
+		lines1, lines2 := testLines(test.file1), testLines(test.file2)
+		if got := editScript(lines1, lines2, &opts); got != test.want {
+			t.Errorf("%q %q: got %q, want %q", test.file1, test.file2, got, test.want)
+		}
+		if got := Equal(lines1, lines2, &opts); got != (test.want == "") {
+			t.Errorf("%q %q: Equal is %v", test.file1, test.file2, got)
+		}
+	}
+}
+
+// Random lines, using only a few different lines so that there are many matches
+func randomLines(r *rand.Rand, words string) [][]byte {
+	lines := make([][]byte, r.Intn(30))
+	for i := range lines {
+		n := r.Intn(len(words))
+		lines[i] = []byte(words[n : n+1])
+	}
+	return lines
+}
+
+// Length of the longest common subsequence of lines
+func lcsLength(lines1, lines2 [][]byte) int {
+	lcs := make([][]int, len(lines1)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lines2)+1)
+	}
+	for i := len(lines1) - 1; i >= 0; i-- {
+		for j := len(lines2) - 1; j >= 0; j-- {
+			if string(lines1[i]) == string(lines2[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = maxInt(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+// Apply the changes found with opts to lines1, the result must be lines2.
+// Returns the number of lines removed and added.
+func checkRoundTrip(t *testing.T, lines1, lines2 [][]byte, opts *Options) (int, int) {
+	t.Helper()
+
+	var result [][]byte
+	last1, last2, removed, added := 0, 0, 0, 0
+	for _, hunk := range Diff(lines1, lines2, opts) {
+		for _, op := range hunk {
+			if op.Start1-last1 != op.Start2-last2 {
+				t.Fatalf("%q %q: op %v does not follow the previous op", lines1, lines2, op)
+			}
+			result = append(result, lines1[last1:op.Start1]...)
+			switch op.Op {
+			case DiffOpSame:
+				result = append(result, lines1[op.Start1:op.End1]...)
+			case DiffOpModify, DiffOpInsert, DiffOpRemove:
+				result = append(result, lines2[op.Start2:op.End2]...)
+				removed += op.End1 - op.Start1
+				added += op.End2 - op.Start2
+			}
+			last1, last2 = op.End1, op.End2
+		}
+	}
+	result = append(result, lines1[last1:]...)
+
+	if got, want := string(joinLines(result)), string(joinLines(lines2)); got != want {
+		t.Fatalf("%q %q, algorithm %d, context %d: got %q", lines1, lines2, opts.Algorithm, opts.ContextLines, got)
+	}
+	return removed, added
+}
+
+// Applying the changes to file1 must give file2, for any number of context lines
+func TestDiffRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 500; n++ {
+		lines1, lines2 := randomLines(r, "abcde"), randomLines(r, "abcdef")
+		for _, contextLines := range []int{0, 1, 3} {
+			removed, added := checkRoundTrip(t, lines1, lines2, &Options{ContextLines: contextLines, Minimal: true})
+
+			// myers finds the minimal differences
+			if lcs := lcsLength(lines1, lines2); removed != len(lines1)-lcs || added != len(lines2)-lcs {
+				t.Errorf("%q %q: %d removed and %d added, want %d and %d", lines1, lines2, removed, added, len(lines1)-lcs, len(lines2)-lcs)
+			}
+		}
+	}
+}
+
+func joinLines(lines [][]byte) []byte {
+	var b []byte
+	for _, line := range lines {
+		b = append(b, line...)
+		b = append(b, '\n')
+	}
+	return b
+}
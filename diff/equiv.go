@@ -0,0 +1,380 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+var blankLine = make([]byte, 0)
+
+// EquivClass lines that are considered identical share the same id
+type EquivClass struct {
+	id   int
+	hash uint32
+	line *[]byte
+	next *EquivClass
+}
+
+// LinesData ids and changes for each line of a file
+type LinesData struct {
+	Ids       []int  // Ids for each line, id=0 for blank lines when IgnoreBlankLines is set
+	Change    []bool // Lines that have been changed
+	Ignore    []bool // Lines matching IgnoreMatching, nil when there are no IgnoreMatching patterns
+	zidS      []int  // list of ids with unmatched lines replaced by a single entry (and blank lines removed)
+	zCount    []int  // Number of lines that represent each zidS entry
+	zidsStart int
+	zidsEnd   int
+}
+
+// IgnoreLine reports whether the line matches one of the IgnoreMatching regular expressions
+func (opts *Options) IgnoreLine(line []byte) bool {
+	for _, re := range opts.IgnoreMatching {
+		if re.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindEquivLines Compute id's that represent the original lines, these numeric id's are use for faster line comparison.
+func FindEquivLines(lines1, lines2 [][]byte, opts *Options) (*LinesData, *LinesData) {
+
+	compareLine, computeHash := opts.lineFuncs()
+
+	info1 := LinesData{
+		Ids:    make([]int, len(lines1)),
+		Change: make([]bool, len(lines1)),
+	}
+
+	info2 := LinesData{
+		Ids:    make([]int, len(lines2)),
+		Change: make([]bool, len(lines2)),
+	}
+
+	// since we already have a hashing function, it's faster to use arrays than to use go's builtin map
+	// Use bucket size that is power of 2
+	buckets := 1 << 9
+	for buckets < (len(lines1)+len(lines2))*2 {
+		buckets = buckets << 1
+	}
+
+	// create the slice we are using for hash tables
+	eqHash := make([]*EquivClass, buckets)
+
+	// Use id=0 for blank lines.
+	// Later in ReportDiff(), do not report changes on chunks of lines with id=0
+	if opts.IgnoreBlankLines {
+		hashcode := computeHash(blankLine)
+		iHash := int(hashcode) & (buckets - 1)
+		eqHash[iHash] = &EquivClass{id: 0, line: &blankLine, hash: hashcode}
+	}
+
+	// lines matching IgnoreMatching still have their own ids, so that they are only
+	// the same as identical lines. Changes are ignored after the diff, see IgnoreChanges.
+	if len(opts.IgnoreMatching) > 0 {
+		info1.Ignore = ignoreLines(lines1, opts)
+		info2.Ignore = ignoreLines(lines2, opts)
+	}
+
+	// the unique id for identical lines, start with 1.
+	var maxIdF1, maxIdF2 int
+	nextId := 1
+
+	// process both sets of lines
+	for findEx := 0; findEx < 2; findEx++ {
+		var lines [][]byte
+		var ids []int
+
+		if findEx == 0 {
+			lines = lines1
+			ids = info1.Ids
+		} else {
+			lines = lines2
+			ids = info2.Ids
+		}
+
+		for i := 0; i < len(lines); i++ {
+			lPtr := &lines[i]
+			// find current line in eqHash
+			hashcode := computeHash(*lPtr)
+			iHash := int(hashcode) & (buckets - 1)
+			eq := eqHash[iHash]
+			if eq == nil {
+				// not found in eqHash, create new entry
+				ids[i] = nextId
+				eqHash[iHash] = &EquivClass{id: nextId, line: lPtr, hash: hashcode}
+				nextId++
+			} else if eq.hash == hashcode && compareLine(*lPtr, *eq.line) {
+				// found, and line is the same. reuse same id
+				ids[i] = eq.id
+			} else {
+				// hash-collision. look through link-list for same match
+				n := eq.next
+				for n != nil {
+					if n.hash == hashcode && compareLine(*lPtr, *n.line) {
+						ids[i] = n.id
+						break
+					}
+					n = n.next
+				}
+				// new entry, link to start of linked-list
+				if n == nil {
+					ids[i] = nextId
+					eq.next = &EquivClass{id: nextId, line: lPtr, hash: hashcode, next: eq.next}
+					nextId++
+				}
+			}
+		}
+
+		if findEx == 0 {
+			maxIdF1 = nextId - 1
+		} else {
+			maxIdF2 = nextId - 1
+		}
+	}
+
+	compressEquivIds(&info1, &info2, maxIdF1, maxIdF2)
+
+	return &info1, &info2
+}
+
+// Lines that match IgnoreMatching
+func ignoreLines(lines [][]byte, opts *Options) []bool {
+	ignore := make([]bool, len(lines))
+	for i, line := range lines {
+		ignore[i] = opts.IgnoreLine(line)
+	}
+	return ignore
+}
+
+// IgnoreChanges gives id=0 to the lines of the changes where all lines are in Ignore,
+// or are blank lines with id=0, so that ReportDiff does not report them, same as diff -I.
+// Call it after the changes are found, Compare already does.
+func IgnoreChanges(info1, info2 *LinesData) {
+	if info1.Ignore == nil && info2.Ignore == nil {
+		return
+	}
+
+	i1, i2 := 0, 0
+	for i1 < len(info1.Change) || i2 < len(info2.Change) {
+		end1, end2 := info1.changeEnd(i1), info2.changeEnd(i2)
+		if end1 == i1 && end2 == i2 {
+			i1++
+			i2++
+			continue
+		}
+		if info1.ignored(i1, end1) && info2.ignored(i2, end2) {
+			info1.ignore(i1, end1)
+			info2.ignore(i2, end2)
+		}
+		i1, i2 = end1, end2
+	}
+}
+
+// end of the changed lines starting at start
+func (info *LinesData) changeEnd(start int) int {
+	end := start
+	for end < len(info.Change) && info.Change[end] {
+		end++
+	}
+	return end
+}
+
+// check if all lines from start to end can be ignored
+func (info *LinesData) ignored(start, end int) bool {
+	for i := start; i < end; i++ {
+		if info.Ids[i] != 0 && (info.Ignore == nil || !info.Ignore[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (info *LinesData) ignore(start, end int) {
+	for i := start; i < end; i++ {
+		info.Ids[i] = 0
+	}
+}
+
+// Count the occurrences of each unique ids in both sets of lines, we will then know which lines are only present in one file, but not the other.
+// Remove chunks of lines that do not appear in the other files, and replace with a single entry
+// Return compressed lists of ids and a list indicating where are the chunk of lines being replaced
+func compressEquivIds(lines1, lines2 *LinesData, maxId1, maxId2 int) {
+
+	len1, len2 := len(lines1.Ids), len(lines2.Ids)
+	hasIds1, hasIds2 := make([]bool, maxId1+1), make([]bool, maxId2+1)
+
+	// Determine which id's are in each file
+	for _, v := range lines1.Ids {
+		hasIds1[v] = true
+	}
+	for _, v := range lines2.Ids {
+		hasIds2[v] = true
+	}
+
+	// exclude lines from the beginning that are identical in both files
+	// if line in file1 but not in file2, exclude it and marked as changed
+	// if line in file2 but not in file1, exclude it and marked as changed
+	i1, i2 := 0, 0
+	for i1 < len1 && i2 < len2 {
+		v1, v2 := lines1.Ids[i1], lines2.Ids[i2]
+		if v1 > maxId2 || !hasIds2[v1] {
+			lines1.Change[i1] = true
+			i1++
+		} else if v2 > maxId1 || !hasIds1[v2] {
+			lines2.Change[i2] = true
+			i2++
+		} else if v1 == v2 {
+			i1++
+			i2++
+		} else {
+			break
+		}
+	}
+
+	// exclude lines from the end that are identical in both files
+	// if line in file1 but not in file2, exclude it and marked as changed
+	// if line in file2 but not in file1, exclude it and marked as changed
+	j1, j2 := len1, len2
+	for i1 < j1 && i2 < j2 {
+		v1, v2 := lines1.Ids[j1-1], lines2.Ids[j2-1]
+		if v1 > maxId2 || !hasIds2[v1] {
+			j1--
+			lines1.Change[j1] = true
+		} else if v2 > maxId1 || !hasIds1[v2] {
+			j2--
+			lines2.Change[j2] = true
+		} else if v1 == v2 {
+			j1--
+			j2--
+		} else {
+			break
+		}
+	}
+
+	// One of the list is now empty, no need to run diff algorithm for comparison.
+	// Just mark the remaining lines other list as changed.
+	if i1 == j1 {
+		for i2 < j2 {
+			lines2.Change[i2] = true
+			i2++
+		}
+		return
+	}
+	if i2 == j2 {
+		for i1 < j1 {
+			lines1.Change[i1] = true
+			i1++
+		}
+		return
+	}
+
+	// store excluded lines from beginning and end of file
+	lines1.zidsStart, lines1.zidsEnd = i1, j1
+	lines2.zidsStart, lines2.zidsEnd = i2, j2
+
+	// Go through all lines, replace chunk of lines that does not exists in the
+	// other set with a single entry and a negative new id).
+	nextId := maxInt(maxId1, maxId2) + 1
+	for findEx := 0; findEx < 2; findEx++ {
+		var ids []int
+		var hasIds []bool
+		var maxId int
+
+		if findEx == 0 {
+			ids = lines1.Ids[lines1.zidsStart:lines1.zidsEnd]
+			hasIds = hasIds2
+			maxId = maxId2
+		} else {
+			ids = lines2.Ids[lines2.zidsStart:lines2.zidsEnd]
+			hasIds = hasIds1
+			maxId = maxId1
+		}
+
+		// new slices for compressed ids and the number of lines each entry replaced
+		// use a new negative id for those merged lines
+		zCount := make([]int, len(ids))
+		zids := make([]int, len(ids))
+
+		lastExclude := false
+		n := 0
+		for _, v := range ids {
+			exclude := v > maxId || !hasIds[v]
+			if exclude && lastExclude {
+				zCount[n-1]++
+				zids[n-1] = -nextId
+				nextId++
+			} else if exclude {
+				zCount[n]++
+				zids[n] = -v
+				n++
+			} else {
+				zCount[n]++
+				zids[n] = v
+				n++
+			}
+			lastExclude = exclude
+		}
+
+		// shrink the slice
+		zids = zids[:n]
+		zCount = zCount[:n]
+
+		if findEx == 0 {
+			lines1.zidS = zids
+			lines1.zCount = zCount
+		} else {
+			lines2.zidS = zids
+			lines2.zCount = zCount
+		}
+	}
+}
+
+// Do the reverse of the compressEquivIds.
+// zLines1 and zLines2 contains the 'extra' lines each entry represents.
+func expandChangeList(info1, info2 *LinesData, zChange1, zChange2 []bool) {
+
+	for findEx := 0; findEx < 2; findEx++ {
+		var info *LinesData
+		var change, zChange []bool
+
+		// expand the changes into the range between zids_start and zids_end
+		if findEx == 0 {
+			info = info1
+			change = info1.Change[info1.zidsStart:]
+			zChange = zChange1
+		} else {
+			info = info2
+			change = info2.Change[info2.zidsStart:]
+			zChange = zChange2
+		}
+
+		// no change
+		if zChange == nil {
+			continue
+		}
+
+		// expand each entry by the number of lines in zCount[]
+		n := 0
+		for i, m := range info.zCount {
+			if zChange[i] {
+				for end := n + m; n < end; n++ {
+					change[n] = true
+				}
+			} else {
+				n += m
+			}
+		}
+	}
+}
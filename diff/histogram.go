@@ -0,0 +1,82 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+// HistogramMaxOccurrences lines that occur more often than this are not used
+// to split up the lists in the histogram diff, same limit as git.
+const HistogramMaxOccurrences = 64
+
+// Histogram diff: find the longest run of matching lines that contains the line
+// with the lowest number of occurrences, and repeat on both sides of that run.
+// Use the O(ND) algorithm for the parts where all lines are too common.
+func algorithmHistogram(data1, data2 []int, change1, change2 []bool, v []int, costLimit int) {
+
+	start1, start2, end1, end2 := trimMatches(data1, data2)
+	if markChanges(start1, start2, end1, end2, change1, change2) {
+		return
+	}
+
+	data1, change1 = data1[start1:end1], change1[start1:end1]
+	data2, change2 = data2[start2:end2], change2[start2:end2]
+
+	// positions of each line in data1
+	positions := make(map[int][]int, len(data1))
+	for i, id := range data1 {
+		positions[id] = append(positions[id], i)
+	}
+
+	best1, best2, bestLen := 0, 0, 0
+	bestCount := HistogramMaxOccurrences + 1
+
+	for i2 := 0; i2 < len(data2); {
+		list := positions[data2[i2]]
+		if len(list) == 0 || len(list) > bestCount {
+			i2++
+			continue
+		}
+
+		next2 := i2 + 1
+		for _, i1 := range list {
+			// extend the match in both directions, using the lowest occurrence within the run
+			count := len(list)
+			s1, s2 := i1, i2
+			for s1 > 0 && s2 > 0 && data1[s1-1] == data2[s2-1] {
+				s1, s2 = s1-1, s2-1
+				count = minInt(count, len(positions[data1[s1]]))
+			}
+			e1, e2 := i1+1, i2+1
+			for e1 < len(data1) && e2 < len(data2) && data1[e1] == data2[e2] {
+				count = minInt(count, len(positions[data1[e1]]))
+				e1, e2 = e1+1, e2+1
+			}
+
+			if count < bestCount || (count == bestCount && e1-s1 > bestLen) {
+				best1, best2, bestLen, bestCount = s1, s2, e1-s1, count
+			}
+			next2 = maxInt(next2, e2)
+		}
+		i2 = next2
+	}
+
+	if bestLen == 0 {
+		algorithmLcs(data1, data2, change1, change2, v, costLimit)
+		return
+	}
+
+	algorithmHistogram(data1[:best1], data2[:best2], change1[:best1], change2[:best2], v, costLimit)
+	algorithmHistogram(data1[best1+bestLen:], data2[best2+bestLen:], change1[best1+bestLen:], change2[best2+bestLen:], v, costLimit)
+}
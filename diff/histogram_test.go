@@ -0,0 +1,81 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name         string
+		file1, file2 string
+		want         string
+	}{
+		{
+			name:  "empty file2",
+			file1: "a b", file2: "",
+			want: "-a -b",
+		},
+		{
+			name:  "remove and insert",
+			file1: "a b c d e", file2: "a c d x e",
+			want: "=a -b =c =d +x =e",
+		},
+		{
+			// c is the line with the fewest occurrences, it is matched first
+			name:  "myers paper",
+			file1: "a b c a b b a", file2: "c b a b a c",
+			want: "-a -b =c -a -b =b =a +b +a +c",
+		},
+		{
+			name:  "inserted block",
+			file1: "f1 { x1 } f2 { x2 }", file2: "f1 { x1 } fn { xn } f2 { x2 }",
+			want: "=f1 ={ =x1 =} +fn +{ +xn +} =f2 ={ =x2 =}",
+		},
+		{
+			name:  "common lines",
+			file1: "x a b c x", file2: "a x b x c",
+			want: "-x =a -b -c =x +b +x +c",
+		},
+		{
+			name:  "swapped blocks",
+			file1: "a b x c d", file2: "c d x a b",
+			want: "-a -b -x =c =d +x +a +b",
+		},
+	}
+
+	for _, test := range tests {
+		lines1, lines2 := testLines(test.file1), testLines(test.file2)
+		opts := &Options{ContextLines: len(lines1) + len(lines2), Algorithm: AlgorithmHistogram}
+		if got := editScript(lines1, lines2, opts); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHistogramRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 500; n++ {
+		lines1, lines2 := randomLines(r, "abcde"), randomLines(r, "abcdef")
+		for _, contextLines := range []int{0, 1, 3} {
+			checkRoundTrip(t, lines1, lines2, &Options{ContextLines: contextLines, Algorithm: AlgorithmHistogram})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// LineIds assigns the equivalent ids to lines one at a time, so that very large files
+// can be compared without keeping all their lines in memory.
+// Unlike FindEquivLines, lines are only compared by a 64 bit hash of their content.
+type LineIds struct {
+	opts   *Options
+	ids    map[uint64]int
+	buf    []byte
+	nextId int
+}
+
+// NewLineIds returns an empty set of ids, use the same LineIds for both files
+func NewLineIds(opts *Options) *LineIds {
+	m := &LineIds{opts: opts, ids: make(map[uint64]int), nextId: 1}
+
+	// Use id=0 for blank lines, same as FindEquivLines
+	if opts.IgnoreBlankLines {
+		m.ids[m.hash(blankLine)] = 0
+	}
+	return m
+}
+
+// Id returns the id of a line, lines that are the same according to opts have the same id
+func (m *LineIds) Id(line []byte) int {
+	h := m.hash(line)
+	id, ok := m.ids[h]
+	if !ok {
+		id = m.nextId
+		m.ids[h] = id
+		m.nextId++
+	}
+	return id
+}
+
+// 64 bit FNV-1a hash of the line, after the substitutions, and ignoring case and spaces
+func (m *LineIds) hash(line []byte) uint64 {
+	m.buf = m.opts.normalizeLine(m.buf[:0], m.opts.substitute(line))
+	h := uint64(14695981039346656037)
+	for _, b := range m.buf {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Convert the line to a form where lines that are the same according to opts are equal,
+// appending the result to dst.
+func (opts *Options) normalizeLine(dst, line []byte) []byte {
+
+	if !opts.IgnoreCase && !opts.IgnoreSpaceChange && !opts.IgnoreAllSpace {
+		return append(dst, line...)
+	}
+
+	space := false
+	for i := 0; i < len(line); {
+		r, size := rune(line[i]), 1
+		isSpace := isSpace(line[i])
+		if opts.UnicodeCaseAndSpace {
+			r, size = utf8.DecodeRune(line[i:])
+			isSpace = unicode.IsSpace(r)
+		}
+		i += size
+
+		if isSpace && (opts.IgnoreAllSpace || opts.IgnoreSpaceChange) {
+			space = true
+			continue
+		}
+
+		// a single space for each run of spaces, except at the start and end of line
+		if space && opts.IgnoreSpaceChange && !opts.IgnoreAllSpace && len(dst) > 0 {
+			dst = append(dst, ' ')
+		}
+		space = false
+
+		switch {
+		case !opts.IgnoreCase:
+			dst = append(dst, line[i-size:i]...)
+		case opts.UnicodeCaseAndSpace:
+			dst = utf8.AppendRune(dst, unicode.ToLower(r))
+		default:
+			dst = append(dst, toLowerByte(byte(r)))
+		}
+	}
+	return dst
+}
+
+// CompareIds run the diff algorithm on lines already converted to ids, e.g. by LineIds,
+// to find out which lines have changed.
+func CompareIds(ids1, ids2 []int, opts *Options) (*LinesData, *LinesData) {
+
+	info1 := &LinesData{Ids: ids1, Change: make([]bool, len(ids1))}
+	info2 := &LinesData{Ids: ids2, Change: make([]bool, len(ids2))}
+
+	maxId1, maxId2 := 0, 0
+	for _, id := range ids1 {
+		maxId1 = maxInt(maxId1, id)
+	}
+	for _, id := range ids2 {
+		maxId2 = maxInt(maxId2, id)
+	}
+
+	compressEquivIds(info1, info2, maxId1, maxId2)
+	diffEquivLines(info1, info2, opts)
+
+	return info1, info2
+}
@@ -0,0 +1,109 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestLineIds(t *testing.T) {
+	tests := []struct {
+		name         string
+		line1, line2 string
+		opts         Options
+		same         bool
+	}{
+		{name: "same", line1: "a b", line2: "a b", same: true},
+		{name: "different", line1: "a b", line2: "a c"},
+		{name: "case", line1: "a B", line2: "A b"},
+		{name: "ignore case", line1: "a B", line2: "A b", opts: Options{IgnoreCase: true}, same: true},
+		{name: "space change", line1: "a  b", line2: "a b"},
+		{name: "ignore space change", line1: " a \t b ", line2: "a b", opts: Options{IgnoreSpaceChange: true}, same: true},
+		{name: "ignore space change, added space", line1: "ab", line2: "a b", opts: Options{IgnoreSpaceChange: true}},
+		{name: "ignore all space", line1: "ab", line2: " a \t b ", opts: Options{IgnoreAllSpace: true}, same: true},
+		{name: "unicode space", line1: "a b", line2: "a b", opts: Options{IgnoreSpaceChange: true}},
+		{name: "ignore unicode space", line1: "a b", line2: "a b", opts: Options{IgnoreSpaceChange: true, UnicodeCaseAndSpace: true}, same: true},
+		{name: "ignore unicode case", line1: "Été", line2: "éTÉ", opts: Options{IgnoreCase: true, UnicodeCaseAndSpace: true}, same: true},
+	}
+
+	for _, test := range tests {
+		ids := NewLineIds(&test.opts)
+		id1, id2 := ids.Id([]byte(test.line1)), ids.Id([]byte(test.line2))
+		if same := id1 == id2; same != test.same {
+			t.Errorf("%s: %q and %q have ids %d and %d", test.name, test.line1, test.line2, id1, id2)
+		}
+		if again := ids.Id([]byte(test.line1)); again != id1 {
+			t.Errorf("%s: %q has id %d, then %d", test.name, test.line1, id1, again)
+		}
+	}
+
+	// blank lines have id 0 when they are ignored
+	ids := NewLineIds(&Options{IgnoreBlankLines: true})
+	if id := ids.Id(nil); id != 0 {
+		t.Errorf("blank line has id %d, want 0", id)
+	}
+	if id := ids.Id([]byte("a")); id == 0 {
+		t.Errorf("line %q has id 0", "a")
+	}
+	ids = NewLineIds(&Options{})
+	if id := ids.Id(nil); id == 0 {
+		t.Errorf("blank line has id 0, when blank lines are not ignored")
+	}
+}
+
+// Lines converted to ids one at a time must find the same changes as the lines compared in memory
+func TestCompareIds(t *testing.T) {
+	options := []Options{
+		{},
+		{IgnoreCase: true},
+		{IgnoreBlankLines: true},
+		{IgnoreSpaceChange: true},
+		{Algorithm: AlgorithmPatience},
+		{Algorithm: AlgorithmHistogram},
+		{IgnoreMatching: []*regexp.Regexp{regexp.MustCompile("^c")}},
+		{Substitutions: []Substitution{{Pattern: regexp.MustCompile("[Ab]"), Replacement: []byte("a")}}},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		lines1, lines2 := randomLines(r, "aAbc  "), randomLines(r, "aAbc  ")
+		opts := &options[i%len(options)]
+
+		want1, want2 := Compare(lines1, lines2, opts)
+
+		lineIds := NewLineIds(opts)
+		var ids1, ids2 []int
+		for _, line := range lines1 {
+			ids1 = append(ids1, lineIds.Id(line))
+		}
+		for _, line := range lines2 {
+			ids2 = append(ids2, lineIds.Id(line))
+		}
+		info1, info2 := CompareIds(ids1, ids2, opts)
+		if len(opts.IgnoreMatching) > 0 {
+			info1.Ignore, info2.Ignore = ignoreLines(lines1, opts), ignoreLines(lines2, opts)
+		}
+		IgnoreChanges(info1, info2)
+
+		if !reflect.DeepEqual(info1.Change, want1.Change) || !reflect.DeepEqual(info2.Change, want2.Change) {
+			t.Fatalf("%q %q, options %+v: changes %v %v, want %v %v", lines1, lines2, *opts, info1.Change, info2.Change, want1.Change, want2.Change)
+		}
+	}
+}
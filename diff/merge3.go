@@ -0,0 +1,126 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+// Kind of region found by Diff3
+const (
+	Merge3Same     = 1 // unchanged in all files
+	Merge3Mine     = 2 // only changed in mine
+	Merge3Theirs   = 3 // only changed in theirs
+	Merge3Both     = 4 // mine and theirs have the same changes
+	Merge3Conflict = 5 // mine and theirs have different changes
+)
+
+// Merge3Chunk a range of lines in the base, mine and theirs files.
+// Lines are numbered from 0, the End positions are exclusive.
+type Merge3Chunk struct {
+	Kind                   int
+	BaseStart, BaseEnd     int
+	MineStart, MineEnd     int
+	TheirsStart, TheirsEnd int
+}
+
+// Diff3 compare the changes from base to mine, and from base to theirs.
+// The files are split up into chunks that are unchanged, changed on one side only, or in conflict.
+func Diff3(base, mine, theirs [][]byte, opts *Options) []Merge3Chunk {
+
+	compareLine, _ := opts.lineFuncs()
+	matchMine := matchingLines(base, mine, opts)
+	matchTheirs := matchingLines(base, theirs, opts)
+
+	var chunks []Merge3Chunk
+	b, m, t := 0, 0, 0
+
+	for b < len(base) || m < len(mine) || t < len(theirs) {
+
+		// unchanged in all files, find the end of this chunk
+		if b < len(base) && matchMine[b] == m && matchTheirs[b] == t {
+			n := 1
+			for b+n < len(base) && matchMine[b+n] == m+n && matchTheirs[b+n] == t+n {
+				n++
+			}
+			chunks = append(chunks, Merge3Chunk{Merge3Same, b, b + n, m, m + n, t, t + n})
+			b, m, t = b+n, m+n, t+n
+			continue
+		}
+
+		// find the next line in base that is unchanged in both mine and theirs
+		i, mEnd, tEnd := b, len(mine), len(theirs)
+		for i < len(base) && (matchMine[i] < 0 || matchTheirs[i] < 0) {
+			i++
+		}
+		if i < len(base) {
+			mEnd, tEnd = matchMine[i], matchTheirs[i]
+		}
+
+		chunk := Merge3Chunk{0, b, i, m, mEnd, t, tEnd}
+		mineSame := equalLines(base[b:i], mine[m:mEnd], compareLine)
+		theirsSame := equalLines(base[b:i], theirs[t:tEnd], compareLine)
+
+		switch {
+		case mineSame && theirsSame:
+			chunk.Kind = Merge3Same
+		case mineSame:
+			chunk.Kind = Merge3Theirs
+		case theirsSame:
+			chunk.Kind = Merge3Mine
+		case equalLines(mine[m:mEnd], theirs[t:tEnd], compareLine):
+			chunk.Kind = Merge3Both
+		default:
+			chunk.Kind = Merge3Conflict
+		}
+
+		chunks = append(chunks, chunk)
+		b, m, t = i, mEnd, tEnd
+	}
+
+	return chunks
+}
+
+// For each line in lines1, find the index of the same line in lines2, or -1 if it has been changed
+func matchingLines(lines1, lines2 [][]byte, opts *Options) []int {
+
+	info1, info2 := Compare(lines1, lines2, opts)
+
+	match := make([]int, len(lines1))
+	i1, i2 := 0, 0
+	for i1 < len(lines1) {
+		switch {
+		case info1.Change[i1]:
+			match[i1] = -1
+			i1++
+		case i2 < len(lines2) && info2.Change[i2]:
+			i2++
+		default:
+			match[i1] = i2
+			i1, i2 = i1+1, i2+1
+		}
+	}
+	return match
+}
+
+func equalLines(lines1, lines2 [][]byte, compareLine func([]byte, []byte) bool) bool {
+	if len(lines1) != len(lines2) {
+		return false
+	}
+	for i := range lines1 {
+		if !compareLine(lines1[i], lines2[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var merge3KindNames = map[int]string{
+	Merge3Same:     "same",
+	Merge3Mine:     "mine",
+	Merge3Theirs:   "theirs",
+	Merge3Both:     "both",
+	Merge3Conflict: "conflict",
+}
+
+// The chunks as "kind(base;mine;theirs)", with the lines of each file
+func merge3Script(base, mine, theirs [][]byte, chunks []Merge3Chunk) string {
+	join := func(lines [][]byte) string {
+		return string(bytes.Join(lines, []byte(" ")))
+	}
+	var script []string
+	for _, c := range chunks {
+		script = append(script, fmt.Sprintf("%s(%s;%s;%s)", merge3KindNames[c.Kind],
+			join(base[c.BaseStart:c.BaseEnd]), join(mine[c.MineStart:c.MineEnd]), join(theirs[c.TheirsStart:c.TheirsEnd])))
+	}
+	return strings.Join(script, " ")
+}
+
+func TestDiff3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, mine, theirs string
+		want               string
+	}{
+		{
+			name: "unchanged",
+			base: "a b", mine: "a b", theirs: "a b",
+			want: "same(a b;a b;a b)",
+		},
+		{
+			name: "changed on each side",
+			base: "a b c d e", mine: "A b c d e", theirs: "a b c d E",
+			want: "mine(a;A;a) same(b c d;b c d;b c d) theirs(e;e;E)",
+		},
+		{
+			name: "same change",
+			base: "a b c", mine: "a B c", theirs: "a B c",
+			want: "same(a;a;a) both(b;B;B) same(c;c;c)",
+		},
+		{
+			name: "conflict",
+			base: "a b c", mine: "a mine c", theirs: "a theirs c",
+			want: "same(a;a;a) conflict(b;mine;theirs) same(c;c;c)",
+		},
+		{
+			name: "inserted on both sides",
+			base: "a b", mine: "a x b", theirs: "a y b",
+			want: "same(a;a;a) conflict(;x;y) same(b;b;b)",
+		},
+		{
+			name: "removed and inserted",
+			base: "a b c", mine: "a c", theirs: "a b c d",
+			want: "same(a;a;a) mine(b;;b) same(c;c;c) theirs(;;d)",
+		},
+		{
+			name: "empty base",
+			base: "", mine: "a", theirs: "",
+			want: "mine(;a;)",
+		},
+	}
+
+	for _, test := range tests {
+		base, mine, theirs := testLines(test.base), testLines(test.mine), testLines(test.theirs)
+		chunks := Diff3(base, mine, theirs, &Options{})
+		if got := merge3Script(base, mine, theirs, chunks); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// The chunks cover all lines of the three files, and their kind matches their lines
+func TestDiff3Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 500; n++ {
+		base, mine, theirs := randomLines(r, "abcde"), randomLines(r, "abcdef"), randomLines(r, "abcdeg")
+		chunks := Diff3(base, mine, theirs, &Options{})
+
+		b, m, th := 0, 0, 0
+		for _, c := range chunks {
+			if c.BaseStart != b || c.MineStart != m || c.TheirsStart != th {
+				t.Fatalf("%q %q %q: chunk %+v does not follow the previous chunk", base, mine, theirs, c)
+			}
+			b, m, th = c.BaseEnd, c.MineEnd, c.TheirsEnd
+
+			baseLines := string(joinLines(base[c.BaseStart:c.BaseEnd]))
+			mineLines := string(joinLines(mine[c.MineStart:c.MineEnd]))
+			theirsLines := string(joinLines(theirs[c.TheirsStart:c.TheirsEnd]))
+			ok := false
+			switch c.Kind {
+			case Merge3Same:
+				ok = mineLines == baseLines && theirsLines == baseLines
+			case Merge3Mine:
+				ok = mineLines != baseLines && theirsLines == baseLines
+			case Merge3Theirs:
+				ok = mineLines == baseLines && theirsLines != baseLines
+			case Merge3Both:
+				ok = mineLines == theirsLines && mineLines != baseLines
+			case Merge3Conflict:
+				ok = mineLines != theirsLines && mineLines != baseLines && theirsLines != baseLines
+			}
+			if !ok {
+				t.Fatalf("%q %q %q: chunk %+v is not %s", base, mine, theirs, c, merge3KindNames[c.Kind])
+			}
+		}
+		if b != len(base) || m != len(mine) || th != len(theirs) {
+			t.Fatalf("%q %q %q: the chunks end at %d %d %d", base, mine, theirs, b, m, th)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+// An O(ND) Difference Algorithm: Find middle snake
+// Give up after costLimit steps (if not 0), and return the best split point found so far instead.
+func algorithmSms(data1, data2 []int, v []int, costLimit int) (int, int, int, int) {
+
+	end1, end2 := len(data1), len(data2)
+	mMax := end1 + end2 + 1
+	upK := end1 - end2
+	odd := (upK & 1) != 0
+	downOff, upOff := mMax, mMax-upK+mMax+mMax+2
+
+	v[downOff+1] = 0
+	v[downOff] = 0
+	v[upOff+upK-1] = end1
+	v[upOff+upK] = end1
+
+	var k, x, u, z int
+
+	for d := 1; true; d++ {
+		upKPlusD := upK + d
+		upKMinusD := upK - d
+		// from the highest diagonal, same as gnu diff, to find the same changes when several are as short
+		for k = d; k >= -d; k -= 2 {
+			x = v[downOff+k+1]
+			if k > -d && (k == d || v[downOff+k-1] >= x) {
+				x = v[downOff+k-1] + 1
+			}
+			for u = x; x < end1 && x-k < end2 && data1[x] == data2[x-k]; x++ {
+			}
+			if odd && (upKMinusD < k) && (k < upKPlusD) && v[upOff+k] <= x {
+				return u, u - k, x, x - k
+			}
+			v[downOff+k] = x
+		}
+		for k = upKPlusD; k >= upKMinusD; k -= 2 {
+			x = v[upOff+k-1]
+			if k < upKPlusD {
+				z = v[upOff+k+1]
+				if k == upKMinusD || z <= x {
+					x = z - 1
+				}
+			}
+			for u = x; x > 0 && x > k && data1[x-1] == data2[x-k-1]; x-- {
+			}
+			if !odd && (-d <= k) && (k <= d) && x <= v[downOff+k] {
+				return x, x - k, u, u - k
+			}
+			v[upOff+k] = x
+		}
+
+		// Too expensive, use the diagonal that has come closest to either end instead
+		if costLimit > 0 && d >= costLimit {
+			if x, y, ok := bestSplit(v, downOff, upOff, upK, d, end1, end2); ok {
+				return x, y, x, y
+			}
+		}
+	}
+	return 0, 0, 0, 0 // should not reach here
+}
+
+// Heuristic used by algorithmSms when the cost limit is exceeded, same as gnu diff.
+// Find the forward path that has gone the furthest, and the backward path that has gone the furthest,
+// and split the lists at the better one of the two. The result is not minimal, but still good.
+// Returns false if the split would not divide the lists into smaller parts.
+func bestSplit(v []int, downOff, upOff, upK, d, end1, end2 int) (int, int, bool) {
+
+	// forward diagonal that maximizes x + y
+	fxBest, fxyBest := 0, -1
+	for k := -d; k <= d; k += 2 {
+		if k < -end2 || k > end1 {
+			continue
+		}
+		x := minInt(v[downOff+k], end1)
+		y := x - k
+		if y > end2 {
+			x, y = end2+k, end2
+		}
+		if y < 0 {
+			x, y = k, 0
+		}
+		if fxyBest < x+y {
+			fxBest, fxyBest = x, x+y
+		}
+	}
+
+	// backward diagonal that minimizes x + y
+	bxBest, bxyBest := 0, end1+end2+1
+	for k := upK - d; k <= upK+d; k += 2 {
+		if k < -end2 || k > end1 {
+			continue
+		}
+		x := maxInt(v[upOff+k], 0)
+		y := x - k
+		if y < 0 {
+			x, y = k, 0
+		}
+		if y > end2 {
+			x, y = end2+k, end2
+		}
+		if x+y < bxyBest {
+			bxBest, bxyBest = x, x+y
+		}
+	}
+
+	x, xy := bxBest, bxyBest
+	if (end1+end2)-bxyBest < fxyBest {
+		x, xy = fxBest, fxyBest
+	}
+	if xy <= 0 || xy >= end1+end2 {
+		return 0, 0, false
+	}
+	return x, xy - x, true
+}
+
+// Special case for algorithmSms() with only 1 item.
+func findOneSms(value int, list []int) (int, int) {
+	for i, v := range list {
+		if v == value {
+			return 0, i
+		}
+	}
+	return 1, 0
+}
+
+// An O(ND) Difference Algorithm: Find LCS
+func algorithmLcs(data1, data2 []int, change1, change2 []bool, v []int, costLimit int) {
+
+	start1, start2 := 0, 0
+	end1, end2 := len(data1), len(data2)
+
+	// matches found at start and end of list
+	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
+		start1++
+		start2++
+	}
+	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
+		end1--
+		end2--
+	}
+
+	len1, len2 := end1-start1, end2-start2
+
+	switch {
+	case len1 == 0:
+		for start2 < end2 {
+			change2[start2] = true
+			start2++
+		}
+
+	case len2 == 0:
+		for start1 < end1 {
+			change1[start1] = true
+			start1++
+		}
+
+	case len1 == 1 && len2 == 1:
+		change1[start1] = true
+		change2[start2] = true
+
+	default:
+		data1, change1 = data1[start1:end1], change1[start1:end1]
+		data2, change2 = data2[start2:end2], change2[start2:end2]
+
+		var x0, y0, x1, y1 int
+
+		if len(data1) == 1 {
+			// match one item, use simple search function
+			x0, y0 = findOneSms(data1[0], data2)
+			x1, y1 = x0, y0
+		} else if len(data2) == 1 {
+			// match one item, use simple search function
+			y0, x0 = findOneSms(data2[0], data1)
+			x1, y1 = x0, y0
+		} else {
+			// Find a point with the longest common sequence
+			x0, y0, x1, y1 = algorithmSms(data1, data2, v, costLimit)
+		}
+
+		// Use the partitions to split this problem into subproblems.
+		algorithmLcs(data1[:x0], data2[:y0], change1[:x0], change2[:y0], v, costLimit)
+		algorithmLcs(data1[x1:], data2[y1:], change1[x1:], change2[y1:], v, costLimit)
+	}
+}
@@ -0,0 +1,100 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCostLimit(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		len1, len2 int
+		want       int
+	}{
+		{Options{Minimal: true, CostLimit: 10}, 100, 100, 0},
+		{Options{CostLimit: 10}, 100, 100, 10},
+		{Options{}, 100, 100, MinCostLimit},
+		{Options{}, 1000000, 1000000, MinCostLimit},
+		// about the square root of the number of diagonals
+		{Options{}, 100000000, 100000000, 16384},
+	}
+	for _, test := range tests {
+		if got := test.opts.costLimit(test.len1, test.len2); got != test.want {
+			t.Errorf("%+v %d %d: got %d, want %d", test.opts, test.len1, test.len2, got, test.want)
+		}
+	}
+}
+
+// When the cost limit is reached, the lists are split at a point of the best path found so far
+func TestBestSplit(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 1000; n++ {
+		data1, data2 := randomIds(r, 2+r.Intn(30), 8), randomIds(r, 2+r.Intn(30), 8)
+		end1, end2 := len(data1), len(data2)
+		v := make([]int, ((end1+end2+1)*2+2)*2)
+
+		for _, costLimit := range []int{1, 2, 3} {
+			x0, y0, x1, y1 := algorithmSms(data1, data2, v, costLimit)
+			if x0 < 0 || y0 < 0 || x1 > end1 || y1 > end2 || x1-x0 != y1-y0 || x1 < x0 {
+				t.Fatalf("%v %v, cost limit %d: split %d,%d %d,%d", data1, data2, costLimit, x0, y0, x1, y1)
+			}
+			if (x0 == 0 && y0 == 0 && x1 == 0 && y1 == 0) || (x0 == end1 && y0 == end2) {
+				t.Fatalf("%v %v, cost limit %d: split at the end %d,%d %d,%d", data1, data2, costLimit, x0, y0, x1, y1)
+			}
+			for i := 0; i < x1-x0; i++ {
+				if data1[x0+i] != data2[y0+i] {
+					t.Fatalf("%v %v, cost limit %d: lines %d and %d do not match", data1, data2, costLimit, x0+i, y0+i)
+				}
+			}
+		}
+	}
+}
+
+// With a low cost limit the differences are not minimal, but applying them still gives file2
+func TestCostLimitRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	notMinimal := 0
+	for n := 0; n < 500; n++ {
+		lines1, lines2 := randomLines(r, "abcde"), randomLines(r, "abcdef")
+		lcs := lcsLength(lines1, lines2)
+		for _, costLimit := range []int{1, 2, 5} {
+			removed, added := checkRoundTrip(t, lines1, lines2, &Options{ContextLines: 3, CostLimit: costLimit})
+			if removed < len(lines1)-lcs || added < len(lines2)-lcs {
+				t.Errorf("%q %q: %d removed and %d added, fewer than the minimal %d and %d", lines1, lines2, removed, added, len(lines1)-lcs, len(lines2)-lcs)
+			}
+			if removed > len(lines1)-lcs {
+				notMinimal++
+			}
+		}
+	}
+	if notMinimal == 0 {
+		t.Errorf("the cost limit was never reached")
+	}
+}
+
+// Random list of n ids, from 1 to max
+func randomIds(r *rand.Rand, n, max int) []int {
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = 1 + r.Intn(max)
+	}
+	return ids
+}
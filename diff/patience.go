@@ -0,0 +1,142 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import "sort"
+
+// Patience diff: match the lines that are unique in both lists, keep the longest
+// sequence of those in the same order, and repeat between each pair of matched lines.
+// Use the O(ND) algorithm for the parts without any unique lines.
+func algorithmPatience(data1, data2 []int, change1, change2 []bool, v []int, costLimit int) {
+
+	start1, start2, end1, end2 := trimMatches(data1, data2)
+	if markChanges(start1, start2, end1, end2, change1, change2) {
+		return
+	}
+
+	data1, change1 = data1[start1:end1], change1[start1:end1]
+	data2, change2 = data2[start2:end2], change2[start2:end2]
+
+	anchors := uniqueCommonLines(data1, data2)
+	if len(anchors) == 0 {
+		algorithmLcs(data1, data2, change1, change2, v, costLimit)
+		return
+	}
+
+	// the lines between each pair of anchors, and after the last one
+	last1, last2 := 0, 0
+	for _, a := range anchors {
+		algorithmPatience(data1[last1:a.pos1], data2[last2:a.pos2], change1[last1:a.pos1], change2[last2:a.pos2], v, costLimit)
+		last1, last2 = a.pos1+1, a.pos2+1
+	}
+	algorithmPatience(data1[last1:], data2[last2:], change1[last1:], change2[last2:], v, costLimit)
+}
+
+// a line that can be matched in both lists
+type lineMatch struct {
+	pos1, pos2 int
+}
+
+// Find the lines that appear exactly once in both lists,
+// returns the longest sequence of them that are in the same order in both lists.
+func uniqueCommonLines(data1, data2 []int) []lineMatch {
+
+	// position of unique lines in data1, -1 if not unique
+	unique := make(map[int]int, len(data1))
+	for i, id := range data1 {
+		if _, found := unique[id]; found {
+			unique[id] = -1
+		} else {
+			unique[id] = i
+		}
+	}
+
+	// the lines also unique in data2, in the order of data2
+	count2 := make(map[int]int, len(data2))
+	for _, id := range data2 {
+		count2[id]++
+	}
+	var matches []lineMatch
+	for i, id := range data2 {
+		if pos1, found := unique[id]; found && pos1 >= 0 && count2[id] == 1 {
+			matches = append(matches, lineMatch{pos1, i})
+		}
+	}
+
+	// longest increasing sequence of pos1, using patience sorting.
+	// tops holds the index of the last match of each pile, prev links to the pile on the left.
+	tops := make([]int, 0, len(matches))
+	prev := make([]int, len(matches))
+	for i, m := range matches {
+		n := sort.Search(len(tops), func(k int) bool { return matches[tops[k]].pos1 > m.pos1 })
+		if n > 0 {
+			prev[i] = tops[n-1]
+		} else {
+			prev[i] = -1
+		}
+		if n == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[n] = i
+		}
+	}
+
+	lis := make([]lineMatch, len(tops))
+	k := -1
+	if len(tops) > 0 {
+		k = tops[len(tops)-1]
+	}
+	for i := len(tops) - 1; i >= 0; i-- {
+		lis[i] = matches[k]
+		k = prev[k]
+	}
+	return lis
+}
+
+// Skip the identical lines at the beginning and end of both lists
+func trimMatches(data1, data2 []int) (int, int, int, int) {
+	start1, start2 := 0, 0
+	end1, end2 := len(data1), len(data2)
+
+	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
+		start1++
+		start2++
+	}
+	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
+		end1--
+		end2--
+	}
+	return start1, start2, end1, end2
+}
+
+// If one of the ranges is empty, mark all lines in the other range as changed.
+// Returns false if both ranges are not empty.
+func markChanges(start1, start2, end1, end2 int, change1, change2 []bool) bool {
+	switch {
+	case start1 == end1:
+		for ; start2 < end2; start2++ {
+			change2[start2] = true
+		}
+	case start2 == end2:
+		for ; start1 < end1; start1++ {
+			change1[start1] = true
+		}
+	default:
+		return false
+	}
+	return true
+}
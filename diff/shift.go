@@ -0,0 +1,145 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+// Perform the shift
+func doShiftBoundary(start, end, offset int, change []bool) {
+	if offset < 0 {
+		for offset != 0 {
+			start, end, offset = start-1, end-1, offset+1
+			change[start], change[end] = true, false
+		}
+	} else {
+		for offset != 0 {
+			change[start], change[end] = false, true
+			start, end, offset = start+1, end+1, offset-1
+		}
+	}
+}
+
+// Determine if the changes starting at 'pos' can be shifted 'up' or 'down'
+func findShiftBoundary(start int, data []int, change []bool) (int, int, int, bool, bool) {
+	end, dLen := start+1, len(data)
+	up, down := 0, 0
+
+	// Find the end of this chunk of changes
+	for end < dLen && change[end] {
+		end++
+	}
+
+	for start-up-1 >= 0 && !change[start-up-1] && data[start-up-1] == data[end-up-1] {
+		up = up + 1
+	}
+
+	for end+down < dLen && !change[end+down] && data[end+down] == data[start+down] {
+		down = down + 1
+	}
+
+	// has changes been shifted to start/end of list or merged with previous/next change
+	upMerge := (start-up == 0) || change[start-up-1]
+	downMerge := (end+down == dLen) || change[end+down]
+
+	return end, up, down, upMerge, downMerge
+}
+
+// scoring function for shifting characters in a line.
+func runeEdgeScore(r rune) int {
+
+	switch r {
+	case ' ', '\t', '\v', '\f':
+		return 100
+
+	case '<', '>', '(', ')', '[', ']', '\'', '"':
+		return 40
+	}
+
+	return 0
+}
+
+// RuneBoundaryScore scoring character boundary, for finding a change chunk that is easier to read
+func RuneBoundaryScore(r1, r2 int) int {
+
+	s1 := runeEdgeScore(rune(r1))
+	s2 := runeEdgeScore(rune(r2))
+
+	return s1 + s2
+}
+
+// ShiftBoundaries shift changes up or down to make it more readable.
+func ShiftBoundaries(data []int, change []bool, boundaryScore func(int, int) int) {
+
+	start, clen := 0, len(change)
+
+	for start < clen {
+		// find the next chunk of changes
+		for start < clen && !change[start] {
+			start++
+		}
+		if start >= clen {
+			break
+		}
+
+		// find the limit of where this set of changes can be shifted
+		end, up, down, upMerge, downMerge := findShiftBoundary(start, data, change)
+
+		// The chunk is already at the start, do not shift downwards
+		if start == 0 {
+			up, down = 0, 0
+		}
+
+		switch {
+		case up > 0 && upMerge:
+			// shift up, merged with previous chunk of changes
+			doShiftBoundary(start, end, -up, change)
+			// restart at the beginning of this merged chunk
+			nStart := start
+			for nStart -= up; nStart-1 >= 0 && change[nStart-1]; nStart-- {
+			}
+			if nStart > 0 {
+				start = nStart
+			}
+
+		case down > 0 && downMerge:
+			// shift down, merged with next chunk of changes
+			doShiftBoundary(start, end, down, change)
+			start += down
+
+		case (up > 0 || down > 0) && boundaryScore != nil:
+			// Only perform shifts when there is a boundary score function
+			offset, bestScore := 0, boundaryScore(data[start], data[end-1])
+			for i := -up; i <= down; i++ {
+				if i != 0 {
+					score := boundaryScore(data[start+i], data[end+i-1])
+					if score > bestScore {
+						offset, bestScore = i, score
+					}
+				}
+			}
+			if offset != 0 {
+				doShiftBoundary(start, end, offset, change)
+			}
+			start = end
+			if offset > 0 {
+				start += offset
+			}
+
+		default:
+			// no shift
+			start = end
+		}
+	}
+}
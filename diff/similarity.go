@@ -0,0 +1,74 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"sort"
+)
+
+// LineHashes compute the hash values of all lines, in sorted order.
+// Lines that are the same according to opts have the same hash value.
+// Use with Similarity() to find files with similar content.
+func LineHashes(lines [][]byte, opts *Options) []uint32 {
+
+	compareLine, computeHash := opts.lineFuncs()
+
+	hashes := make([]uint32, 0, len(lines))
+	for _, line := range lines {
+		if (opts.IgnoreBlankLines && compareLine(line, blankLine)) || opts.IgnoreLine(line) {
+			continue
+		}
+		hashes = append(hashes, computeHash(line))
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes
+}
+
+// Similarity percentage of lines in common between two sorted lists of line hashes.
+func Similarity(hashes1, hashes2 []uint32) int {
+
+	total := len(hashes1) + len(hashes2)
+	if total == 0 {
+		return 100
+	}
+
+	// both lists are sorted, count the matching entries
+	common := 0
+	for i, j := 0, 0; i < len(hashes1) && j < len(hashes2); {
+		switch {
+		case hashes1[i] < hashes2[j]:
+			i++
+		case hashes1[i] > hashes2[j]:
+			j++
+		default:
+			common++
+			i, j = i+1, j+1
+		}
+	}
+
+	return common * 2 * 100 / total
+}
+
+// MaxSimilarity the similarity if all lines of the shorter list are found in the longer list.
+// Useful to skip comparisons which can never reach the required similarity.
+func MaxSimilarity(len1, len2 int) int {
+	if len1+len2 == 0 {
+		return 100
+	}
+	return minInt(len1, len2) * 2 * 100 / (len1 + len2)
+}
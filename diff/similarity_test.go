@@ -0,0 +1,60 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name         string
+		file1, file2 string
+		opts         Options
+		want         int
+	}{
+		{name: "empty", file1: "", file2: "", want: 100},
+		{name: "one empty", file1: "a b", file2: "", want: 0},
+		{name: "same", file1: "a b c d", file2: "a b c d", want: 100},
+		{name: "moved lines", file1: "a b c d", file2: "d c b a", want: 100},
+		{name: "half", file1: "a b c d", file2: "a b x y", want: 50},
+		{name: "duplicate lines", file1: "a a a b", file2: "a b b b", want: 50},
+		{name: "added lines", file1: "a b c", file2: "a b c d e f", want: 66},
+		{name: "ignore case", file1: "a b", file2: "A B", opts: Options{IgnoreCase: true}, want: 100},
+		{name: "ignore blank lines", file1: "a b", file2: "a <blank> <blank> b", opts: Options{IgnoreBlankLines: true}, want: 100},
+		{name: "ignore matching lines", file1: "a b D1", file2: "a b D2", opts: Options{IgnoreMatching: []*regexp.Regexp{regexp.MustCompile("^D")}}, want: 100},
+	}
+
+	for _, test := range tests {
+		lines1, lines2 := testLines(test.file1), testLines(test.file2)
+		for _, lines := range [][][]byte{lines1, lines2} {
+			for i, line := range lines {
+				if string(line) == "<blank>" {
+					lines[i] = nil
+				}
+			}
+		}
+		hashes1, hashes2 := LineHashes(lines1, &test.opts), LineHashes(lines2, &test.opts)
+		if got := Similarity(hashes1, hashes2); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+		if max := MaxSimilarity(len(lines1), len(lines2)); max < Similarity(LineHashes(lines1, &Options{}), LineHashes(lines2, &Options{})) {
+			t.Errorf("%s: MaxSimilarity %d is lower than the similarity", test.name, max)
+		}
+	}
+}
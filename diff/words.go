@@ -0,0 +1,108 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind of token found by SplitWords
+const (
+	tokenOther = iota
+	tokenWord
+	tokenSpace
+	tokenOperator
+)
+
+// characters that are joined together into a single operator, such as == += -> &&
+const operatorChars = "+-*/%=<>!&|^~:?."
+
+// SplitWords split text into words, white space and operators, and return the position of each token,
+// and another array for comparison. Use with DoDiff() like SplitRunes(), to find the words changed within a line.
+// Identifiers and numbers are single words, each bracket or separator is a token by itself.
+// The ids map gives the same id to identical tokens, it must be shared by the lines being compared.
+func SplitWords(s []byte, opts *Options, ids map[string]int) ([]int, []int) {
+
+	pos := make([]int, 0, len(s)/4+2)
+	cmp := make([]int, 0, len(s)/4+1)
+
+	for i := 0; i < len(s); {
+		start := i
+		kind, size := tokenKind(s, i)
+		i += size
+		if kind != tokenOther {
+			for i < len(s) {
+				k, n := tokenKind(s, i)
+				if k != kind {
+					break
+				}
+				i += n
+			}
+		}
+
+		pos = append(pos, start)
+		cmp = append(cmp, tokenId(s[start:i], kind, opts, ids))
+	}
+	pos = append(pos, len(s))
+	return pos, cmp
+}
+
+// Find the kind of token for the rune at s[i], and the size of the rune
+func tokenKind(s []byte, i int) (int, int) {
+	r, size := rune(s[i]), 1
+	if r >= utf8.RuneSelf {
+		r, size = utf8.DecodeRune(s[i:])
+	}
+	switch {
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return tokenWord, size
+	case unicode.IsSpace(r):
+		return tokenSpace, size
+	case r < utf8.RuneSelf && strings.IndexByte(operatorChars, byte(r)) >= 0:
+		return tokenOperator, size
+	}
+	return tokenOther, size
+}
+
+// Id for the token, tokens that are considered identical have the same id
+func tokenId(token []byte, kind int, opts *Options, ids map[string]int) int {
+	var key string
+	switch {
+	case kind == tokenSpace && (opts.IgnoreSpaceChange || opts.IgnoreAllSpace):
+		key = " "
+	case kind == tokenWord && opts.IgnoreCase && opts.UnicodeCaseAndSpace:
+		key = string(bytes.ToLower(token))
+	case kind == tokenWord && opts.IgnoreCase:
+		lower := make([]byte, len(token))
+		for i, b := range token {
+			lower[i] = toLowerByte(b)
+		}
+		key = string(lower)
+	default:
+		key = string(token)
+	}
+
+	id, found := ids[key]
+	if !found {
+		id = len(ids) + 1
+		ids[key] = id
+	}
+	return id
+}
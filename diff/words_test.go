@@ -0,0 +1,80 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The tokens found by SplitWords, separated by "|"
+func splitTokens(s string, opts *Options) string {
+	pos, _ := SplitWords([]byte(s), opts, make(map[string]int))
+	var tokens []string
+	for i := 0; i+1 < len(pos); i++ {
+		tokens = append(tokens, s[pos[i]:pos[i+1]])
+	}
+	return strings.Join(tokens, "|")
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"", ""},
+		{"word", "word"},
+		{"if (a == b_1) {", "if| |(|a| |==| |b_1|)| |{"},
+		{"x+=y->z", "x|+=|y|->|z"},
+		{"f(a,b);", "f|(|a|,|b|)|;"},
+		{"\tindent  two", "\t|indent|  |two"},
+		{"3.14 0x1f", "3|.|14| |0x1f"},
+		{"héllo wörld", "héllo| |wörld"},
+		{"a((b))", "a|(|(|b|)|)"},
+	}
+	for _, test := range tests {
+		if got := splitTokens(test.line, &Options{}); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+// Tokens that are the same according to the options have the same id
+func TestSplitWordsIds(t *testing.T) {
+	tests := []struct {
+		line1, line2 string
+		opts         Options
+		same         bool
+	}{
+		{"a = b", "a = b", Options{}, true},
+		{"a = b", "a  =\tb", Options{}, false},
+		{"a = b", "a  =\tb", Options{IgnoreSpaceChange: true}, true},
+		{"Foo Bar", "foo bar", Options{}, false},
+		{"Foo Bar", "foo bar", Options{IgnoreCase: true}, true},
+		{"Éa", "éa", Options{IgnoreCase: true}, false},
+		{"Éa", "éa", Options{IgnoreCase: true, UnicodeCaseAndSpace: true}, true},
+	}
+	for _, test := range tests {
+		ids := make(map[string]int)
+		_, cmp1 := SplitWords([]byte(test.line1), &test.opts, ids)
+		_, cmp2 := SplitWords([]byte(test.line2), &test.opts, ids)
+		if same := reflect.DeepEqual(cmp1, cmp2); same != test.same {
+			t.Errorf("%q %q %+v: same ids is %v, want %v", test.line1, test.line2, test.opts, same, test.same)
+		}
+	}
+}
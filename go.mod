@@ -1,3 +1,9 @@
 module github.com/GoToUse/godiff
 
-go 1.19
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.22.0
+)
@@ -46,24 +46,22 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
 	"flag"
 	"fmt"
-	"hash/crc32"
 	"html"
-
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unicode"
-	"unicode/utf8"
+
+	"github.com/GoToUse/godiff/diff"
 )
 
 const (
@@ -76,17 +74,36 @@ const (
 	// OutputBufSize Output buffer size
 	OutputBufSize = 65536
 
-	// ContextLines default number of context lines to display
-	ContextLines = 3
-
 	// PathSeparator convenient shortcut
 	PathSeparator = string(os.PathSeparator)
 
 	// MmapThreshold use mmap for file greater than this size, for smaller files just use Read() instead.
 	MmapThreshold = 8 * 1024
 
+	// DefaultMaxSize files larger than this are compared without reading them into memory
+	DefaultMaxSize = 100 << 20
+
+	// StdinName file name for reading from stdin
+	StdinName = "-"
+
 	// NumPreviewLines Number of lines to print for previewing file
 	NumPreviewLines = 10
+
+	// DefaultRenameThreshold files must be at least 50% similar to be considered renamed
+	DefaultRenameThreshold = 50
+
+	// Output formats
+	FormatHtml = "html"
+	FormatText = "text"
+	FormatJson = "json"
+
+	// Granularity of the changes shown within lines
+	GranularityLine = "line"
+	GranularityWord = "word"
+	GranularityChar = "char"
+
+	// UnifiedTimeFormat timestamp in unified diff header, same as gnu diff
+	UnifiedTimeFormat = "2006-01-02 15:04:05.000000000 -0700"
 )
 
 // Error Messages
@@ -101,50 +118,71 @@ const (
 	MsgFileTooBig     = "File too big"
 	MsgThisIsDir      = "This is a directory"
 	MsgThisIsFile     = "This is a file"
+	MsgNoNewlineAtEOF = "\\ No newline at end of file"
+	MsgRenamedFrom    = "Renamed from %s (%d%% similar)"
+	MsgMergeConflicts = "%d conflicting changes"
+)
+
+// Exit codes, same as diff
+const (
+	ExitSame      = 0 // no differences found
+	ExitDifferent = 1 // some files are different
+	ExitTrouble   = 2 // some files could not be compared
 )
 
 // FileData file data
 type FileData struct {
-	name     string
-	info     os.FileInfo
-	osFile   *os.File
-	errorMsg string
-	isBinary bool
-	isMapped bool
-	data     []byte
+	name      string
+	info      os.FileInfo
+	osFile    *os.File
+	errorMsg  string
+	isBinary  bool
+	noNewline bool   // last line does not end with a newline
+	eols      []byte // line ending of each line: EolLF, EolCRLF, EolCR or EolNone
+	bom       bool   // the file starts with a byte order mark
+	encoding  string // character encoding, converted to UTF-8
+	isMapped  bool
+	data      []byte
+	raw       []byte // bytes of the file before data was converted, to output the lines as they are
 }
 
 // OutputFormat Output to diff as html or text format
 type OutputFormat struct {
+	cfg                  *DiffConfig
 	buf1, buf2           bytes.Buffer
 	name1, name2         string
 	fileInfo1, fileInfo2 os.FileInfo
+	encoding1, encoding2 string // shown in the html header, if not UTF-8
 	headerPrinted        bool
 	linenoWidth          int
+	message              string // message shown instead of the changes
+	note                 string // message shown with the changes, for differences in line endings
+	isError              bool
+	page                 *ReportPage // section of the html report for this pair of files
 }
 
-const (
-	DiffOpSame   = 1
-	DiffOpModify = 2
-	DiffOpInsert = 3
-	DiffOpRemove = 4
-)
+// DiffChangerData Data use by DiffChanger
+type DiffChangerData struct {
+	*OutputFormat
+	file1, file2           [][]byte
+	first1, first2         int  // line number of file1[0] and file2[0], only the lines being output are held for large files
+	noNewline1, noNewline2 bool // last line of file does not end with a newline
+}
 
-type DiffOp struct {
-	op           int
-	start1, end1 int
-	start2, end2 int
+// changerData gives access to the lines of a DiffChanger, to replace them while the changes are output
+type changerData interface {
+	data() *DiffChangerData
 }
 
-// DiffChanger Interface for report_diff() callbacks.
-type DiffChanger interface {
-	diffLines([]DiffOp)
+func (chg *DiffChangerData) data() *DiffChangerData {
+	return chg
 }
 
-// DiffChangerData Data use by DiffChanger
-type DiffChangerData struct {
-	*OutputFormat
-	file1, file2 [][]byte
+// The line numbers of op, as indexes in file1 and file2
+func (chg *DiffChangerData) window(v diff.DiffOp) diff.DiffOp {
+	v.Start1, v.End1 = v.Start1-chg.first1, v.End1-chg.first1
+	v.Start2, v.End2 = v.Start2-chg.first2, v.End2-chg.first2
+	return v
 }
 
 // DiffChangerText changes to be output in Text format
@@ -172,7 +210,7 @@ const HtmlHeader = `<!doctype html><html><head>
 
 const HtmlCss = `<style type="text/css">
 .tab {border-color:#808080; border-style:solid; border-width:1px 1px 1px 1px; border-collapse:collapse;}
-.tth {border-color:#808080; border-style:solid; border-width:1px 1px 1px 1px; border-collapse:collapse; padding:4px; vertical-align:top; text-align:left; background-color:#E0E0E0;}
+.tth {border-color:#808080; border-style:solid; border-width:1px 1px 1px 1px; border-collapse:collapse; padding:4px; vertical-align:top; text-align:left; background-color:#E0E0E0; position:sticky; top:1.8em; z-index:1;}
 .ttd {border-color:#808080; border-style:solid; border-width:1px 1px 1px 1px; border-collapse:collapse; padding:4px; vertical-align:top; text-align:left;}
 .hdr {color:black; font-size:85%;}
 .inf {color:#C08000; font-size:85%;}
@@ -185,8 +223,62 @@ const HtmlCss = `<style type="text/css">
 .add {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#CFFFCF; display:block;}
 .del {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#FFCFCF; display:block;}
 .chg {color:#C00080; background-color:#AFAFDF;}
+.cfl {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#FFDFAF; display:block;}
+.nav {position:sticky; top:0; z-index:2; height:1.8em; line-height:1.8em; background-color:white; font-size:85%;}
+.cur > td {outline:2px solid #C08000;}
+details.file > summary {cursor:pointer; padding:2px 0;}
 </style>`
 
+const HtmlNavigation = `<div class="nav"><a href="#" id="prev-change">&lt; Previous change</a> | <a href="#" id="next-change">Next change &gt;</a>
+ <span class="inf">(keys: n or j next change, p or k previous change)</span></div>
+`
+
+const HtmlScript = `<script>
+(function() {
+	// move the summary table to the top of the page
+	var summary = document.getElementById("summary"), top = document.getElementById("top");
+	if (summary && top) {
+		top.appendChild(summary);
+	}
+
+	// navigate between the groups of changes
+	var rows = [], cur = -1;
+	document.querySelectorAll("details.file tr").forEach(function(row) {
+		if (row.querySelector(".add, .del, .upd")) {
+			rows.push(row);
+		}
+	});
+	function go(n) {
+		if (rows.length == 0) {
+			return;
+		}
+		if (cur >= 0) {
+			rows[cur].classList.remove("cur");
+		}
+		cur = (n + rows.length) % rows.length;
+		var details = rows[cur].closest("details");
+		if (details) {
+			details.open = true;
+		}
+		rows[cur].classList.add("cur");
+		rows[cur].scrollIntoView({block: "center"});
+	}
+	document.addEventListener("keydown", function(e) {
+		if (e.ctrlKey || e.metaKey || e.altKey) {
+			return;
+		}
+		if (e.key == "n" || e.key == "j") {
+			go(cur + 1);
+		} else if (e.key == "p" || e.key == "k") {
+			go(cur - 1);
+		}
+	});
+	document.getElementById("next-change").onclick = function() { go(cur + 1); return false; };
+	document.getElementById("prev-change").onclick = function() { go(cur - 1); return false; };
+})();
+</script>
+`
+
 const HtmlLegend = `<br><b>Legend:</b><br><table class="tab">
 <tr><td class="tth"><span class="hdr">filename 1</span></td><td class="tth"><span class="hdr">filename 2</span></td></tr>
 <tr><td class="ttd">
@@ -204,25 +296,58 @@ const HtmlLegend = `<br><b>Legend:</b><br><table class="tab">
 
 // command line arguments
 var (
-	flagPprofFile            string
-	flagVersion              bool = false
-	flagCmpIgnoreCase        bool = false
-	flagCmpIgnoreBlankLines  bool = false
-	flagCmpIgnoreSpaceChange bool = false
-	flagCmpIgnoreAllSpace    bool = false
-	flagUnicodeCaseAndSpace  bool = false
-	flagShowIdenticalFiles   bool = false
-	flagSuppressLineChanges  bool = false
-	flagSuppressMissingFile  bool = false
-	flagOutputAsText         bool = false
-	flagUnifiedContext       bool = false
-	flagContextLines         int  = ContextLines
-	flagExcludeFiles         string
-	flagMaxGoroutines        = 1
+	flagPprofFile    string
+	flagVersion      bool = false
+	flagExcludeFiles string
+	flagRenames      bool = false
+	flagOutputAsText bool = false
+	flagThreeWay     bool = false
+	flagAlgorithm    string
+	flagColor        string
+	flagOutputFile   string
+	flagOutputDir    string
+	flagStat         bool = false
+	flagNumstat      bool = false
+	flagMaxSize      string
+	flagEncoding     string
+	flagRulesFile    string
 )
 
+// DiffConfig settings for a comparison, setup from the command line arguments.
+// Each comparison carries its own config, so differently configured diffs can run side by side.
+type DiffConfig struct {
+	cmpOptions          diff.Options   // Options for line comparison: -b -w -i -B -c etc.
+	showIdenticalFiles  bool           // Report when two files are the identical
+	suppressLineChanges bool           // Do not display changes within lines
+	granularity         string         // Changes within lines: line (none), word or char
+	suppressMissingFile bool           // Do not show content if corresponding file is missing
+	brief               bool           // Only report which files differ
+	stripTrailingCR     bool           // Ignore the differences in line endings
+	binary              bool           // Show the changes in binary files as hex
+	outputFormat        string         // Output format: html, text or json
+	unifiedContext      bool           // Unified context
+	sideBySide          bool           // Output in two columns, in text format
+	width               int            // Width of the side by side output
+	color               bool           // Use ANSI colours in text output
+	maxGoroutines       int            // Max number of goroutines to use for file comparison
+	maxSize             int64          // Larger files are compared without reading them into memory
+	encodings           [2]*Encoding   // Character encoding of each file, nil to detect it
+	excludeFiles        *regexp.Regexp // Files/Dirs to excludes
+	renameThreshold     int            // Minimum similarity (%) for files to be considered renamed
+	renames             *RenameList    // Files missing on the other side, collected for rename detection
+	report              *HtmlReport    // Sections of the html output, and pages written with -o-dir
+	stats               *DiffStat      // Number of changed lines for each file, instead of the changes, with -stat or -numstat
+	exitStatus          int            // ExitSame, ExitDifferent or ExitTrouble, updated by all goroutines
+	exitStatusLock      sync.Mutex
+
+	// zip and tar files compared as directories, by name
+	archives     map[string]ArchiveFS
+	archivesLock sync.Mutex
+}
+
 // JobQueue for goroutines
 type JobQueue struct {
+	cfg          *DiffConfig
 	name1, name2 string
 	info1, info2 os.FileInfo
 }
@@ -233,9 +358,6 @@ var (
 	jobWait  sync.WaitGroup
 )
 
-// Files/Dirs to excludes
-var regexpExcludeFiles *regexp.Regexp
-
 // Buffered stdout
 var (
 	out     = bufio.NewWriterSize(os.Stdout, OutputBufSize)
@@ -251,15 +373,6 @@ var (
 	htmlEntityDQuote = html.EscapeString("\"")
 )
 
-// functions to compare line and computer hash values,
-// these will be setup based on flags: -b -w -U etc.
-var (
-	compareLine func([]byte, []byte) bool
-	computeHash func([]byte) uint32
-)
-
-var blankLine = make([]byte, 0)
-
 func version() {
 	fmt.Printf("godiff. Version %s\n", VERSION)
 	fmt.Printf("Copyright (C) 2012 Siu Pin Chao.\n")
@@ -271,6 +384,9 @@ func usage(msg string) {
 	}
 	fmt.Fprint(os.Stderr, "A text file comparison tool displaying differences in HTML\n\n")
 	fmt.Fprint(os.Stderr, "usage: godiff <options> <file|dir> <file|dir>\n")
+	fmt.Fprint(os.Stderr, "       godiff -3 <options> base mine theirs\n")
+	fmt.Fprint(os.Stderr, "       godiff merge <options> base mine theirs\n")
+	fmt.Fprint(os.Stderr, "       godiff patch <options> [dir] < changes.diff\n")
 	fmt.Fprint(os.Stderr, "\n<options>\n")
 	flag.PrintDefaults()
 	os.Exit(2)
@@ -283,28 +399,80 @@ func usage0() {
 // Main routine.
 func main() {
 
+	// sub-commands
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		os.Exit(patchMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Exit(mergeMain(os.Args[2:]))
+	}
+
+	os.Exit(diffMain())
+}
+
+// Compare two files or directories. Returns the exit code, same as diff:
+// 0 no differences, 1 differences found, 2 on trouble.
+func diffMain() int {
+
+	cfg := &DiffConfig{
+		cmpOptions:      diff.Options{ContextLines: diff.DefaultContextLines},
+		maxGoroutines:   1,
+		renameThreshold: DefaultRenameThreshold,
+		outputFormat:    FormatHtml,
+		granularity:     GranularityChar,
+		width:           DefaultWidth,
+		maxSize:         DefaultMaxSize,
+	}
+
+	// use the width of the terminal if known
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		cfg.width = columns
+	}
+
 	// setup command line options
 	flag.Usage = usage0
 	flag.StringVar(&flagPprofFile, "prof", "", "Write pprof output to file")
 	flag.StringVar(&flagExcludeFiles, "X", "", "Exclude files/directories matching this regexp pattern")
 	flag.BoolVar(&flagVersion, "v", flagVersion, "Print version information")
-	flag.IntVar(&flagContextLines, "c", flagContextLines, "Include N lines of context before and after changes")
-	flag.IntVar(&flagMaxGoroutines, "g", flagMaxGoroutines, "Max number of goroutines to use for file comparison")
-	flag.BoolVar(&flagCmpIgnoreSpaceChange, "b", flagCmpIgnoreSpaceChange, "Ignore changes in the amount of white space")
-	flag.BoolVar(&flagCmpIgnoreAllSpace, "w", flagCmpIgnoreAllSpace, "Ignore all white space")
-	flag.BoolVar(&flagCmpIgnoreCase, "i", flagCmpIgnoreCase, "Ignore case differences in file contents")
-	flag.BoolVar(&flagCmpIgnoreBlankLines, "B", flagCmpIgnoreBlankLines, "Ignore changes whose lines are all blank")
-	flag.BoolVar(&flagUnicodeCaseAndSpace, "unicode", flagUnicodeCaseAndSpace, "Apply unicode rules for white space and upper/lower case")
-	flag.BoolVar(&flagShowIdenticalFiles, "s", flagShowIdenticalFiles, "Report when two files are the identical")
-	flag.BoolVar(&flagSuppressLineChanges, "l", flagSuppressLineChanges, "Do not display changes within lines")
-	flag.BoolVar(&flagSuppressMissingFile, "m", flagSuppressMissingFile, "Do not show content if corresponding file is missing")
-	flag.BoolVar(&flagUnifiedContext, "u", flagUnifiedContext, "Unified context")
-	flag.BoolVar(&flagOutputAsText, "n", flagOutputAsText, "Output using 'diff' text format instead of HTML")
+	flag.IntVar(&cfg.cmpOptions.ContextLines, "c", cfg.cmpOptions.ContextLines, "Include N lines of context before and after changes")
+	flag.IntVar(&cfg.maxGoroutines, "g", cfg.maxGoroutines, "Max number of goroutines to use for file comparison")
+	flag.BoolVar(&cfg.cmpOptions.IgnoreSpaceChange, "b", cfg.cmpOptions.IgnoreSpaceChange, "Ignore changes in the amount of white space")
+	flag.BoolVar(&cfg.cmpOptions.IgnoreAllSpace, "w", cfg.cmpOptions.IgnoreAllSpace, "Ignore all white space")
+	flag.BoolVar(&cfg.cmpOptions.IgnoreCase, "i", cfg.cmpOptions.IgnoreCase, "Ignore case differences in file contents")
+	flag.BoolVar(&cfg.cmpOptions.IgnoreBlankLines, "B", cfg.cmpOptions.IgnoreBlankLines, "Ignore changes whose lines are all blank")
+	flag.Var((*regexpList)(&cfg.cmpOptions.IgnoreMatching), "I", "Ignore changes whose lines all match this regexp pattern, can be repeated")
+	flag.StringVar(&flagRulesFile, "rules", "", "Replace text before comparing lines, with the 'pattern => replacement' rules in this file")
+	flag.BoolVar(&cfg.cmpOptions.UnicodeCaseAndSpace, "unicode", cfg.cmpOptions.UnicodeCaseAndSpace, "Apply unicode rules for white space and upper/lower case")
+	flag.BoolVar(&cfg.showIdenticalFiles, "s", cfg.showIdenticalFiles, "Report when two files are the identical")
+	flag.BoolVar(&cfg.suppressLineChanges, "l", cfg.suppressLineChanges, "Do not display changes within lines, same as -granularity line")
+	flag.StringVar(&cfg.granularity, "granularity", cfg.granularity, "Show changes within lines by: line (no changes), word or char")
+	flag.BoolVar(&cfg.suppressMissingFile, "m", cfg.suppressMissingFile, "Do not show content if corresponding file is missing")
+	flag.BoolVar(&cfg.brief, "q", cfg.brief, "Only report which files differ, same as diff -q")
+	flag.BoolVar(&cfg.stripTrailingCR, "strip-trailing-cr", cfg.stripTrailingCR, "Ignore the differences between CRLF, CR and LF line endings")
+	flag.BoolVar(&cfg.binary, "binary", cfg.binary, "Show the changes in binary files as hex and ascii, instead of only reporting that they differ")
+	flag.BoolVar(&cfg.unifiedContext, "u", cfg.unifiedContext, "Unified context")
+	flag.BoolVar(&cfg.sideBySide, "y", cfg.sideBySide, "Output in two columns, side by side, in the terminal")
+	flag.IntVar(&cfg.width, "width", cfg.width, "Output at most N columns for side by side output")
+	flag.StringVar(&flagColor, "color", ColorAuto, "Use colours in text output: auto (when writing to a terminal, and NO_COLOR is not set), always or never")
+	flag.BoolVar(&flagOutputAsText, "n", flagOutputAsText, "Output using 'diff' text format instead of HTML, same as -format text")
+	flag.StringVar(&flagOutputFile, "o", "", "Write output to this file instead of stdout")
+	flag.StringVar(&flagOutputDir, "o-dir", "", "Write a html page for each pair of files, and an index page, into this directory")
+	flag.StringVar(&cfg.outputFormat, "format", cfg.outputFormat, "Output format: html, text or json (one JSON record per line)")
+	flag.BoolVar(&flagRenames, "rename", flagRenames, "Detect renamed and moved files when comparing directories")
+	flag.IntVar(&cfg.renameThreshold, "rename-threshold", cfg.renameThreshold, "Minimum similarity (%) of renamed files")
+	flag.StringVar(&flagAlgorithm, "algorithm", "myers", "Diff algorithm: myers, patience or histogram")
+	flag.BoolVar(&cfg.cmpOptions.Minimal, "minimal", cfg.cmpOptions.Minimal, "Always find the minimal differences, even for large files that are very different")
+	flag.IntVar(&cfg.cmpOptions.CostLimit, "cost-limit", cfg.cmpOptions.CostLimit, "Stop searching for the minimal differences after N edits, 0 to choose from the size of the files")
+	flag.BoolVar(&flagThreeWay, "3", flagThreeWay, "Three-way comparison of base, mine and theirs files")
+	flag.StringVar(&flagMaxSize, "max-size", "100M", "Compare files larger than this (in bytes, or with a K, M or G suffix) without reading them into memory. Stdin, pipes, compressed files and files in archives larger than this are refused")
+	flag.StringVar(&flagEncoding, "encoding", EncodingAuto, "Character encoding of the files: auto, or an encoding such as utf-16le, latin1 or shift_jis. Use enc1,enc2 for a different encoding for each file")
+	flag.BoolVar(&flagStat, "stat", flagStat, "Only show the number of lines added and removed in each file, same as git diff --stat")
+	flag.BoolVar(&flagNumstat, "numstat", flagNumstat, "Same as -stat, in a tab separated format for use by other programs")
 	flag.Parse()
 
 	if flagVersion {
 		version()
-		os.Exit(0)
+		return 0
 	}
 
 	// write pprof info
@@ -322,26 +490,79 @@ func main() {
 		if err != nil {
 			usage("Invalid exclude regex: " + err.Error())
 		}
-		regexpExcludeFiles = r
+		cfg.excludeFiles = r
+	}
+
+	if flagRulesFile != "" {
+		rules, err := loadRules(flagRulesFile)
+		if err != nil {
+			usage(err.Error())
+		}
+		cfg.cmpOptions.Substitutions = rules
+	}
+
+	if flagOutputFile != "" && flagOutputDir != "" {
+		usage("Only one of -o and -o-dir can be used")
+	}
+
+	if size, err := parseSize(flagMaxSize); err != nil {
+		usage("Invalid max-size: " + flagMaxSize)
+	} else {
+		cfg.maxSize = size
+	}
+
+	if encodings, err := parseEncodings(flagEncoding); err != nil {
+		usage(err.Error())
+	} else {
+		cfg.encodings = encodings
+	}
+
+	if algorithm, err := diff.ParseAlgorithm(flagAlgorithm); err != nil {
+		usage(err.Error())
+	} else {
+		cfg.cmpOptions.Algorithm = algorithm
 	}
 
+	var outFile *os.File
+
 	// flush output on termination
 	defer func() {
 		out.Flush()
+		if outFile != nil {
+			outFile.Close()
+		}
 	}()
 
-	// choose which compare and hash function to use
-	if flagCmpIgnoreCase || flagCmpIgnoreSpaceChange || flagCmpIgnoreAllSpace {
-		if flagUnicodeCaseAndSpace {
-			computeHash = computeHashUnicode
-			compareLine = compareLineUnicode
-		} else {
-			computeHash = computeHashBytes
-			compareLine = compareLineBytes
-		}
+	if cfg.brief && (flagStat || flagNumstat) {
+		usage("Only one of -q and -stat can be used")
+	}
+
+	if flagOutputAsText || cfg.sideBySide || cfg.brief || flagStat || flagNumstat {
+		cfg.outputFormat = FormatText
+	}
+
+	if color, ok := useColor(flagColor, flagOutputFile != "" || flagOutputDir != ""); !ok {
+		usage("Invalid color option: " + flagColor)
 	} else {
-		computeHash = computeHashExact
-		compareLine = bytes.Equal
+		cfg.color = color && cfg.outputFormat == FormatText
+	}
+
+	switch cfg.outputFormat {
+	case FormatHtml, FormatText, FormatJson:
+	default:
+		usage("Invalid output format: " + cfg.outputFormat)
+	}
+
+	if cfg.suppressLineChanges {
+		cfg.granularity = GranularityLine
+	}
+
+	switch cfg.granularity {
+	case GranularityLine:
+		cfg.suppressLineChanges = true
+	case GranularityWord, GranularityChar:
+	default:
+		usage("Invalid granularity: " + cfg.granularity)
 	}
 
 	// get command line args
@@ -350,6 +571,28 @@ func main() {
 		usage("Missing files")
 	}
 
+	if flagThreeWay {
+		if len(args) != 3 {
+			usage("Three files required: base mine theirs")
+		}
+		if cfg.outputFormat == FormatJson {
+			usage("JSON output is not supported for three-way comparison")
+		}
+		if flagOutputDir != "" {
+			usage("-o-dir is not supported for three-way comparison")
+		}
+		f, err := openOutput()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return ExitTrouble
+		}
+		outFile = f
+		if !diff3Files(cfg, args) {
+			return ExitTrouble
+		}
+		return cfg.exitStatus
+	}
+
 	if len(args) > 2 {
 		usage("Too many files")
 	}
@@ -358,8 +601,8 @@ func main() {
 	file1, file2 := args[0], args[1]
 
 	// check file type
-	finfo1, err1 := os.Stat(file1)
-	finfo2, err2 := os.Stat(file2)
+	finfo1, err1 := statFile(file1)
+	finfo2, err2 := statFile(file2)
 
 	// Unable to find either file/directory
 	if err1 != nil || err2 != nil {
@@ -369,206 +612,90 @@ func main() {
 		if err2 != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err2.Error())
 		}
-		os.Exit(1)
+		return ExitTrouble
 	}
 
-	if finfo1.IsDir() != finfo2.IsDir() {
-		usage("Unable to compare file and directory")
+	// zip and tar files are compared as directories, with another archive or a directory
+	if archive1, err := openArchive(file1, finfo1, cfg.maxSize); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file1, err.Error())
+		return ExitTrouble
+	} else if archive2, err := openArchive(file2, finfo2, cfg.maxSize); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file2, err.Error())
+		return ExitTrouble
+	} else {
+		if archive1 != nil && (archive2 != nil || finfo2.IsDir()) {
+			cfg.addArchive(strings.TrimRight(file1, PathSeparator), archive1)
+			finfo1 = &archiveRootInfo{finfo1}
+		} else if archive1 != nil {
+			archive1.Close()
+		}
+		if archive2 != nil && (archive1 != nil || finfo1.IsDir()) {
+			cfg.addArchive(strings.TrimRight(file2, PathSeparator), archive2)
+			finfo2 = &archiveRootInfo{finfo2}
+		} else if archive2 != nil {
+			archive2.Close()
+		}
 	}
+	defer cfg.closeArchives()
 
-	if !flagOutputAsText {
-		out.WriteString(HtmlHeader)
-		fmt.Fprintf(out, "<title>Compare %s vs %s</title>\n", html.EscapeString(file1), html.EscapeString(file2))
-		out.WriteString(HtmlCss)
-		out.WriteString("</head><body>\n")
-		fmt.Fprintf(out, "<p>Compare <strong>%s</strong> vs <strong>%s</strong></p>\n", html.EscapeString(file1), html.EscapeString(file2))
+	if finfo1.IsDir() != finfo2.IsDir() {
+		cfg.closeArchives() // usage() exits without the deferred calls
+		usage("Unable to compare file and directory")
 	}
 
 	switch {
-	case !finfo1.IsDir() && !finfo2.IsDir():
-		diffFile(file1, file2, finfo1, finfo2)
-
-	case finfo1.IsDir() && finfo2.IsDir():
-		jobQueueInit()
-		diffDirs(file1, file2, finfo1, finfo2)
-		jobQueueFinish()
-	}
-
-	if !flagOutputAsText {
-		fmt.Fprintf(out, "Generated on %s<br>", time.Now().Format(time.RFC1123))
-		out.WriteString(HtmlLegend)
-		out.WriteString("</body></html>\n")
-	}
-}
-
-// Call the diff algorithm.
-func doDiff(data1, data2 []int) ([]bool, []bool) {
-	len1, len2 := len(data1), len(data2)
-	change1, change2 := make([]bool, len1), make([]bool, len2)
-
-	size := (len1+len2+1)*2 + 2
-	v := make([]int, size*2)
-
-	// Run diff compare algorithm.
-	algorithmLcs(data1, data2, change1, change2, v)
-
-	return change1, change2
-}
-
-// Find the beginning/end of this 'changed' segment
-func nextChangeSegment(start int, change []bool, data []int) (int, int, int) {
-
-	// find the end of this changes segment
-	end := start + 1
-	for end < len(change) && change[end] {
-		end++
-	}
-
-	// skip blank lines in the begining and end of the changes
-	i, j := start, end
-	for i < end && data[i] == 0 {
-		i++
-	}
-	for j > i && data[j-1] == 0 {
-		j--
+	case flagNumstat:
+		cfg.stats = &DiffStat{format: StatFormatNumstat}
+	case flagStat:
+		cfg.stats = &DiffStat{format: StatFormatStat}
 	}
 
-	return end, i, j
-}
-
-// Add segment to the group of changes. Add context lines before and after if necessary
-func addChangeSegment(chg DiffChanger, ops []DiffOp, op DiffOp) []DiffOp {
-	last1, last2 := 0, 0
-	if len(ops) > 0 {
-		lastOp := ops[len(ops)-1]
-		last1, last2 = lastOp.end1, lastOp.end2
+	if cfg.stats != nil && finfo1.IsDir() {
+		cfg.stats.root1 = strings.TrimRight(file1, PathSeparator)
+		cfg.stats.root2 = strings.TrimRight(file2, PathSeparator)
 	}
 
-	gap1, gap2 := op.start1-last1, op.start2-last2
-	if len(ops) > 0 && (op.op == 0 || (gap1 > flagContextLines*2 && gap2 > flagContextLines*2)) {
-		e1, e2 := minInt(op.start1, last1+flagContextLines), minInt(op.start2, last2+flagContextLines)
-		if e1 > last1 || e2 > last2 {
-			ops = append(ops, DiffOp{DiffOpSame, last1, e1, last2, e2})
-		}
-		chg.diffLines(ops)
-		ops = ops[:0]
+	if flagOutputDir != "" && cfg.outputFormat != FormatHtml {
+		usage("-o-dir is only supported for html output")
 	}
-
-	c1, c2 := maxInt(last1, op.start1-flagContextLines), maxInt(last2, op.start2-flagContextLines)
-	if c1 < op.start1 || c2 < op.start2 {
-		ops = append(ops, DiffOp{DiffOpSame, c1, op.start1, c2, op.start2})
+	f, err := openOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return ExitTrouble
 	}
+	outFile = f
 
-	if op.op != 0 {
-		ops = append(ops, op)
+	if cfg.outputFormat == FormatHtml {
+		cfg.report = &HtmlReport{dir: flagOutputDir}
+		writeHtmlHeader(file1, file2)
 	}
-	return ops
-}
 
-// Report diff changes.
-// For each group of change, call the diff_lines() function
-func reportDiff(chg DiffChanger, data1, data2 []int, change1, change2 []bool) bool {
-	len1, len2 := len(change1), len(change2)
-	i1, i2 := 0, 0
-	ops := make([]DiffOp, 0, 16)
-	changed := false
-	var m1start, m1end, m2start, m2end int
-
-	// scan for changes
-	for i1 < len1 || i2 < len2 {
-		switch {
-		// no change, advance both i1 and i2 to next set of changes
-		case i1 < len1 && i2 < len2 && !change1[i1] && !change2[i2]:
-			i1++
-			i2++
-
-		// change in both lists
-		case i1 < len1 && i2 < len2 && change1[i1] && change2[i2]:
-			i1, m1start, m1end = nextChangeSegment(i1, change1, data1)
-			i2, m2start, m2end = nextChangeSegment(i2, change2, data2)
-
-			opMode := 0
-			switch {
-			case m1start < m1end && m2start < m2end:
-				opMode = DiffOpModify
-			case m1start < m1end:
-				opMode = DiffOpRemove
-			case m2start < m2end:
-				opMode = DiffOpInsert
-			}
-			if opMode != 0 {
-				ops = addChangeSegment(chg, ops, DiffOp{opMode, m1start, m1end, m2start, m2end})
-				changed = true
-			}
-
-		case i1 < len1 && change1[i1]:
-			i1, m1start, m1end = nextChangeSegment(i1, change1, data1)
-			if m1start < m1end {
-				ops = addChangeSegment(chg, ops, DiffOp{DiffOpRemove, m1start, m1end, i2, i2})
-				changed = true
-			}
-
-		case i2 < len2 && change2[i2]:
-			i2, m2start, m2end = nextChangeSegment(i2, change2, data2)
-			if m2start < m2end {
-				ops = addChangeSegment(chg, ops, DiffOp{DiffOpInsert, i1, i1, m2start, m2end})
-				changed = true
-			}
+	switch {
+	case !finfo1.IsDir() && !finfo2.IsDir():
+		diffFile(cfg, file1, file2, finfo1, finfo2)
 
-		default: // should not reach here
-			return true
+	case finfo1.IsDir() && finfo2.IsDir():
+		if flagRenames {
+			cfg.renames = newRenameList(file1, file2)
 		}
+		jobQueueInit(cfg)
+		diffDirs(cfg, file1, file2, finfo1, finfo2)
+		if cfg.renames != nil {
+			diffRenames(cfg)
+		}
+		jobQueueFinish(cfg)
 	}
-	if len(ops) > 0 {
-		addChangeSegment(chg, ops, DiffOp{0, len1, len1, len2, len2})
-	}
-	return changed
-}
 
-// convert byte to lower case
-func toLowerByte(b byte) byte {
-	if b >= 'A' && b <= 'Z' {
-		return b - 'A' + 'a'
+	if cfg.stats != nil {
+		cfg.stats.write(cfg)
 	}
-	return b
-}
-
-// split text into array of individual rune position, and another array for comparison.
-func splitRunes(s []byte) ([]int, []int) {
-
-	pos := make([]int, len(s)+1)
-	cmp := make([]int, len(s))
-
-	var h, i, n int
 
-	for i < len(s) {
-		pos[n] = i
-		b := s[i]
-		if b < utf8.RuneSelf {
-			if flagCmpIgnoreCase {
-				if flagUnicodeCaseAndSpace {
-					h = int(unicode.ToLower(rune(b)))
-				} else {
-					h = int(toLowerByte(b))
-				}
-			} else {
-				h = int(b)
-			}
-			i++
-		} else {
-			r, rSize := utf8.DecodeRune(s[i:])
-			if flagCmpIgnoreCase && flagUnicodeCaseAndSpace {
-				h = int(unicode.ToLower(r))
-			} else {
-				h = int(r)
-			}
-			i += rSize
-		}
-		cmp[n] = h
-		n = n + 1
+	if cfg.outputFormat == FormatHtml {
+		cfg.report.writeSummary()
+		writeHtmlFooter()
 	}
-	pos[n] = i
-	return pos[:n+1], cmp[:n]
+
+	return cfg.exitStatus
 }
 
 // Write bytes to buffer, ready to be output as html,
@@ -610,25 +737,46 @@ func htmlPreviewFile(buf *bytes.Buffer, lines [][]byte) {
 	buf.WriteString("</span></span>")
 }
 
-func outputDiffMessageContent(filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, data1, data2 [][]byte, isError bool) {
+// numLines is the number of lines of the file that exists, data1 or data2 only holds the first lines of large files
+func outputDiffMessageContent(cfg *DiffConfig, filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, data1, data2 [][]byte, numLines int, isError bool) {
+
+	if msg1 != MsgFileIdentical || msg2 != MsgFileIdentical {
+		cfg.setExitStatus(ExitDifferent)
+	}
+
+	if cfg.stats != nil {
+		cfg.addStatMessage(filename1, filename2, info1, info2, msg1, msg2, numLines)
+		return
+	}
 
-	if flagOutputAsText {
-		outAcquireLock()
-		if flagUnifiedContext {
-			fmt.Fprintf(out, "<<< %s: %s\n", filename1, msg1)
-			fmt.Fprintf(out, ">>> %s: %s\n\n", filename2, msg2)
+	switch cfg.outputFormat {
+	case FormatText:
+		cfg.outAcquireLock()
+		if cfg.unifiedContext || cfg.brief {
+			outputUnifiedMessage(filename1, filename2, msg1, msg2)
 		} else {
 			fmt.Fprintf(out, "--- %s: %s\n", filename1, msg1)
 			fmt.Fprintf(out, "+++ %s: %s\n\n", filename2, msg2)
 		}
-		outReleaseLock()
-	} else {
+		cfg.outReleaseLock()
+
+	case FormatJson:
+		outputJsonRecord(cfg, &JsonRecord{
+			Status: jsonStatus(msg1, msg2, isError),
+			File1:  newJsonFile(filename1, info1, msg1),
+			File2:  newJsonFile(filename2, info2, msg2),
+		})
+
+	default:
 
 		outfmt := OutputFormat{
+			cfg:       cfg,
 			name1:     filename1,
 			name2:     filename2,
 			fileInfo1: info1,
 			fileInfo2: info2,
+			message:   joinMessages(msg1, msg2),
+			isError:   isError,
 		}
 
 		var span string
@@ -656,6 +804,14 @@ func outputDiffMessageContent(filename1, filename2 string, info1, info2 os.FileI
 
 		htmlFileTable(&outfmt)
 
+		// a missing file counts as all lines added or removed
+		if outfmt.page != nil && info1 == nil {
+			outfmt.page.added = numLines
+		}
+		if outfmt.page != nil && info2 == nil {
+			outfmt.page.removed = numLines
+		}
+
 		out.WriteString("<tr><td class=\"ttd\">")
 		out.Write(outfmt.buf1.Bytes())
 
@@ -663,13 +819,47 @@ func outputDiffMessageContent(filename1, filename2 string, info1, info2 os.FileI
 		out.Write(outfmt.buf2.Bytes())
 
 		out.WriteString("</td></tr>\n")
-		out.WriteString("</table><br>\n")
-		outReleaseLock()
+		htmlFileTableEnd(&outfmt)
+	}
+}
+
+// Single message for both files
+func joinMessages(msg1, msg2 string) string {
+	switch {
+	case msg1 == msg2 || msg2 == "":
+		return msg1
+	case msg1 == "":
+		return msg2
+	}
+	return msg1 + " / " + msg2
+}
+
+// Messages in unified text format, using the same wording as gnu diff where possible,
+// so the output can be given to patch
+func outputUnifiedMessage(filename1, filename2 string, msg1, msg2 string) {
+	switch {
+	case msg2 == MsgFileNotExists || msg2 == MsgDirNotExists:
+		fmt.Fprintf(out, "Only in %s: %s\n", filepath.Dir(filename1), filepath.Base(filename1))
+	case msg1 == MsgFileNotExists || msg1 == MsgDirNotExists:
+		fmt.Fprintf(out, "Only in %s: %s\n", filepath.Dir(filename2), filepath.Base(filename2))
+	case msg1 == MsgFileIdentical:
+		fmt.Fprintf(out, "Files %s and %s are identical\n", filename1, filename2)
+	case msg1 == MsgFileDiffers && msg2 == MsgFileDiffers:
+		fmt.Fprintf(out, "Files %s and %s differ\n", filename1, filename2)
+	case msg1 == MsgBinFileDiffers || msg2 == MsgBinFileDiffers:
+		fmt.Fprintf(out, "Binary files %s and %s differ\n", filename1, filename2)
+	case msg1 == MsgThisIsDir && msg2 == MsgThisIsFile:
+		fmt.Fprintf(out, "File %s is a directory while file %s is a regular file\n", filename1, filename2)
+	case msg1 == MsgThisIsFile && msg2 == MsgThisIsDir:
+		fmt.Fprintf(out, "File %s is a regular file while file %s is a directory\n", filename1, filename2)
+	default:
+		fmt.Fprintf(out, "<<< %s: %s\n", filename1, msg1)
+		fmt.Fprintf(out, ">>> %s: %s\n\n", filename2, msg2)
 	}
 }
 
-func outputDiffMessage(filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, isError bool) {
-	outputDiffMessageContent(filename1, filename2, info1, info2, msg1, msg2, nil, nil, isError)
+func outputDiffMessage(cfg *DiffConfig, filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, isError bool) {
+	outputDiffMessageContent(cfg, filename1, filename2, info1, info2, msg1, msg2, nil, nil, 0, isError)
 }
 
 func writeHtmlLineno(buf *bytes.Buffer, lineno, width int) {
@@ -760,67 +950,100 @@ func writeHtmlLineChange(buf *bytes.Buffer, line []byte, pos []int, change []boo
 	}
 }
 
+// Encoding of the file for the html header, UTF-8 is not shown
+func htmlEncoding(encoding string) string {
+	if encoding == "" || encoding == EncodingUTF8.name {
+		return ""
+	}
+	return " " + html.EscapeString(encoding)
+}
+
 func htmlFileTable(outFmt *OutputFormat) {
 
 	if !outFmt.headerPrinted {
-		outAcquireLock()
+		outFmt.cfg.outAcquireLock()
 		outFmt.headerPrinted = true
+		outFmt.cfg.beginPage(outFmt)
 		out.WriteString("<table class=\"tab\"><tr><td class=\"tth\"><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outFmt.name1))
 		out.WriteString("</span>")
 		if outFmt.fileInfo1 != nil {
-			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", outFmt.fileInfo1.Size(), outFmt.fileInfo1.ModTime().Format(time.RFC1123))
+			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s%s</span>", outFmt.fileInfo1.Size(), outFmt.fileInfo1.ModTime().Format(time.RFC1123), htmlEncoding(outFmt.encoding1))
 		}
 		out.WriteString("</td><td class=\"tth\"><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outFmt.name2))
 		out.WriteString("</span>")
 		if outFmt.fileInfo2 != nil {
-			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", outFmt.fileInfo2.Size(), outFmt.fileInfo2.ModTime().Format(time.RFC1123))
+			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s%s</span>", outFmt.fileInfo2.Size(), outFmt.fileInfo2.ModTime().Format(time.RFC1123), htmlEncoding(outFmt.encoding2))
 		}
 		out.WriteString("</td></tr>")
+		if outFmt.note != "" {
+			out.WriteString("<tr><td class=\"ttd\" colspan=\"2\"><span class=\"msg\">")
+			out.WriteString(html.EscapeString(outFmt.note))
+			out.WriteString("</span></td></tr>\n")
+		}
 	}
 }
 
 func htmlFileTableUnified(outFmt *OutputFormat) {
 
 	if !outFmt.headerPrinted {
-		outAcquireLock()
+		outFmt.cfg.outAcquireLock()
 		outFmt.headerPrinted = true
+		outFmt.cfg.beginPage(outFmt)
 		out.WriteString("<table class=\"tab\"><tr><td class=\"tth\"><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outFmt.name1))
 		out.WriteString("</span>")
 		if outFmt.fileInfo1 != nil {
-			fmt.Fprintf(out, " <span class=\"inf\">%d %s</span>", outFmt.fileInfo1.Size(), outFmt.fileInfo1.ModTime().Format(time.RFC1123))
+			fmt.Fprintf(out, " <span class=\"inf\">%d %s%s</span>", outFmt.fileInfo1.Size(), outFmt.fileInfo1.ModTime().Format(time.RFC1123), htmlEncoding(outFmt.encoding1))
 		}
 		out.WriteString("<br><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outFmt.name2))
 		out.WriteString("</span>")
 		if outFmt.fileInfo2 != nil {
-			fmt.Fprintf(out, " <span class=\"inf\">%d %s</span>", outFmt.fileInfo2.Size(), outFmt.fileInfo2.ModTime().Format(time.RFC1123))
+			fmt.Fprintf(out, " <span class=\"inf\">%d %s%s</span>", outFmt.fileInfo2.Size(), outFmt.fileInfo2.ModTime().Format(time.RFC1123), htmlEncoding(outFmt.encoding2))
 		}
 		out.WriteString("</td></tr>")
+		if outFmt.note != "" {
+			out.WriteString("<tr><td class=\"ttd\"><span class=\"msg\">")
+			out.WriteString(html.EscapeString(outFmt.note))
+			out.WriteString("</span></td></tr>\n")
+		}
 	}
 }
 
-func (chg *DiffChangerUnifiedHtml) diffLines(ops []DiffOp) {
+// Close the table started by htmlFileTable(), and release the output lock
+func htmlFileTableEnd(outFmt *OutputFormat) {
+	if outFmt.page != nil {
+		out.WriteString("</table>")
+		outFmt.cfg.endPage()
+	} else {
+		out.WriteString("</table><br>\n")
+	}
+	outFmt.headerPrinted = false
+	outFmt.cfg.outReleaseLock()
+}
+
+func (chg *DiffChangerUnifiedHtml) DiffLines(ops []diff.DiffOp) {
 
 	htmlFileTableUnified(chg.OutputFormat)
 	chg.buf1.Reset()
 
 	for _, v := range ops {
-		switch v.op {
-		case DiffOpInsert:
-			writeHtmlLinesUnified(&chg.buf1, "add", "+", chg.file2[v.start2:v.end2], -1, v.start2, chg.linenoWidth)
+		w := chg.window(v)
+		switch v.Op {
+		case diff.DiffOpInsert:
+			writeHtmlLinesUnified(&chg.buf1, "add", "+", chg.file2[w.Start2:w.End2], -1, v.Start2, chg.linenoWidth)
 
-		case DiffOpRemove:
-			writeHtmlLinesUnified(&chg.buf1, "del", "-", chg.file1[v.start1:v.end1], v.start1, -1, chg.linenoWidth)
+		case diff.DiffOpRemove:
+			writeHtmlLinesUnified(&chg.buf1, "del", "-", chg.file1[w.Start1:w.End1], v.Start1, -1, chg.linenoWidth)
 
-		case DiffOpModify:
-			writeHtmlLinesUnified(&chg.buf1, "del", "-", chg.file1[v.start1:v.end1], v.start1, -1, chg.linenoWidth)
-			writeHtmlLinesUnified(&chg.buf1, "add", "+", chg.file2[v.start2:v.end2], -1, v.start2, chg.linenoWidth)
+		case diff.DiffOpModify:
+			writeHtmlLinesUnified(&chg.buf1, "del", "-", chg.file1[w.Start1:w.End1], v.Start1, -1, chg.linenoWidth)
+			writeHtmlLinesUnified(&chg.buf1, "add", "+", chg.file2[w.Start2:w.End2], -1, v.Start2, chg.linenoWidth)
 
 		default:
-			writeHtmlLinesUnified(&chg.buf1, "nop", " ", chg.file1[v.start1:v.end1], v.start1, v.start2, chg.linenoWidth)
+			writeHtmlLinesUnified(&chg.buf1, "nop", " ", chg.file1[w.Start1:w.End1], v.Start1, v.Start2, chg.linenoWidth)
 		}
 	}
 
@@ -829,7 +1052,35 @@ func (chg *DiffChangerUnifiedHtml) diffLines(ops []DiffOp) {
 	out.WriteString("</td></tr>\n")
 }
 
-func (chg *DiffChangerHtml) diffLines(ops []DiffOp) {
+// Find the changes within a pair of modified lines.
+// Returns the rune (or word) positions and the changed runes (or words) for each line.
+func lineChanges(cfg *DiffConfig, line1, line2 []byte) ([]int, []bool, []int, []bool) {
+
+	if cfg.granularity == GranularityWord {
+		ids := make(map[string]int)
+		pos1, cmp1 := diff.SplitWords(line1, &cfg.cmpOptions, ids)
+		pos2, cmp2 := diff.SplitWords(line2, &cfg.cmpOptions, ids)
+
+		change1, change2 := diff.DoDiff(cmp1, cmp2)
+		diff.ShiftBoundaries(cmp1, change1, nil)
+		diff.ShiftBoundaries(cmp2, change2, nil)
+
+		return pos1, change1, pos2, change2
+	}
+
+	pos1, cmp1 := diff.SplitRunes(line1, &cfg.cmpOptions)
+	pos2, cmp2 := diff.SplitRunes(line2, &cfg.cmpOptions)
+
+	change1, change2 := diff.DoDiff(cmp1, cmp2)
+
+	// perform shift boundaries, to make the changes more readable
+	diff.ShiftBoundaries(cmp1, change1, diff.RuneBoundaryScore)
+	diff.ShiftBoundaries(cmp2, change2, diff.RuneBoundaryScore)
+
+	return pos1, change1, pos2, change2
+}
+
+func (chg *DiffChangerHtml) DiffLines(ops []diff.DiffOp) {
 
 	htmlFileTable(chg.OutputFormat)
 
@@ -837,45 +1088,37 @@ func (chg *DiffChangerHtml) diffLines(ops []DiffOp) {
 	chg.buf2.Reset()
 
 	for _, v := range ops {
-		switch v.op {
-		case DiffOpInsert:
-			writeHtmlBlanks(&chg.buf1, v.end2-v.start2)
-			writeHtmlLines(&chg.buf2, "add", chg.file2[v.start2:v.end2], v.start2, chg.linenoWidth)
+		w := chg.window(v)
+		switch v.Op {
+		case diff.DiffOpInsert:
+			writeHtmlBlanks(&chg.buf1, v.End2-v.Start2)
+			writeHtmlLines(&chg.buf2, "add", chg.file2[w.Start2:w.End2], v.Start2, chg.linenoWidth)
 
-		case DiffOpRemove:
-			writeHtmlLines(&chg.buf1, "del", chg.file1[v.start1:v.end1], v.start1, chg.linenoWidth)
-			writeHtmlBlanks(&chg.buf2, v.end1-v.start1)
+		case diff.DiffOpRemove:
+			writeHtmlLines(&chg.buf1, "del", chg.file1[w.Start1:w.End1], v.Start1, chg.linenoWidth)
+			writeHtmlBlanks(&chg.buf2, v.End1-v.Start1)
 
-		case DiffOpModify:
+		case diff.DiffOpModify:
 			chg.buf1.WriteString("<span class=\"upd\">")
 			chg.buf2.WriteString("<span class=\"upd\">")
 
-			start1, start2 := v.start1, v.start2
+			start1, start2 := v.Start1, v.Start2
 
-			for start1 < v.end1 && start2 < v.end2 {
+			for start1 < v.End1 && start2 < v.End2 {
 
 				writeHtmlLineno(&chg.buf1, start1+1, chg.linenoWidth)
 				writeHtmlLineno(&chg.buf2, start2+1, chg.linenoWidth)
 
-				if flagSuppressLineChanges {
-					writeHtmlBytes(&chg.buf1, chg.file1[start1])
-					writeHtmlBytes(&chg.buf2, chg.file2[start2])
+				if chg.cfg.suppressLineChanges {
+					writeHtmlBytes(&chg.buf1, chg.file1[start1-chg.first1])
+					writeHtmlBytes(&chg.buf2, chg.file2[start2-chg.first2])
 				} else {
 					// report on changes within the line
-					line1, line2 := chg.file1[start1], chg.file2[start2]
-					pos1, cmp1 := splitRunes(line1)
-					pos2, cmp2 := splitRunes(line2)
-
-					change1, change2 := doDiff(cmp1, cmp2)
+					line1, line2 := chg.file1[start1-chg.first1], chg.file2[start2-chg.first2]
+					pos1, change1, pos2, change2 := lineChanges(chg.cfg, line1, line2)
 
-					if change1 != nil {
-						// perform shift boundaries, to make the changes more readable
-						shiftBoundaries(cmp1, change1, runeBoundaryScore)
-						shiftBoundaries(cmp2, change2, runeBoundaryScore)
-
-						writeHtmlLineChange(&chg.buf1, line1, pos1, change1)
-						writeHtmlLineChange(&chg.buf2, line2, pos2, change2)
-					}
+					writeHtmlLineChange(&chg.buf1, line1, pos1, change1)
+					writeHtmlLineChange(&chg.buf2, line2, pos2, change2)
 				}
 
 				chg.buf1.WriteByte('\n')
@@ -887,29 +1130,29 @@ func (chg *DiffChangerHtml) diffLines(ops []DiffOp) {
 			chg.buf1.WriteString("</span>")
 			chg.buf2.WriteString("</span>")
 
-			if start1 < v.end1 {
-				writeHtmlLines(&chg.buf1, "del", chg.file1[start1:v.end1], start1, chg.linenoWidth)
-				writeHtmlBlanks(&chg.buf2, v.end1-start1)
+			if start1 < v.End1 {
+				writeHtmlLines(&chg.buf1, "del", chg.file1[start1-chg.first1:w.End1], start1, chg.linenoWidth)
+				writeHtmlBlanks(&chg.buf2, v.End1-start1)
 			}
 
-			if start2 < v.end2 {
-				writeHtmlBlanks(&chg.buf1, v.end2-start2)
-				writeHtmlLines(&chg.buf2, "add", chg.file2[start2:v.end2], start2, chg.linenoWidth)
+			if start2 < v.End2 {
+				writeHtmlBlanks(&chg.buf1, v.End2-start2)
+				writeHtmlLines(&chg.buf2, "add", chg.file2[start2-chg.first2:w.End2], start2, chg.linenoWidth)
 			}
 
 		default:
-			n1, n2 := v.end1-v.start1, v.end2-v.start2
+			n1, n2 := v.End1-v.Start1, v.End2-v.Start2
 			maxN := maxInt(n1, n2)
 
 			if n1 > 0 {
-				writeHtmlLines(&chg.buf1, "nop", chg.file1[v.start1:v.end1], v.start1, chg.linenoWidth)
+				writeHtmlLines(&chg.buf1, "nop", chg.file1[w.Start1:w.End1], v.Start1, chg.linenoWidth)
 			}
 			if n1 < maxN {
 				writeHtmlBlanks(&chg.buf1, maxN-n1)
 			}
 
 			if n2 > 0 {
-				writeHtmlLines(&chg.buf2, "nop", chg.file2[v.start2:v.end2], v.start2, chg.linenoWidth)
+				writeHtmlLines(&chg.buf2, "nop", chg.file2[w.Start2:w.End2], v.Start2, chg.linenoWidth)
 			}
 			if n2 < maxN {
 				writeHtmlBlanks(&chg.buf2, maxN-n2)
@@ -925,692 +1168,256 @@ func (chg *DiffChangerHtml) diffLines(ops []DiffOp) {
 
 }
 
-func (chg *DiffChangerUnifiedText) diffLines(ops []DiffOp) {
+// Line range for the unified hunk header, same as gnu diff.
+// An empty range is shown as the line before the range, so patch can handle diffs against empty files.
+func unifiedRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// File modification time for the unified header, in the same format as gnu diff
+func unifiedTimestamp(info os.FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	return "\t" + info.ModTime().Format(UnifiedTimeFormat)
+}
+
+// Write the names of the files, before the changes in text format
+func writeTextHeader(outFmt *OutputFormat) {
+	out.WriteString(outFmt.cfg.colored(AnsiBold, "<<< "+outFmt.name1))
+	out.WriteByte('\n')
+	out.WriteString(outFmt.cfg.colored(AnsiBold, ">>> "+outFmt.name2))
+	out.WriteByte('\n')
+	writeTextNote(outFmt.cfg, outFmt.note)
+}
+
+// Write lines[start:end] in text format, each line with a prefix.
+// Add a marker after the last line of the file if it does not end with a newline.
+func writeTextLines(prefix string, lines [][]byte, start, end int, noNewline bool) {
+	for i := start; i < end; i++ {
+		out.WriteString(prefix)
+		out.Write(lines[i])
+		out.WriteByte('\n')
+		if noNewline && i == len(lines)-1 {
+			out.WriteString(MsgNoNewlineAtEOF)
+			out.WriteByte('\n')
+		}
+	}
+}
+
+// Write the changed lines, with the changed words marked as [-removed-]{+added+} like git diff --word-diff.
+// Each modified line is paired up with a line from the other file.
+func writeWordDiffLines(cfg *DiffConfig, v diff.DiffOp, file1, file2 [][]byte) {
+	i1, i2 := v.Start1, v.Start2
+	for ; i1 < v.End1 && i2 < v.End2; i1, i2 = i1+1, i2+1 {
+		line1, line2 := file1[i1], file2[i2]
+		pos1, change1, pos2, change2 := lineChanges(cfg, line1, line2)
+		writeWordChanges(cfg, line1, line2, pos1, change1, pos2, change2)
+		out.WriteByte('\n')
+	}
+	for ; i1 < v.End1; i1++ {
+		writeWordChanges(cfg, file1[i1], nil, []int{0, len(file1[i1])}, []bool{true}, nil, nil)
+		out.WriteByte('\n')
+	}
+	for ; i2 < v.End2; i2++ {
+		writeWordChanges(cfg, nil, file2[i2], nil, nil, []int{0, len(file2[i2])}, []bool{true})
+		out.WriteByte('\n')
+	}
+}
+
+// Write a pair of lines as a single line, unchanged words are written once,
+// removed and added words are written as [-removed-] and {+added+}
+func writeWordChanges(cfg *DiffConfig, line1, line2 []byte, pos1 []int, change1 []bool, pos2 []int, change2 []bool) {
+	i1, i2 := 0, 0
+	for i1 < len(change1) || i2 < len(change2) {
+		if i1 < len(change1) && i2 < len(change2) && !change1[i1] && !change2[i2] {
+			out.Write(line2[pos2[i2]:pos2[i2+1]])
+			i1, i2 = i1+1, i2+1
+			continue
+		}
+
+		j1, j2 := i1, i2
+		for j1 < len(change1) && change1[j1] {
+			j1++
+		}
+		for j2 < len(change2) && change2[j2] {
+			j2++
+		}
+		if j1 == i1 && j2 == i2 {
+			break // should not reach here
+		}
+		if pos1 != nil && pos1[j1] > pos1[i1] {
+			out.WriteString(cfg.colored(AnsiDel, "[-"+string(line1[pos1[i1]:pos1[j1]])+"-]"))
+		}
+		if pos2 != nil && pos2[j2] > pos2[i2] {
+			out.WriteString(cfg.colored(AnsiAdd, "{+"+string(line2[pos2[i2]:pos2[j2]])+"+}"))
+		}
+		i1, i2 = j1, j2
+	}
+}
+
+func (chg *DiffChangerUnifiedText) DiffLines(ops []diff.DiffOp) {
 
 	if !chg.headerPrinted {
-		outAcquireLock()
+		chg.cfg.outAcquireLock()
 		chg.headerPrinted = true
-		fmt.Fprintf(out, "--- %s\n", chg.name1)
-		fmt.Fprintf(out, "+++ %s\n", chg.name2)
+		out.WriteString(chg.cfg.colored(AnsiBold, fmt.Sprintf("--- %s%s", chg.name1, unifiedTimestamp(chg.fileInfo1))))
+		out.WriteByte('\n')
+		out.WriteString(chg.cfg.colored(AnsiBold, fmt.Sprintf("+++ %s%s", chg.name2, unifiedTimestamp(chg.fileInfo2))))
+		out.WriteByte('\n')
 	}
 
-	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", ops[0].start1+1, ops[len(ops)-1].end1-ops[0].start1, ops[0].start2+1, ops[len(ops)-1].end2-ops[0].start2)
+	first, last := ops[0], ops[len(ops)-1]
+	out.WriteString(chg.cfg.colored(AnsiInf, fmt.Sprintf("@@ -%s +%s @@", unifiedRange(first.Start1, last.End1), unifiedRange(first.Start2, last.End2))))
+	out.WriteByte('\n')
 
-	for _, v := range ops {
-		switch v.op {
-		case DiffOpInsert, DiffOpRemove, DiffOpModify:
-			for _, line := range chg.file1[v.start1:v.end1] {
-				out.WriteString("- ")
-				out.Write(line)
-				out.WriteByte('\n')
-			}
-
-			for _, line := range chg.file2[v.start2:v.end2] {
-				out.WriteString("+ ")
-				out.Write(line)
-				out.WriteByte('\n')
-			}
+	wordDiff := chg.cfg.granularity == GranularityWord
 
+	for _, v := range ops {
+		// lines that are not reported as changes, but are not the same either (e.g. ignored blank lines)
+		// must still be output as changes, otherwise the hunk cannot be applied.
+		same := v.Op == diff.DiffOpSame && v.End1-v.Start1 == v.End2-v.Start2
+		w := chg.window(v)
+		switch {
+		case same && wordDiff:
+			writeTextLines("", chg.file1, w.Start1, w.End1, false)
+		case same:
+			writeTextLines(" ", chg.file1, w.Start1, w.End1, chg.noNewline1)
+		case wordDiff:
+			writeWordDiffLines(chg.cfg, w, chg.file1, chg.file2)
 		default:
-			for _, line := range chg.file1[v.start1:v.end1] {
-				out.WriteString("  ")
-				out.Write(line)
-				out.WriteByte('\n')
-			}
+			writeChangedLines(chg.cfg, "-", "+", "", w, chg.file1, chg.file2, chg.noNewline1, chg.noNewline2)
 		}
 	}
 }
 
-func printLineNumbers(mode string, start1, end1, start2, end2 int) {
+func lineNumbers(mode string, start1, end1, start2, end2 int) string {
+	var s string
 	if end1 < 0 || end1-start1 == 1 {
-		fmt.Fprintf(out, "%d%s", start1+1, mode)
+		s = fmt.Sprintf("%d%s", start1+1, mode)
 	} else {
-		fmt.Fprintf(out, "%d,%d%s", start1+1, end1, mode)
+		s = fmt.Sprintf("%d,%d%s", start1+1, end1, mode)
 	}
 	if end2 < 0 || end2-start2 == 1 {
-		fmt.Fprintf(out, "%d\n", start2+1)
+		s += fmt.Sprintf("%d", start2+1)
 	} else {
-		fmt.Fprintf(out, "%d,%d\n", start2+1, end2)
+		s += fmt.Sprintf("%d,%d", start2+1, end2)
 	}
+	return s
 }
 
-func (chg *DiffChangerText) diffLines(ops []DiffOp) {
+func (chg *DiffChangerText) DiffLines(ops []diff.DiffOp) {
 
 	if !chg.headerPrinted {
-		outAcquireLock()
+		chg.cfg.outAcquireLock()
 		chg.headerPrinted = true
-		fmt.Fprintf(out, "<<< %s\n", chg.name1)
-		fmt.Fprintf(out, ">>> %s\n", chg.name2)
+		writeTextHeader(chg.OutputFormat)
 	}
 
 	for _, v := range ops {
-		switch v.op {
-		case DiffOpSame:
+		var lines string
+		switch v.Op {
+		case diff.DiffOpSame:
 			continue
 
-		case DiffOpInsert:
-			printLineNumbers("a", v.start1-1, -1, v.start2, v.end2)
-
-		case DiffOpRemove:
-			printLineNumbers("d", v.start1, v.end1, v.start2-1, -1)
+		case diff.DiffOpInsert:
+			lines = lineNumbers("a", v.Start1-1, -1, v.Start2, v.End2)
 
-		case DiffOpModify:
-			printLineNumbers("c", v.start1, v.end1, v.start2, v.end2)
-		}
+		case diff.DiffOpRemove:
+			lines = lineNumbers("d", v.Start1, v.End1, v.Start2-1, -1)
 
-		for _, line := range chg.file1[v.start1:v.end1] {
-			out.WriteString("< ")
-			out.Write(line)
-			out.WriteByte('\n')
+		case diff.DiffOpModify:
+			lines = lineNumbers("c", v.Start1, v.End1, v.Start2, v.End2)
 		}
+		out.WriteString(chg.cfg.colored(AnsiInf, lines))
+		out.WriteByte('\n')
 
-		if v.end1 > v.start1 && v.end2 > v.start2 {
-			out.WriteString("---\n")
+		if chg.cfg.granularity == GranularityWord {
+			writeWordDiffLines(chg.cfg, chg.window(v), chg.file1, chg.file2)
+			continue
 		}
 
-		for _, line := range chg.file2[v.start2:v.end2] {
-			out.WriteString("> ")
-			out.Write(line)
-			out.WriteByte('\n')
-		}
+		writeChangedLines(chg.cfg, "< ", "> ", "---\n", chg.window(v), chg.file1, chg.file2, chg.noNewline1, chg.noNewline2)
 	}
 }
 
-// Test for space character
-func isSpace(b byte) bool {
-	return b == ' ' || b == '\t' || b == '\v' || b == '\f'
+// stdin is read once, it can be compared with itself
+var stdinData struct {
+	once sync.Once
+	data []byte
+	err  error
 }
 
-func skipSpaceRune(line []byte, i int) int {
-	for i < len(line) {
-		b, size := utf8.DecodeRune(line[i:])
-		if !unicode.IsSpace(b) {
-			return i
-		}
-		i += size
+// Same as os.Stat, "-" is stdin
+func statFile(fName string) (os.FileInfo, error) {
+	if fName == StdinName {
+		return os.Stdin.Stat()
 	}
-	return i
+	return os.Stat(fName)
 }
 
-// Get the next rune, and skip spaces after it
-func getNextRuneNonspace(line []byte, i int) (rune, int) {
-	b, size := utf8.DecodeRune(line[i:])
-	return b, skipSpaceRune(line, i+size)
+// Pipes and devices have no size, and cannot be mapped into memory
+func isStream(fName string, fInfo os.FileInfo) bool {
+	return fName == StdinName || fInfo.Mode()&(os.ModeNamedPipe|os.ModeCharDevice|os.ModeSocket) != 0
 }
 
-// Get the next rune, and determine if there is a space after it.
-// Also ignore trailing spaces at end-of-line
-func getNextRuneXspace(line []byte, i int) (rune, bool, int) {
-	b, size := utf8.DecodeRune(line[i:])
-	i += size
-	spaceAfter := false
-	for i < len(line) {
-		s, size := utf8.DecodeRune(line[i:])
-		if !unicode.IsSpace(s) {
-			break
+// read the entire content of stdin, a pipe or a device
+func (file *FileData) readStream(maxSize int64) {
+
+	var data []byte
+	var err error
+
+	if file.name == StdinName {
+		stdinData.once.Do(func() {
+			stdinData.data, stdinData.err = io.ReadAll(io.LimitReader(os.Stdin, maxSize+1))
+		})
+		data, err = stdinData.data, stdinData.err
+	} else {
+		var f *os.File
+		f, err = os.Open(file.name)
+		if err == nil {
+			data, err = io.ReadAll(io.LimitReader(f, maxSize+1))
+			f.Close()
 		}
-		spaceAfter = true
-		i += size
 	}
-	if spaceAfter && i >= len(line) {
-		spaceAfter = false
-	}
-	return b, spaceAfter, i
-}
 
-func skipSpaceByte(line []byte, i int) int {
-	for i < len(line) {
-		if !isSpace(line[i]) {
-			return i
-		}
-		i++
+	switch {
+	case err != nil:
+		file.errorMsg = err.Error()
+	case int64(len(data)) > maxSize:
+		file.errorMsg = MsgFileTooBig
+	default:
+		file.setData(data, maxSize)
 	}
-	return i
 }
 
-func getNextByteNonSpace(line []byte, i int) (byte, int) {
-	return line[i], skipSpaceByte(line, i+1)
-}
+// open file, and read/mmap the entire content into byte array.
+// Files larger than -max-size are not read.
+func openFile(cfg *DiffConfig, fName string, fInfo os.FileInfo) *FileData {
 
-func getNextByteXSpace(line []byte, i int) (byte, bool, int) {
-	b, i := line[i], i+1
-	spaceAfter := false
-	for i < len(line) {
-		if !isSpace(line[i]) {
-			break
-		}
-		spaceAfter = true
-		i++
+	file := &FileData{name: fName, info: fInfo}
+	fSize := file.info.Size()
+	maxSize := cfg.maxSize
+
+	var err error
+
+	if a, name, ok := cfg.archiveFile(fName); ok {
+		file.readArchive(a, name, maxSize)
+		return file
 	}
-	if spaceAfter && i >= len(line) {
-		spaceAfter = false
+
+	if isStream(fName, fInfo) {
+		file.readStream(maxSize)
+		return file
 	}
-	return b, spaceAfter, i
-}
 
-func compareLineBytes(line1, line2 []byte) bool {
-	len1, len2 := len(line1), len(line2)
-	var i, j int
-	var v1, v2 byte
-	switch {
-	case flagCmpIgnoreAllSpace:
-		i = skipSpaceByte(line1, 0)
-		j = skipSpaceByte(line2, 0)
-		for i < len1 && j < len2 {
-			v1, i = getNextByteNonSpace(line1, i)
-			v2, j = getNextByteNonSpace(line2, j)
-			if flagCmpIgnoreCase && v1 != v2 {
-				v1, v2 = toLowerByte(v1), toLowerByte(v2)
-			}
-			if v1 != v2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flagCmpIgnoreSpaceChange:
-		var spaceAfter1, spaceAfter2 bool
-		i = skipSpaceByte(line1, 0)
-		j = skipSpaceByte(line2, 0)
-		for i < len1 && j < len2 {
-			v1, spaceAfter1, i = getNextByteXSpace(line1, i)
-			v2, spaceAfter2, j = getNextByteXSpace(line2, j)
-			if flagCmpIgnoreCase && v1 != v2 {
-				v1, v2 = toLowerByte(v1), toLowerByte(v2)
-			}
-			if v1 != v2 || spaceAfter1 != spaceAfter2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flagCmpIgnoreCase:
-		if len1 != len2 {
-			return false
-		}
-		for i < len1 && j < len2 {
-			if toLowerByte(line1[i]) != toLowerByte(line2[j]) {
-				return false
-			}
-			i, j = i+1, j+1
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-	}
-	return true
-}
-
-func compareLineUnicode(line1, line2 []byte) bool {
-	len1, len2 := len(line1), len(line2)
-	var i, j int
-	var v1, v2 rune
-	var size1, size2 int
-	switch {
-	case flagCmpIgnoreAllSpace:
-		i = skipSpaceRune(line1, 0)
-		j = skipSpaceRune(line2, 0)
-		for i < len1 && j < len2 {
-			v1, i = getNextRuneNonspace(line1, i)
-			v2, j = getNextRuneNonspace(line2, j)
-			if flagCmpIgnoreCase && v1 != v2 {
-				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
-			}
-			if v1 != v2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flagCmpIgnoreSpaceChange:
-		i = skipSpaceRune(line1, 0)
-		j = skipSpaceRune(line2, 0)
-		var spaceAfter1, spaceAfter2 bool
-		for i < len1 && j < len2 {
-			v1, spaceAfter1, i = getNextRuneXspace(line1, i)
-			v2, spaceAfter2, j = getNextRuneXspace(line2, j)
-			if flagCmpIgnoreCase && v1 != v2 {
-				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
-			}
-			if v1 != v2 || spaceAfter1 != spaceAfter2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flagCmpIgnoreCase:
-		if len1 != len2 {
-			return false
-		}
-		for i < len1 && j < len2 {
-			v1, size1 = utf8.DecodeRune(line1[i:])
-			v2, size2 = utf8.DecodeRune(line2[j:])
-			if v1 != v2 && unicode.ToLower(v1) != unicode.ToLower(v2) {
-				return false
-			}
-			i, j = i+size1, j+size2
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-	}
-	return true
-}
-
-var crcTable = crc32.MakeTable(crc32.Castagnoli)
-
-func hash32(h uint32, b byte) uint32 {
-	return crcTable[byte(h)^b] ^ (h >> 8)
-}
-
-func hash32Unicode(h uint32, r rune) uint32 {
-	for r != 0 {
-		h = hash32(h, byte(r))
-		r = r >> 8
-	}
-	return h
-}
-
-func computeHashExact(data []byte) uint32 {
-	// On amd64, this will be using the SSE4.2 hardware instructions, much faster!
-	return crc32.Update(0, crcTable, data)
-}
-
-func computeHashBytes(line1 []byte) uint32 {
-	var hash uint32
-	switch {
-	case flagCmpIgnoreAllSpace:
-		for _, v1 := range line1 {
-			if !isSpace(v1) {
-				if flagCmpIgnoreCase {
-					v1 = toLowerByte(v1)
-				}
-				hash = hash32(hash, v1)
-			}
-		}
-
-	case flagCmpIgnoreSpaceChange:
-		lastHash := hash
-		lastSpace := true
-		for _, v1 := range line1 {
-			if isSpace(v1) {
-				if !lastSpace {
-					lastHash = hash
-					hash = hash32(hash, ' ')
-				}
-				lastSpace = true
-			} else {
-				if flagCmpIgnoreCase {
-					v1 = toLowerByte(v1)
-				}
-				hash = hash32(hash, v1)
-				lastSpace = false
-			}
-		}
-		if lastSpace {
-			hash = lastHash
-		}
-
-	case flagCmpIgnoreCase:
-		for _, v1 := range line1 {
-			v1 = toLowerByte(v1)
-			hash = hash32(hash, v1)
-		}
-
-	}
-	return hash
-}
-
-func computeHashUnicode(line1 []byte) uint32 {
-	var hash uint32
-	i, len1 := 0, len(line1)
-
-	switch {
-	case flagCmpIgnoreAllSpace:
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i = i + size
-			if !unicode.IsSpace(v1) {
-				if flagCmpIgnoreCase {
-					v1 = unicode.ToLower(v1)
-				}
-				hash = hash32Unicode(hash, v1)
-			}
-		}
-
-	case flagCmpIgnoreSpaceChange:
-		lastHash := hash
-		lastSpace := true
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i += size
-			if unicode.IsSpace(v1) {
-				if !lastSpace {
-					lastHash = hash
-					hash = hash32(hash, ' ')
-				}
-				lastSpace = true
-			} else {
-				if flagCmpIgnoreCase {
-					v1 = unicode.ToLower(v1)
-				}
-				hash = hash32Unicode(hash, v1)
-				lastSpace = false
-			}
-		}
-		if lastSpace {
-			hash = lastHash
-		}
-
-	case flagCmpIgnoreCase:
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i = i + size
-			v1 = unicode.ToLower(v1)
-			hash = hash32Unicode(hash, v1)
-		}
-	}
-	return hash
-}
-
-type EquivClass struct {
-	id   int
-	hash uint32
-	line *[]byte
-	next *EquivClass
-}
-
-type LinesData struct {
-	ids       []int // Ids for each line,
-	zidS      []int // list of ids with unmatched lines replaced by a single entry (and blank lines removed)
-	zCount    []int // Number of lines that represent each zidS entry
-	change    []bool
-	zidsStart int
-	zidsEnd   int
-}
-
-// Compute id's that represent the original lines, these numeric id's are use for faster line comparison.
-func findEquivLines(lines1, lines2 [][]byte) (*LinesData, *LinesData) {
-
-	info1 := LinesData{
-		ids:    make([]int, len(lines1)),
-		change: make([]bool, len(lines1)),
-	}
-
-	info2 := LinesData{
-		ids:    make([]int, len(lines2)),
-		change: make([]bool, len(lines2)),
-	}
-
-	// since we already have a hashing function, it's faster to use arrays than to use go's builtin map
-	// Use bucket size that is power of 2
-	buckets := 1 << 9
-	for buckets < (len(lines1)+len(lines2))*2 {
-		buckets = buckets << 1
-	}
-
-	// create the slice we are using for hash tables
-	eqHash := make([]*EquivClass, buckets)
-
-	// Use id=0 for blank lines.
-	// Later in report_changes(), do not report changes on chunks of lines with id=0
-	if flagCmpIgnoreBlankLines {
-		hashcode := computeHash(blankLine)
-		iHash := int(hashcode) & (buckets - 1)
-		eqHash[iHash] = &EquivClass{id: 0, line: &blankLine, hash: hashcode}
-	}
-
-	// the unique id for identical lines, start with 1.
-	var maxIdF1, maxIdF2 int
-	nextId := 1
-
-	// process both sets of lines
-	for findEx := 0; findEx < 2; findEx++ {
-		var lines [][]byte
-		var ids []int
-
-		if findEx == 0 {
-			lines = lines1
-			ids = info1.ids
-		} else {
-			lines = lines2
-			ids = info2.ids
-		}
-
-		for i := 0; i < len(lines); i++ {
-			lPtr := &lines[i]
-			// find current line in eqHash
-			hashcode := computeHash(*lPtr)
-			iHash := int(hashcode) & (buckets - 1)
-			eq := eqHash[iHash]
-			if eq == nil {
-				// not found in eqHash, create new entry
-				ids[i] = nextId
-				eqHash[iHash] = &EquivClass{id: nextId, line: lPtr, hash: hashcode}
-				nextId++
-			} else if eq.hash == hashcode && compareLine(*lPtr, *eq.line) {
-				// found, and line is the same. reuse same id
-				ids[i] = eq.id
-			} else {
-				// hash-collision. look through link-list for same match
-				n := eq.next
-				for n != nil {
-					if n.hash == hashcode && compareLine(*lPtr, *n.line) {
-						ids[i] = n.id
-						break
-					}
-					n = n.next
-				}
-				// new entry, link to start of linked-list
-				if n == nil {
-					ids[i] = nextId
-					eq.next = &EquivClass{id: nextId, line: lPtr, hash: hashcode, next: eq.next}
-					nextId++
-				}
-			}
-		}
-
-		if findEx == 0 {
-			maxIdF1 = nextId - 1
-		} else {
-			maxIdF2 = nextId - 1
-		}
-	}
-
-	compressEquivIds(&info1, &info2, maxIdF1, maxIdF2)
-
-	return &info1, &info2
-}
-
-// Count the occurrences of each unique ids in both sets of lines, we will then know which lines are only present in one file, but not the other.
-// Remove chunks of lines that do not appear in the other files, and replace with a single entry
-// Return compressed lists of ids and a list indicating where are the chunk of lines being replaced
-func compressEquivIds(lines1, lines2 *LinesData, maxId1, maxId2 int) {
-
-	len1, len2 := len(lines1.ids), len(lines2.ids)
-	hasIds1, hasIds2 := make([]bool, maxId1+1), make([]bool, maxId2+1)
-
-	// Determine which id's are in each file
-	for _, v := range lines1.ids {
-		hasIds1[v] = true
-	}
-	for _, v := range lines2.ids {
-		hasIds2[v] = true
-	}
-
-	// exclude lines from the beginning that are identical in both files
-	// if line in file1 but not in file2, exclude it and marked as changed
-	// if line in file2 but not in file1, exclude it and marked as changed
-	i1, i2 := 0, 0
-	for i1 < len1 && i2 < len2 {
-		v1, v2 := lines1.ids[i1], lines2.ids[i2]
-		if v1 > maxId2 || !hasIds2[v1] {
-			lines1.change[i1] = true
-			i1++
-		} else if v2 > maxId1 || !hasIds1[v2] {
-			lines2.change[i2] = true
-			i2++
-		} else if v1 == v2 {
-			i1++
-			i2++
-		} else {
-			break
-		}
-	}
-
-	// exclude lines from the end that are identical in both files
-	// if line in file1 but not in file2, exclude it and marked as changed
-	// if line in file2 but not in file1, exclude it and marked as changed
-	j1, j2 := len1, len2
-	for i1 < j1 && i2 < j2 {
-		v1, v2 := lines1.ids[j1-1], lines2.ids[j2-1]
-		if v1 > maxId2 || !hasIds2[v1] {
-			j1--
-			lines1.change[j1] = true
-		} else if v2 > maxId1 || !hasIds1[v2] {
-			j2--
-			lines2.change[j2] = true
-		} else if v1 == v2 {
-			j1--
-			j2--
-		} else {
-			break
-		}
-	}
-
-	// One of the list is now empty, no need to run diff algorithm for comparison.
-	// Just mark the remaining lines other list as changed.
-	if i1 == j1 {
-		for i2 < j2 {
-			lines2.change[i2] = true
-			i2++
-		}
-		return
-	}
-	if i2 == j2 {
-		for i1 < j1 {
-			lines1.change[i1] = true
-			i1++
-		}
-		return
-	}
-
-	// store excluded lines from beginning and end of file
-	lines1.zidsStart, lines1.zidsEnd = i1, j1
-	lines2.zidsStart, lines2.zidsEnd = i2, j2
-
-	// Go through all lines, replace chunk of lines that does not exists in the
-	// other set with a single entry and a negative new id).
-	nextId := maxInt(maxId1, maxId2) + 1
-	for findEx := 0; findEx < 2; findEx++ {
-		var ids []int
-		var hasIds []bool
-		var maxId int
-
-		if findEx == 0 {
-			ids = lines1.ids[lines1.zidsStart:lines1.zidsEnd]
-			hasIds = hasIds2
-			maxId = maxId2
-		} else {
-			ids = lines2.ids[lines2.zidsStart:lines2.zidsEnd]
-			hasIds = hasIds1
-			maxId = maxId1
-		}
-
-		// new slices for compressed ids and the number of lines each entry replaced
-		// use a new negative id for those merged lines
-		zCount := make([]int, len(ids))
-		zids := make([]int, len(ids))
-
-		lastExclude := false
-		n := 0
-		for _, v := range ids {
-			exclude := v > maxId || !hasIds[v]
-			if exclude && lastExclude {
-				zCount[n-1]++
-				zids[n-1] = -nextId
-				nextId++
-			} else if exclude {
-				zCount[n]++
-				zids[n] = -v
-				n++
-			} else {
-				zCount[n]++
-				zids[n] = v
-				n++
-			}
-			lastExclude = exclude
-		}
-
-		// shrink the slice
-		zids = zids[:n]
-		zCount = zCount[:n]
-
-		if findEx == 0 {
-			lines1.zidS = zids
-			lines1.zCount = zCount
-		} else {
-			lines2.zidS = zids
-			lines2.zCount = zCount
-		}
-	}
-}
-
-// Do the reverse of the compressEquivIds.
-// zLines1 and zLines2 contains the 'extra' lines each entry represents.
-func expandChangeList(info1, info2 *LinesData, zChange1, zChange2 []bool) {
-
-	for findEx := 0; findEx < 2; findEx++ {
-		var info *LinesData
-		var change, zChange []bool
-
-		// expand the changes into the range between zids_start and zids_end
-		if findEx == 0 {
-			info = info1
-			change = info1.change[info1.zidsStart:]
-			zChange = zChange1
-		} else {
-			info = info2
-			change = info2.change[info2.zidsStart:]
-			zChange = zChange2
-		}
-
-		// no change
-		if zChange == nil {
-			continue
-		}
-
-		// expand each entry by the number of lines in zCount[]
-		n := 0
-		for i, m := range info.zCount {
-			if zChange[i] {
-				for end := n + m; n < end; n++ {
-					change[n] = true
-				}
-			} else {
-				n += m
-			}
-		}
-	}
-}
-
-// open file, and read/mmap the entire content into byte array
-func openFile(fName string, fInfo os.FileInfo) *FileData {
-
-	file := &FileData{name: fName, info: fInfo}
-	fSize := file.info.Size()
-
-	var err error
-
-	if fSize >= 1e8 {
+	if fSize > maxSize {
 		file.errorMsg = MsgFileTooBig
 		return file
 	}
@@ -1628,34 +1435,17 @@ func openFile(fName string, fInfo os.FileInfo) *FileData {
 		return file
 	}
 
-	if strings.HasSuffix(fName, ".gz") {
-		// Uncompressed .gz file
-		reader, err := gzip.NewReader(file.osFile)
-		if err != nil {
-			file.errorMsg = err.Error()
-			return file
-		}
-		fData, err := io.ReadAll(reader)
-		if err != nil {
-			file.errorMsg = err.Error()
-			return file
-		}
-		reader.Close()
-		file.data = fData
+	// compressed files are detected by their first bytes
+	magic := make([]byte, MagicSize)
+	n, _ := file.osFile.ReadAt(magic, 0)
+	if d := findDecompressor(magic[:n]); d != nil {
+		file.decompress(d, file.osFile, maxSize)
 		file.osFile.Close()
 		file.osFile = nil
-	} else if strings.HasSuffix(fName, ".bz2") {
-		// Uncompressed .bz2 file
-		reader := bzip2.NewReader(file.osFile)
-		fData, err := io.ReadAll(reader)
-		if err != nil {
-			file.errorMsg = err.Error()
-			return file
-		}
-		file.data = fData
-		file.osFile.Close()
-		file.osFile = nil
-	} else if has_mmap && fSize > MmapThreshold {
+		return file
+	}
+
+	if has_mmap && fSize > MmapThreshold {
 		// map to file into memory, leave file open.
 		file.data, err = map_file(file.osFile, 0, int(fSize))
 		if err != nil {
@@ -1686,13 +1476,16 @@ func openFile(fName string, fInfo os.FileInfo) *FileData {
 // Close file (and unmap it)
 func (file *FileData) closeFile() {
 	if file.osFile != nil {
-		if file.isMapped && file.data != nil {
+		if file.isMapped && file.raw != nil {
+			unmap_file(file.raw)
+		} else if file.isMapped && file.data != nil {
 			unmap_file(file.data)
 		}
 		file.osFile.Close()
 		file.osFile = nil
 	}
 	file.data = nil
+	file.raw = nil
 }
 
 // check if file is binary
@@ -1719,14 +1512,22 @@ func (file *FileData) splitLines() [][]byte {
 	var i, prevI int
 	var b, lastB byte
 
+	file.eols = make([]byte, 0, cap(lines))
+
 	data := file.data
 	for i, b = range data {
 		// accept dos, unix, mac newline
 		if b == '\n' && lastB == '\r' {
 			prevI = i + 1
+			file.eols[len(file.eols)-1] = EolCRLF
 		} else if b == '\n' || b == '\r' {
 			lines = append(lines, data[prevI:i])
 			prevI = i + 1
+			if b == '\n' {
+				file.eols = append(file.eols, EolLF)
+			} else {
+				file.eols = append(file.eols, EolCR)
+			}
 		} else if b == 0 && i < BinaryCheckSize {
 			file.isBinary = true
 			file.errorMsg = MsgFileIsBinary
@@ -1738,11 +1539,29 @@ func (file *FileData) splitLines() [][]byte {
 	// add last incomplete line (if required)
 	if len(data) > prevI {
 		lines = append(lines, data[prevI:])
+		file.eols = append(file.eols, EolNone)
+		file.noNewline = true
 	}
 
 	return lines
 }
 
+// Read all entries of a directory, or of a directory in an archive
+func readDir(cfg *DiffConfig, dirname string) ([]os.FileInfo, error) {
+
+	if a, name, ok := cfg.archiveFile(dirname); ok {
+		return a.ReadDir(name)
+	}
+
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.Readdir(-1)
+}
+
 // FileInfoList for sorting os.FileInfo by name
 type FileInfoList []os.FileInfo
 
@@ -1751,26 +1570,18 @@ func (s FileInfoList) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s FileInfoList) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
 
 // get a list of sorted directory entries
-func readSortedDir(dirname string) ([]os.FileInfo, error) {
-
-	dir, err := os.Open(dirname)
-	if err != nil {
-		return nil, err
-	}
+func readSortedDir(cfg *DiffConfig, dirname string) ([]os.FileInfo, error) {
 
-	all, err := dir.Readdir(-1)
+	all, err := readDir(cfg, dirname)
 	if err != nil {
-		dir.Close()
 		return nil, err
 	}
 
-	dir.Close()
-
 	// Exclude files
-	if regexpExcludeFiles != nil && len(all) > 0 {
+	if cfg.excludeFiles != nil && len(all) > 0 {
 		eAll := make([]os.FileInfo, 0, len(all))
 		for _, f := range all {
-			if !regexpExcludeFiles.MatchString(f.Name()) {
+			if !cfg.excludeFiles.MatchString(f.Name()) {
 				eAll = append(eAll, f)
 			}
 		}
@@ -1783,13 +1594,13 @@ func readSortedDir(dirname string) ([]os.FileInfo, error) {
 }
 
 // compare 2 dirs.
-func diffDirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
+func diffDirs(cfg *DiffConfig, dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 
 	dirname1 = strings.TrimRight(dirname1, PathSeparator)
 	dirname2 = strings.TrimRight(dirname2, PathSeparator)
 
-	dir1, err1 := readSortedDir(dirname1)
-	dir2, err2 := readSortedDir(dirname2)
+	dir1, err1 := readSortedDir(cfg, dirname1)
+	dir2, err2 := readSortedDir(cfg, dirname2)
 
 	if err1 != nil || err2 != nil {
 		msg1, msg2 := "", ""
@@ -1799,7 +1610,8 @@ func diffDirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 		if err2 != nil {
 			msg2 = err2.Error()
 		}
-		outputDiffMessage(dirname1, dirname2, finfo1, finfo2, msg1, msg2, true)
+		cfg.setExitStatus(ExitTrouble)
+		outputDiffMessage(cfg, dirname1, dirname2, finfo1, finfo2, msg1, msg2, true)
 		return
 	}
 
@@ -1827,49 +1639,47 @@ func diffDirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 				if dir1[i1].IsDir() != dir2[i2].IsDir() {
 					if !dirMode {
 						if dir1[i1].IsDir() {
-							outputDiffMessage(dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2], MsgThisIsDir, MsgThisIsFile, true)
+							outputDiffMessage(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2], MsgThisIsDir, MsgThisIsFile, true)
 						} else {
-							outputDiffMessage(dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2], MsgThisIsFile, MsgThisIsDir, true)
+							outputDiffMessage(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2], MsgThisIsFile, MsgThisIsDir, true)
 						}
 					}
 				} else if dirMode {
 					// compare sub-directories
-					diffDirs(dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
+					diffDirs(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
 				} else {
 					// compare files
-					if flagMaxGoroutines > 1 {
-						queueDiffFile(dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
+					if cfg.maxGoroutines > 1 {
+						queueDiffFile(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
 					} else {
-						diffFile(dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
+						diffFile(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name2, dir1[i1], dir2[i2])
 					}
 				}
 				i1, i2 = i1+1, i2+1
 			} else if (i1 < len(dir1) && name1 < name2) || i2 >= len(dir2) {
-				if dirMode {
-					outputDiffMessage(dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1], nil, "", MsgDirNotExists, true)
-				} else {
-					if flagSuppressMissingFile {
-						outputDiffMessage(dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1], nil, "", MsgFileNotExists, true)
-					} else {
-						fData := openFile(dirname1+PathSeparator+name1, dir1[i1])
-						fData.checkBinary()
-						outputDiffMessageContent(dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1], nil, fData.errorMsg, MsgFileNotExists, fData.splitLines(), nil, true)
-						fData.closeFile()
-					}
+				switch {
+				case cfg.renames != nil && dirMode:
+					// look for renamed files in the missing directory
+					cfg.renames.addDir(cfg, 0, dirname1+PathSeparator+name1, dirname2+PathSeparator+name1)
+				case cfg.renames != nil:
+					cfg.renames.add(0, dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1])
+				case dirMode:
+					outputDiffMessage(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1], nil, "", MsgDirNotExists, true)
+				default:
+					diffMissingFile(cfg, dirname1+PathSeparator+name1, dirname2+PathSeparator+name1, dir1[i1], nil)
 				}
 				i1++
 			} else if (i2 < len(dir2) && name2 < name1) || i1 >= len(dir1) {
-				if dirMode {
-					outputDiffMessage(dirname1+PathSeparator+name2, dirname2+PathSeparator+name2, nil, dir2[i2], MsgDirNotExists, "", true)
-				} else {
-					if flagSuppressMissingFile {
-						outputDiffMessage(dirname1+PathSeparator+name2, dirname2+PathSeparator+name2, nil, dir2[i2], MsgFileNotExists, "", true)
-					} else {
-						fData := openFile(dirname2+PathSeparator+name2, dir2[i2])
-						fData.checkBinary()
-						outputDiffMessageContent(dirname1+PathSeparator+name2, dirname2+PathSeparator+name2, nil, dir2[i2], MsgFileNotExists, fData.errorMsg, nil, fData.splitLines(), true)
-						fData.closeFile()
-					}
+				switch {
+				case cfg.renames != nil && dirMode:
+					// look for renamed files in the missing directory
+					cfg.renames.addDir(cfg, 1, dirname2+PathSeparator+name2, dirname1+PathSeparator+name2)
+				case cfg.renames != nil:
+					cfg.renames.add(1, dirname2+PathSeparator+name2, dirname1+PathSeparator+name2, dir2[i2])
+				case dirMode:
+					outputDiffMessage(cfg, dirname1+PathSeparator+name2, dirname2+PathSeparator+name2, nil, dir2[i2], MsgDirNotExists, "", true)
+				default:
+					diffMissingFile(cfg, dirname1+PathSeparator+name2, dirname2+PathSeparator+name2, nil, dir2[i2])
 				}
 				i2++
 			} else {
@@ -1879,31 +1689,83 @@ func diffDirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 	}
 }
 
+// report a file that only exists on one side, fInfo1 or fInfo2 is nil for the missing file.
+func diffMissingFile(cfg *DiffConfig, filename1, filename2 string, fInfo1, fInfo2 os.FileInfo) {
+
+	if fInfo2 == nil {
+		if cfg.suppressMissingFile || cfg.brief {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, nil, "", MsgFileNotExists, true)
+		} else {
+			fData, lines, n := openMissingFile(cfg, 0, filename1, fInfo1)
+			outputDiffMessageContent(cfg, filename1, filename2, fInfo1, nil, fData.errorMsg, MsgFileNotExists, lines, nil, n, true)
+			fData.closeFile()
+		}
+	} else {
+		if cfg.suppressMissingFile || cfg.brief {
+			outputDiffMessage(cfg, filename1, filename2, nil, fInfo2, MsgFileNotExists, "", true)
+		} else {
+			fData, lines, n := openMissingFile(cfg, 1, filename2, fInfo2)
+			outputDiffMessageContent(cfg, filename1, filename2, nil, fInfo2, MsgFileNotExists, fData.errorMsg, nil, lines, n, true)
+			fData.closeFile()
+		}
+	}
+}
+
 // compare 2 file
-func diffFile(filename1, filename2 string, fInfo1, fInfo2 os.FileInfo) {
+func diffFile(cfg *DiffConfig, filename1, filename2 string, fInfo1, fInfo2 os.FileInfo) {
+
+	// large files are read one line at a time
+	if (cfg.isLargeFile(filename1, fInfo1) || cfg.isLargeFile(filename2, fInfo2)) && cfg.canReadLines(filename1, fInfo1) && cfg.canReadLines(filename2, fInfo2) {
+		diffLargeFile(cfg, filename1, filename2, fInfo1, fInfo2)
+		return
+	}
 
-	file1 := openFile(filename1, fInfo1)
-	file2 := openFile(filename2, fInfo2)
+	file1 := openFile(cfg, filename1, fInfo1)
+	file2 := openFile(cfg, filename2, fInfo2)
 
 	defer file1.closeFile()
 	defer file2.closeFile()
 
 	if file1.errorMsg != "" || file2.errorMsg != "" {
 		// display error messages
-		outputDiffMessage(filename1, filename2, fInfo1, fInfo2, file1.errorMsg, file2.errorMsg, true)
+		cfg.setExitStatus(ExitTrouble)
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, file1.errorMsg, file2.errorMsg, true)
 		return
 	} else if bytes.Equal(file1.data, file2.data) {
 		// files are equal
-		if flagShowIdenticalFiles {
-			outputDiffMessage(filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
+		if cfg.showIdenticalFiles {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
 		}
 		return
 	}
 
+	file1.decode(cfg, 0)
+	file2.decode(cfg, 1)
+
+	// brief mode, files in the same encoding that are not byte for byte equal are different,
+	// unless parts of the lines are ignored
+	if cfg.brief && !cfg.ignoreChanges() && file1.encoding == file2.encoding {
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileDiffers, MsgFileDiffers, false)
+		return
+	}
+
 	lines1 := file1.splitLines()
 	lines2 := file2.splitLines()
 
-	if file1.isBinary || file2.isBinary {
+	if cfg.brief {
+		if sameFileLines(cfg, file1, file2, lines1, lines2) {
+			if cfg.showIdenticalFiles {
+				outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
+			}
+		} else {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileDiffers, MsgFileDiffers, false)
+		}
+		return
+	}
+
+	if (file1.isBinary || file2.isBinary) && cfg.showBinaryChanges() {
+		diffBinaryFile(cfg, file1, file2)
+	} else if file1.isBinary || file2.isBinary {
 
 		var msg1, msg2 string
 
@@ -1920,364 +1782,244 @@ func diffFile(filename1, filename2 string, fInfo1, fInfo2 os.FileInfo) {
 		}
 
 		if msg1 != "" || msg2 != "" {
-			outputDiffMessage(filename1, filename2, fInfo1, fInfo2, msg1, msg2, true)
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, msg1, msg2, true)
 		}
 	} else {
-		// Compute equiv ids for each line.
-		info1, info2 := findEquivLines(lines1, lines2)
-
-		// No zidS available, no need to run diff comparison algorithm
-		// The find_equiv_lines() function may have performed the comparison already.
-		if info1.zidS != nil && info2.zidS != nil {
-			// run the diff algorithm
-			zChange1, zChange2 := doDiff(info1.zidS, info2.zidS)
-
-			// expand the change list, so that change array contains changes to actual lines
-			expandChangeList(info1, info2, zChange1, zChange2)
-		}
-
-		// perform shift boundary
-		shiftBoundaries(info1.ids, info1.change, nil)
-		shiftBoundaries(info2.ids, info2.change, nil)
-
-		chgData := DiffChangerData{
-			OutputFormat: &OutputFormat{
-				name1:       filename1,
-				name2:       filename2,
-				fileInfo1:   fInfo1,
-				fileInfo2:   fInfo2,
-				linenoWidth: len(fmt.Sprintf("%d", maxInt(len(lines1), len(lines2)))),
-			},
-			file1: lines1,
-			file2: lines2,
-		}
-
-		var chg DiffChanger
-
-		// Choose change output format: text or html
-		if flagOutputAsText {
-			if flagUnifiedContext {
-				chg = &DiffChangerUnifiedText{DiffChangerData: chgData}
-			} else {
-				chg = &DiffChangerText{DiffChangerData: chgData}
-			}
-		} else {
-			if flagUnifiedContext {
-				chg = &DiffChangerUnifiedHtml{DiffChangerData: chgData}
-			} else {
-				chg = &DiffChangerHtml{DiffChangerData: chgData}
-			}
-		}
-
-		// output diff results
-		changed := reportDiff(chg, info1.ids, info2.ids, info1.change, info2.change)
-
-		if chgData.headerPrinted {
-			if !flagOutputAsText {
-				out.WriteString("</table><br>\n")
-			}
-			chgData.headerPrinted = false
-			outReleaseLock()
-		}
-
-		if !changed && flagShowIdenticalFiles {
-			// report on identical file if required
-			outputDiffMessage(filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
-		}
+		// Compute equiv ids for each line, and find the changes.
+		lines1, lines2, info1, info2 := compareFileLines(cfg, file1, file2, lines1, lines2)
+		outputFileChanges(cfg, file1, file2, lines1, lines2, info1, info2, nil)
 	}
 }
 
-// shortcut functions. hopefully will be inlined by compiler
-func maxInt(a, b int) int {
-	if a < b {
-		return b
+// Compare the lines of two files and find the changes.
+func compareLines(cfg *DiffConfig, file1, file2 *FileData, lines1, lines2 [][]byte) (*diff.LinesData, *diff.LinesData) {
+	if !file1.noNewline && !file2.noNewline {
+		return diff.Compare(lines1, lines2, &cfg.cmpOptions)
 	}
-	return a
+	info1, info2 := diff.FindEquivLines(lines1, lines2, &cfg.cmpOptions)
+	splitLastLines(file1, file2, info1.Ids, info2.Ids)
+	cmp1, cmp2 := diff.CompareIds(info1.Ids, info2.Ids, &cfg.cmpOptions)
+	cmp1.Ignore, cmp2.Ignore = info1.Ignore, info2.Ignore
+	diff.IgnoreChanges(cmp1, cmp2)
+	return cmp1, cmp2
 }
 
-// shortcut functions. hopefully will be inlined by compiler
-func minInt(a, b int) int {
-	if a < b {
-		return a
+// A last line that does not end with a newline can only be matched with the last line
+// of the other file, if it does not end with a newline either. Give these lines new ids.
+func splitLastLines(file1, file2 *FileData, ids1, ids2 []int) {
+	if !file1.noNewline && !file2.noNewline {
+		return
 	}
-	return b
-}
-
-// An O(ND) Difference Algorithm: Find middle snake
-func algorithmSms(data1, data2 []int, v []int) (int, int, int, int) {
-
-	end1, end2 := len(data1), len(data2)
-	mMax := end1 + end2 + 1
-	upK := end1 - end2
-	odd := (upK & 1) != 0
-	downOff, upOff := mMax, mMax-upK+mMax+mMax+2
 
-	v[downOff+1] = 0
-	v[downOff] = 0
-	v[upOff+upK-1] = end1
-	v[upOff+upK] = end1
-
-	var k, x, u, z int
-
-	for d := 1; true; d++ {
-		upKPlusD := upK + d
-		upKMinusD := upK - d
-		for k = -d; k <= d; k += 2 {
-			x = v[downOff+k+1]
-			if k > -d && (k == d || z >= x) {
-				x, z = z+1, x
-			} else {
-				z = x
-			}
-			for u = x; x < end1 && x-k < end2 && data1[x] == data2[x-k]; x++ {
-			}
-			if odd && (upKMinusD < k) && (k < upKPlusD) && v[upOff+k] <= x {
-				return u, u - k, x, x - k
-			}
-			v[downOff+k] = x
-		}
-		z = v[upOff+upKMinusD-1]
-		for k = upKMinusD; k <= upKPlusD; k += 2 {
-			x = z
-			if k < upKPlusD {
-				z = v[upOff+k+1]
-				if k == upKMinusD || z <= x {
-					x = z - 1
-				}
-			}
-			for u = x; x > 0 && x > k && data1[x-1] == data2[x-k-1]; x-- {
-			}
-			if !odd && (-d <= k) && (k <= d) && x <= v[downOff+k] {
-				return x, x - k, u, u - k
-			}
-			v[upOff+k] = x
-		}
+	maxId := 0
+	for _, id := range ids1 {
+		maxId = maxInt(maxId, id)
+	}
+	for _, id := range ids2 {
+		maxId = maxInt(maxId, id)
 	}
-	return 0, 0, 0, 0 // should not reach here
-}
 
-// Special case for algorithmSms() with only 1 item.
-func findOneSms(value int, list []int) (int, int) {
-	for i, v := range list {
-		if v == value {
-			return 0, i
+	same := file1.noNewline && file2.noNewline && ids1[len(ids1)-1] == ids2[len(ids2)-1]
+	if file1.noNewline {
+		ids1[len(ids1)-1] = maxId + 1
+	}
+	if file2.noNewline {
+		ids2[len(ids2)-1] = maxId + 2
+		if same {
+			ids2[len(ids2)-1] = maxId + 1
 		}
 	}
-	return 1, 0
 }
 
-// An O(ND) Difference Algorithm: Find LCS
-func algorithmLcs(data1, data2 []int, change1, change2 []bool, v []int) {
+// Output the changes between two files, once the changed lines are known.
+// If wrap is not nil, it is called with the DiffChanger that output the changes,
+// the returned DiffChanger is used instead.
+func outputFileChanges(cfg *DiffConfig, file1, file2 *FileData, lines1, lines2 [][]byte, info1, info2 *diff.LinesData, wrap func(diff.DiffChanger) diff.DiffChanger) {
 
-	start1, start2 := 0, 0
-	end1, end2 := len(data1), len(data2)
+	filename1, filename2 := file1.name, file2.name
+	fInfo1, fInfo2 := file1.info, file2.info
 
-	// matches found at start and end of list
-	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
-		start1++
-		start2++
-	}
-	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
-		end1--
-		end2--
-	}
+	// the line endings or byte order mark are reported even if no lines have changed
+	note := lineEndingsNote(cfg, file1, file2)
 
-	len1, len2 := end1-start1, end2-start2
-
-	switch {
-	case len1 == 0:
-		for start2 < end2 {
-			change2[start2] = true
-			start2++
+	// only count the changes for the diffstat
+	if cfg.stats != nil {
+		added, removed := countChanges(info2), countChanges(info1)
+		if added > 0 || removed > 0 || note != "" {
+			cfg.setExitStatus(ExitDifferent)
+			cfg.addStat(&StatEntry{name: cfg.stats.statName(filename1, filename2), added: added, removed: removed})
 		}
+		return
+	}
 
-	case len2 == 0:
-		for start1 < end1 {
-			change1[start1] = true
-			start1++
-		}
+	chgData := DiffChangerData{
+		OutputFormat: &OutputFormat{
+			cfg:         cfg,
+			name1:       filename1,
+			name2:       filename2,
+			fileInfo1:   fInfo1,
+			fileInfo2:   fInfo2,
+			encoding1:   file1.encoding,
+			encoding2:   file2.encoding,
+			note:        note,
+			linenoWidth: len(fmt.Sprintf("%d", maxInt(len(info1.Ids), len(info2.Ids)))),
+		},
+		file1:      lines1,
+		file2:      lines2,
+		noNewline1: file1.noNewline,
+		noNewline2: file2.noNewline,
+	}
 
-	case len1 == 1 && len2 == 1:
-		change1[start1] = true
-		change2[start2] = true
+	var chg diff.DiffChanger
 
+	// Choose change output format: text, json or html
+	switch {
+	case cfg.outputFormat == FormatText && cfg.sideBySide:
+		chg = &DiffChangerSideBySide{DiffChangerData: chgData}
+	case cfg.outputFormat == FormatText && cfg.unifiedContext:
+		chg = &DiffChangerUnifiedText{DiffChangerData: chgData}
+	case cfg.outputFormat == FormatText:
+		chg = &DiffChangerText{DiffChangerData: chgData}
+	case cfg.outputFormat == FormatJson:
+		chg = &DiffChangerJson{DiffChangerData: chgData}
+	case cfg.unifiedContext:
+		chg = &DiffChangerUnifiedHtml{DiffChangerData: chgData}
 	default:
-		data1, change1 = data1[start1:end1], change1[start1:end1]
-		data2, change2 = data2[start2:end2], change2[start2:end2]
-
-		var x0, y0, x1, y1 int
-
-		if len(data1) == 1 {
-			// match one item, use simple search function
-			x0, y0 = findOneSms(data1[0], data2)
-			x1, y1 = x0, y0
-		} else if len(data2) == 1 {
-			// match one item, use simple search function
-			y0, x0 = findOneSms(data2[0], data1)
-			x1, y1 = x0, y0
-		} else {
-			// Find a point with the longest common sequence
-			x0, y0, x1, y1 = algorithmSms(data1, data2, v)
-		}
-
-		// Use the partitions to split this problem into subproblems.
-		algorithmLcs(data1[:x0], data2[:y0], change1[:x0], change2[:y0], v)
-		algorithmLcs(data1[x1:], data2[y1:], change1[x1:], change2[y1:], v)
+		chg = &DiffChangerHtml{DiffChangerData: chgData}
 	}
-}
 
-// Perform the shift
-func doShiftBoundary(start, end, offset int, change []bool) {
-	if offset < 0 {
-		for offset != 0 {
-			start, end, offset = start-1, end-1, offset+1
-			change[start], change[end] = true, false
-		}
-	} else {
-		for offset != 0 {
-			change[start], change[end] = false, true
-			start, end, offset = start+1, end+1, offset-1
-		}
+	// output diff results
+	var report diff.DiffChanger = chg
+	if wrap != nil {
+		report = wrap(chg)
 	}
-}
-
-// Determine if the changes starting at 'pos' can be shifted 'up' or 'down'
-func findShiftBoundary(start int, data []int, change []bool) (int, int, int, bool, bool) {
-	end, dLen := start+1, len(data)
-	up, down := 0, 0
-
-	// Find the end of this chunk of changes
-	for end < dLen && change[end] {
-		end++
+	changed := diff.ReportDiff(report, info1.Ids, info2.Ids, info1.Change, info2.Change, &cfg.cmpOptions)
+	if changed {
+		cfg.setExitStatus(ExitDifferent)
 	}
 
-	for start-up-1 >= 0 && !change[start-up-1] && data[start-up-1] == data[end-up-1] {
-		up = up + 1
+	if chgData.page != nil {
+		chgData.page.added = countChanges(info2)
+		chgData.page.removed = countChanges(info1)
 	}
 
-	for end+down < dLen && !change[end+down] && data[end+down] == data[start+down] {
-		down = down + 1
+	if chgData.headerPrinted {
+		if cfg.outputFormat == FormatHtml {
+			htmlFileTableEnd(chgData.OutputFormat)
+		} else {
+			chgData.headerPrinted = false
+			cfg.outReleaseLock()
+		}
 	}
 
-	// has changes been shifted to start/end of list or merged with previous/next change
-	upMerge := (start-up == 0) || change[start-up-1]
-	downMerge := (end+down == dLen) || change[end+down]
+	// json output is written as a single record once all changes are known
+	if jsonChg, ok := chg.(*DiffChangerJson); ok && changed {
+		outputJsonRecord(cfg, &JsonRecord{
+			Status: JsonStatusModified,
+			File1:  newJsonFile(filename1, fInfo1, chgData.note),
+			File2:  newJsonFile(filename2, fInfo2, chgData.note),
+			Hunks:  jsonChg.hunks,
+		})
+	}
 
-	return end, up, down, upMerge, downMerge
+	if !changed && chgData.note != "" {
+		// only the line endings or byte order mark are different
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, chgData.note, chgData.note, false)
+	} else if !changed && cfg.showIdenticalFiles {
+		// report on identical file if required
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
+	}
 }
 
-// scoring function for shifting characters in a line.
-func runeEdgeScore(r rune) int {
-
-	switch r {
-	case ' ', '\t', '\v', '\f':
-		return 100
-
-	case '<', '>', '(', ')', '[', ']', '\'', '"':
-		return 40
+// The changes in binary files are shown with -binary, except in formats that cannot show them
+func (cfg *DiffConfig) showBinaryChanges() bool {
+	switch {
+	case !cfg.binary || cfg.stats != nil:
+		return false
+	case cfg.outputFormat == FormatText:
+		return !cfg.unifiedContext
 	}
-
-	return 0
+	return cfg.outputFormat == FormatHtml
 }
 
-// scoring character boundary, for finding a change chunk that is easier to read
-func runeBoundaryScore(r1, r2 int) int {
-
-	s1 := runeEdgeScore(rune(r1))
-	s2 := runeEdgeScore(rune(r2))
-
-	return s1 + s2
+// Some changes between files are ignored, files that are not byte for byte equal can be the same
+func (cfg *DiffConfig) ignoreChanges() bool {
+	opts := &cfg.cmpOptions
+	return opts.IgnoreCase || opts.IgnoreSpaceChange || opts.IgnoreAllSpace || opts.IgnoreBlankLines ||
+		len(opts.IgnoreMatching) > 0 || len(opts.Substitutions) > 0
 }
 
-// shift changes up or down to make it more readable.
-func shiftBoundaries(data []int, change []bool, boundaryScore func(int, int) int) {
-
-	start, clen := 0, len(change)
+// regexpList a command line option that can be repeated, each value is a regexp pattern
+type regexpList []*regexp.Regexp
 
-	for start < clen {
-		// find the next chunk of changes
-		for start < clen && !change[start] {
-			start++
-		}
-		if start >= clen {
-			break
-		}
+func (l *regexpList) String() string {
+	patterns := make([]string, len(*l))
+	for i, re := range *l {
+		patterns[i] = re.String()
+	}
+	return strings.Join(patterns, " ")
+}
 
-		// find the limit of where this set of changes can be shifted
-		end, up, down, upMerge, downMerge := findShiftBoundary(start, data, change)
+func (l *regexpList) Set(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
 
-		// The chunk is already at the start, do not shift downwards
-		if start == 0 {
-			up, down = 0, 0
+// Number of changed lines, not counting ignored blank lines
+func countChanges(info *diff.LinesData) int {
+	n := 0
+	for i, c := range info.Change {
+		if c && info.Ids[i] != 0 {
+			n++
 		}
+	}
+	return n
+}
 
-		switch {
-		case up > 0 && upMerge:
-			// shift up, merged with previous chunk of changes
-			doShiftBoundary(start, end, -up, change)
-			// restart at the beginning of this merged chunk
-			nStart := start
-			for nStart -= up; nStart-1 >= 0 && change[nStart-1]; nStart-- {
-			}
-			if nStart > 0 {
-				start = nStart
-			}
-
-		case down > 0 && downMerge:
-			// shift down, merged with next chunk of changes
-			doShiftBoundary(start, end, down, change)
-			start += down
-
-		case (up > 0 || down > 0) && boundaryScore != nil:
-			// Only perform shifts when there is a boundary score function
-			offset, bestScore := 0, boundaryScore(data[start], data[end-1])
-			for i := -up; i <= down; i++ {
-				if i != 0 {
-					score := boundaryScore(data[start+i], data[end+i-1])
-					if score > bestScore {
-						offset, bestScore = i, score
-					}
-				}
-			}
-			if offset != 0 {
-				doShiftBoundary(start, end, offset, change)
-			}
-			start = end
-			if offset > 0 {
-				start += offset
-			}
+// shortcut functions. hopefully will be inlined by compiler
+func maxInt(a, b int) int {
+	if a < b {
+		return b
+	}
+	return a
+}
 
-		default:
-			// no shift
-			start = end
-		}
+// shortcut functions. hopefully will be inlined by compiler
+func minInt(a, b int) int {
+	if a < b {
+		return a
 	}
+	return b
 }
 
 // Wait for all jobs to finish
-func jobQueueFinish() {
-	if flagMaxGoroutines > 1 {
+func jobQueueFinish(cfg *DiffConfig) {
+	if cfg.maxGoroutines > 1 {
 		jobWait.Wait()
 	}
 }
 
 // Initialise job queues
-func jobQueueInit() {
+func jobQueueInit(cfg *DiffConfig) {
 
-	if flagMaxGoroutines > 1 {
+	if cfg.maxGoroutines > 1 {
 
-		if flagMaxGoroutines > runtime.GOMAXPROCS(-1) {
-			runtime.GOMAXPROCS(flagMaxGoroutines)
+		if cfg.maxGoroutines > runtime.GOMAXPROCS(-1) {
+			runtime.GOMAXPROCS(cfg.maxGoroutines)
 		}
 
 		// create async job queue channel
 		jobQueue = make(chan JobQueue, 1)
 
 		// start up goroutines, to handle file comparison
-		for i := 0; i < flagMaxGoroutines; i++ {
+		for i := 0; i < cfg.maxGoroutines; i++ {
 			go func() {
 				for job := range jobQueue {
-					diffFile(job.name1, job.name2, job.info1, job.info2)
+					diffFile(job.cfg, job.name1, job.name2, job.info1, job.info2)
 					jobWait.Done()
 				}
 			}()
@@ -2286,9 +2028,10 @@ func jobQueueInit() {
 }
 
 // Queue file comparison task
-func queueDiffFile(fName1, fName2 string, finfo1, finfo2 os.FileInfo) {
+func queueDiffFile(cfg *DiffConfig, fName1, fName2 string, finfo1, finfo2 os.FileInfo) {
 	jobWait.Add(1)
 	jobQueue <- JobQueue{
+		cfg:   cfg,
 		name1: fName1,
 		name2: fName2,
 		info1: finfo1,
@@ -2297,15 +2040,22 @@ func queueDiffFile(fName1, fName2 string, finfo1, finfo2 os.FileInfo) {
 }
 
 // Acquire Mutex lock on output stream
-func outAcquireLock() {
-	if flagMaxGoroutines > 1 {
+func (cfg *DiffConfig) outAcquireLock() {
+	if cfg.maxGoroutines > 1 {
 		outLock.Lock()
 	}
 }
 
 // Release Mutex lock on output stream
-func outReleaseLock() {
-	if flagMaxGoroutines > 1 {
+func (cfg *DiffConfig) outReleaseLock() {
+	if cfg.maxGoroutines > 1 {
 		outLock.Unlock()
 	}
 }
+
+// Record the result of a comparison, the exit code is the worst result of all files
+func (cfg *DiffConfig) setExitStatus(status int) {
+	cfg.exitStatusLock.Lock()
+	cfg.exitStatus = maxInt(cfg.exitStatus, status)
+	cfg.exitStatusLock.Unlock()
+}
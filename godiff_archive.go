@@ -0,0 +1,329 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFS content of a zip or tar file, compared as a directory.
+// Names are relative to the root of the archive, separated by '/'.
+type ArchiveFS interface {
+	ReadDir(name string) ([]os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	Close() error
+}
+
+// Compare the archive as a directory, with this name, files in it are found by archiveFile()
+func (cfg *DiffConfig) addArchive(name string, a ArchiveFS) {
+	cfg.archivesLock.Lock()
+	if cfg.archives == nil {
+		cfg.archives = make(map[string]ArchiveFS)
+	}
+	cfg.archives[name] = a
+	cfg.archivesLock.Unlock()
+}
+
+// Find the archive containing this file, and the name of the file in the archive
+func (cfg *DiffConfig) archiveFile(fName string) (ArchiveFS, string, bool) {
+	cfg.archivesLock.Lock()
+	defer cfg.archivesLock.Unlock()
+
+	for root, a := range cfg.archives {
+		if fName == root {
+			return a, ".", true
+		}
+		if strings.HasPrefix(fName, root+PathSeparator) {
+			return a, filepath.ToSlash(fName[len(root)+len(PathSeparator):]), true
+		}
+	}
+	return nil, "", false
+}
+
+// Close the archives once all files are compared
+func (cfg *DiffConfig) closeArchives() {
+	cfg.archivesLock.Lock()
+	for _, a := range cfg.archives {
+		a.Close()
+	}
+	cfg.archives = nil
+	cfg.archivesLock.Unlock()
+}
+
+// Open a zip or tar file (compressed or not) as a directory. Returns nil if it is not an archive.
+// The files are only read when they are compared, a compressed tar file is first decompressed to a temporary file.
+func openArchive(fName string, fInfo os.FileInfo, maxSize int64) (ArchiveFS, error) {
+
+	if !fInfo.Mode().IsRegular() || fName == StdinName {
+		return nil, nil
+	}
+
+	magic := readMagic(fName)
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")) {
+		r, err := zip.OpenReader(fName)
+		if err != nil {
+			return nil, err
+		}
+		return &zipFS{r: r}, nil
+	}
+
+	d := findDecompressor(magic)
+	if d == nil && !isTar(magic) {
+		return nil, nil
+	}
+
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return readTar(f, false, fInfo.ModTime(), maxSize)
+	}
+	defer f.Close()
+
+	r, err := d.reader(f)
+	if err != nil {
+		return nil, nil
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// only compressed tar files are archives
+	header := make([]byte, MagicSize)
+	n, _ := io.ReadFull(r, header)
+	if !isTar(header[:n]) {
+		return nil, nil
+	}
+
+	tmp, err := os.CreateTemp("", "godiff-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tmp, io.MultiReader(bytes.NewReader(header[:n]), r)); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return readTar(tmp, true, fInfo.ModTime(), maxSize)
+}
+
+// Read a file in an archive
+func (file *FileData) readArchive(a ArchiveFS, name string, maxSize int64) {
+	if file.info.Size() > maxSize {
+		file.errorMsg = MsgFileTooBig
+		return
+	}
+	data, err := a.ReadFile(name)
+	if err != nil {
+		file.errorMsg = err.Error()
+		return
+	}
+	file.setData(data, maxSize)
+}
+
+// tar files have "ustar" at offset 257 of the first header
+func isTar(magic []byte) bool {
+	return len(magic) >= 262 && string(magic[257:262]) == "ustar"
+}
+
+// zipFS a zip file
+type zipFS struct {
+	r *zip.ReadCloser
+}
+
+func (z *zipFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(z.r, name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (z *zipFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(z.r, name)
+}
+
+func (z *zipFS) Close() error {
+	return z.r.Close()
+}
+
+// tarFS a tar file, the files are read from it when they are compared
+type tarFS struct {
+	f     *os.File // the tar file, or a decompressed copy of it
+	temp  bool     // f is removed when the archive is closed
+	dirs  map[string][]os.FileInfo
+	files map[string]*tarEntry
+}
+
+// tarEntry the position of a file in the tar file.
+// Sparse files cannot be read from there, they are read into memory.
+type tarEntry struct {
+	offset, size int64
+	data         []byte
+}
+
+// dirInfo a directory in a tar file without its own entry
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (d *dirInfo) Name() string       { return d.name }
+func (d *dirInfo) Size() int64        { return 0 }
+func (d *dirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (d *dirInfo) ModTime() time.Time { return d.modTime }
+func (d *dirInfo) IsDir() bool        { return true }
+func (d *dirInfo) Sys() interface{}   { return nil }
+
+// Read the entries of a tar file, and note the position of each file in it.
+// f is closed, and removed if temp is set, if it cannot be read, or when the archive is closed.
+func readTar(f *os.File, temp bool, modTime time.Time, maxSize int64) (*tarFS, error) {
+
+	t := &tarFS{f: f, temp: temp, dirs: map[string][]os.FileInfo{".": nil}, files: make(map[string]*tarEntry)}
+
+	// add a file or directory, and its parent directories
+	var add func(name string, info os.FileInfo)
+	add = func(name string, info os.FileInfo) {
+		dir := path.Dir(name)
+		if _, ok := t.dirs[dir]; !ok {
+			add(dir, &dirInfo{name: path.Base(dir), modTime: modTime})
+		}
+		for i, f := range t.dirs[dir] {
+			if f.Name() == info.Name() {
+				t.dirs[dir][i] = info
+				return
+			}
+		}
+		t.dirs[dir] = append(t.dirs[dir], info)
+		if info.IsDir() && t.dirs[name] == nil {
+			t.dirs[name] = []os.FileInfo{}
+		}
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(h.Name, "/"))
+		if name == "." || strings.HasPrefix(name, "../") {
+			continue
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			add(name, h.FileInfo())
+		case tar.TypeReg:
+			// the tar reader does not read ahead, the content of the file starts at the current position
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				t.Close()
+				return nil, err
+			}
+			entry := &tarEntry{offset: offset, size: h.Size}
+			if isSparse(h) && h.Size <= maxSize {
+				if entry.data, err = io.ReadAll(tr); err != nil {
+					t.Close()
+					return nil, err
+				}
+			}
+			t.files[name] = entry
+			add(name, h.FileInfo())
+		}
+	}
+
+	for _, infos := range t.dirs {
+		sort.Sort(FileInfoList(infos))
+	}
+	return t, nil
+}
+
+func (t *tarFS) ReadDir(name string) ([]os.FileInfo, error) {
+	if infos, ok := t.dirs[name]; ok {
+		return append([]os.FileInfo(nil), infos...), nil
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+}
+
+// Sparse files in the pax format are regular files, the tar reader fills in the holes
+func isSparse(h *tar.Header) bool {
+	for key := range h.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tarFS) ReadFile(name string) ([]byte, error) {
+	entry, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.data != nil {
+		return entry.data, nil
+	}
+	data := make([]byte, entry.size)
+	if _, err := t.f.ReadAt(data, entry.offset); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (t *tarFS) Close() error {
+	err := t.f.Close()
+	if t.temp {
+		os.Remove(t.f.Name())
+	}
+	return err
+}
+
+// archiveRootInfo the archive file, compared as a directory
+type archiveRootInfo struct {
+	os.FileInfo
+}
+
+func (a *archiveRootInfo) Mode() os.FileMode { return a.FileInfo.Mode() | os.ModeDir }
+func (a *archiveRootInfo) IsDir() bool       { return true }
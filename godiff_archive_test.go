@@ -0,0 +1,130 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Modification time of the files in the tar files, and in the directories compared with them
+var archiveTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// Write the files in a tar file, compressed with gzip if compress is set
+func writeTar(t *testing.T, fName string, files map[string]string, compress bool) {
+	t.Helper()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: archiveTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if compress {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = gz.Bytes()
+	}
+	if err := os.WriteFile(fName, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A tar file must be compared the same as the directory it was made from
+func TestArchive(t *testing.T) {
+	files1 := map[string]string{
+		"same":                              "x\n",
+		"changed":                           "a\nb\nc\n",
+		"removed":                           "r\n",
+		"sub/changed":                       "1\n2\n",
+		"sub/" + strings.Repeat("long", 30): "old\n",
+	}
+	files2 := map[string]string{
+		"same":                              "x\n",
+		"changed":                           "a\nB\nc\n",
+		"added":                             "n\n",
+		"sub/changed":                       "1\n2\n3\n",
+		"sub/" + strings.Repeat("long", 30): "new\n",
+	}
+
+	dir := t.TempDir()
+	for root, files := range map[string]map[string]string{"a": files1, "b": files2} {
+		for name, data := range files {
+			fName := filepath.Join(dir, root, name)
+			writeFiles(t, dir, map[string]string{filepath.Join(root, name): data})
+			if err := os.Chtimes(fName, archiveTime, archiveTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeTar(t, filepath.Join(dir, "a.tar"), files1, false)
+	writeTar(t, filepath.Join(dir, "a.tar.gz"), files1, true)
+
+	// compressed tar files are decompressed to a temporary file, which must be removed
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	for _, args := range [][]string{{"-n"}, {"-n", "-u"}, {"-format", "json"}} {
+		want, _, wantStatus := runGodiff(t, dir, "", append(args, "a", "b")...)
+		if wantStatus != 1 {
+			t.Fatalf("%v: exit status %d, want 1", args, wantStatus)
+		}
+		for _, archive := range []string{"a.tar", "a.tar.gz"} {
+			output, _, status := runGodiff(t, dir, "", append(args, archive, "b")...)
+			if got := strings.ReplaceAll(output, archive, "a"); got != want || status != wantStatus {
+				t.Errorf("%v %s: got %q, exit status %d, want %q, exit status %d", args, archive, got, status, want, wantStatus)
+			}
+		}
+	}
+
+	if entries, _ := os.ReadDir(tmp); len(entries) > 0 {
+		t.Errorf("temporary file %s not removed", entries[0].Name())
+	}
+
+	// the files larger than -max-size are not read
+	output, _, _ := runGodiff(t, dir, "", "-n", "-max-size", "3", "a.tar", "b")
+	if !strings.Contains(output, "--- a.tar/changed: "+MsgFileTooBig) || strings.Contains(output, "a.tar/same") {
+		t.Errorf("-max-size 3: got %q", output)
+	}
+}
@@ -0,0 +1,446 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// Binary files shown as hex and ascii, with the -binary option
+const (
+	BinaryContextRows = 2     // rows of unchanged bytes shown before and after the changes
+	BinaryRefineSize  = 65536 // find the changed bytes within modified blocks up to this size
+	BinaryRowSize     = 16    // bytes in each row of the html output
+	MsgBinFirstDiff   = "First difference at offset 0x%x, %d bytes differ"
+)
+
+// DiffChangerBinary changes between binary files, found by comparing blocks of bytes.
+// The changes are collected first, to count the bytes that differ before the output.
+type DiffChangerBinary struct {
+	*OutputFormat
+	data1, data2       []byte
+	offsets1, offsets2 []int // start of each block, and the end of the data
+	groups             [][]diff.DiffOp
+	rowSize            int
+}
+
+// binaryRow bytes from each file shown in one row of the output.
+// Both files are empty in the row marking the unchanged bytes that are not shown.
+type binaryRow struct {
+	off1, off2 int
+	n1, n2     int
+	changed    []bool // changed bytes, in rows where both files are at the same position
+	gutter     byte   // ' ' same, '|' modified, '<' removed, '>' inserted
+}
+
+// binaryRows rows for a group of changes
+type binaryRows struct {
+	rows []binaryRow
+	size int
+	open bool // the last row can take more bytes at the same position in both files
+}
+
+// Start offset of each block, and the end of the data
+func blockOffsets(blocks [][]byte) []int {
+	offsets := make([]int, len(blocks)+1)
+	for i, b := range blocks {
+		offsets[i+1] = offsets[i] + len(b)
+	}
+	return offsets
+}
+
+// Offset of the first byte that differs, same as cmp
+func firstDifference(data1, data2 []byte) int {
+	n := minInt(len(data1), len(data2))
+	for i := 0; i < n; i++ {
+		if data1[i] != data2[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Compare binary files by blocks of bytes, and show the changes in hex
+func diffBinaryFile(cfg *DiffConfig, file1, file2 *FileData) {
+
+	blocks1 := diff.SplitBlocks(file1.data)
+	blocks2 := diff.SplitBlocks(file2.data)
+
+	rowSize := BinaryRowSize
+	if cfg.outputFormat == FormatText {
+		rowSize = textRowSize(cfg.width)
+	}
+
+	chg := &DiffChangerBinary{
+		OutputFormat: &OutputFormat{
+			cfg:       cfg,
+			name1:     file1.name,
+			name2:     file2.name,
+			fileInfo1: file1.info,
+			fileInfo2: file2.info,
+		},
+		data1:    file1.data,
+		data2:    file2.data,
+		offsets1: blockOffsets(blocks1),
+		offsets2: blockOffsets(blocks2),
+		rowSize:  rowSize,
+	}
+
+	opts := &diff.Options{ContextLines: 1, Algorithm: cfg.cmpOptions.Algorithm}
+	info1, info2 := diff.Compare(blocks1, blocks2, opts)
+	if !diff.ReportDiff(chg, info1.Ids, info2.Ids, info1.Change, info2.Change, opts) {
+		return
+	}
+	cfg.setExitStatus(ExitDifferent)
+
+	chg.note = fmt.Sprintf(MsgBinFirstDiff, firstDifference(file1.data, file2.data), chg.countBytes())
+	if cfg.outputFormat == FormatText {
+		chg.writeText()
+	} else {
+		chg.writeHtml()
+	}
+}
+
+// Bytes in each row of the text output, to fit both files in the width of the terminal
+func textRowSize(width int) int {
+	n := 4
+	for n < 16 && 25+16*n <= width {
+		n *= 2
+	}
+	return n
+}
+
+// Convert the block changes to bytes, and find the changed bytes within the modified blocks
+func (chg *DiffChangerBinary) DiffLines(ops []diff.DiffOp) {
+
+	var group []diff.DiffOp
+	for _, v := range ops {
+		op := diff.DiffOp{
+			Op:     v.Op,
+			Start1: chg.offsets1[v.Start1], End1: chg.offsets1[v.End1],
+			Start2: chg.offsets2[v.Start2], End2: chg.offsets2[v.End2],
+		}
+		if op.Op == diff.DiffOpModify && op.End1-op.Start1+op.End2-op.Start2 <= BinaryRefineSize {
+			group = appendByteChanges(group, chg.data1, chg.data2, op)
+		} else {
+			group = appendBinaryOp(group, op)
+		}
+	}
+	chg.groups = append(chg.groups, group)
+}
+
+// Add the changes, joining unchanged bytes with the previous unchanged bytes
+func appendBinaryOp(ops []diff.DiffOp, op diff.DiffOp) []diff.DiffOp {
+	if n := len(ops); n > 0 && op.Op == diff.DiffOpSame && ops[n-1].Op == diff.DiffOpSame {
+		ops[n-1].End1, ops[n-1].End2 = op.End1, op.End2
+		return ops
+	}
+	return append(ops, op)
+}
+
+// Compare the modified blocks byte by byte, and add the changes
+func appendByteChanges(ops []diff.DiffOp, data1, data2 []byte, op diff.DiffOp) []diff.DiffOp {
+
+	// byte values + 1, as 0 is used for blank lines
+	cmp1 := make([]int, op.End1-op.Start1)
+	for i := range cmp1 {
+		cmp1[i] = int(data1[op.Start1+i]) + 1
+	}
+	cmp2 := make([]int, op.End2-op.Start2)
+	for i := range cmp2 {
+		cmp2[i] = int(data2[op.Start2+i]) + 1
+	}
+
+	change1, change2 := diff.DoDiff(cmp1, cmp2)
+
+	// all bytes in a single group
+	opts := &diff.Options{ContextLines: len(cmp1) + len(cmp2)}
+	diff.ReportDiff(diff.DiffChangerFunc(func(group []diff.DiffOp) {
+		for _, v := range group {
+			v.Start1, v.End1 = v.Start1+op.Start1, v.End1+op.Start1
+			v.Start2, v.End2 = v.Start2+op.Start2, v.End2+op.Start2
+			ops = appendBinaryOp(ops, v)
+		}
+	}), cmp1, cmp2, change1, change2, opts)
+
+	return ops
+}
+
+// Number of bytes that differ
+func (chg *DiffChangerBinary) countBytes() int {
+	n := 0
+	for _, group := range chg.groups {
+		for _, v := range group {
+			if v.Op != diff.DiffOpSame {
+				n += maxInt(v.End1-v.Start1, v.End2-v.Start2)
+			}
+		}
+	}
+	return n
+}
+
+// Add bytes at the same position in both files, to the last row if possible
+func (b *binaryRows) addBytes(i1, i2, n int, changed bool) {
+	for ; n > 0; i1, i2, n = i1+1, i2+1, n-1 {
+		if !b.open || b.rows[len(b.rows)-1].n1 == b.size {
+			b.rows = append(b.rows, binaryRow{off1: i1, off2: i2, gutter: ' '})
+			b.open = true
+		}
+		r := &b.rows[len(b.rows)-1]
+		r.n1++
+		r.n2++
+		r.changed = append(r.changed, changed)
+		if changed {
+			r.gutter = '|'
+		}
+	}
+}
+
+// Number of bytes that can still be added to the last row
+func (b *binaryRows) room() int {
+	if !b.open {
+		return 0
+	}
+	return b.size - b.rows[len(b.rows)-1].n1
+}
+
+// Add bytes that are not aligned between the files, in rows of their own
+func (b *binaryRows) addBlock(i1, n1, i2, n2 int, gutter byte) {
+	b.open = false
+	for k := 0; k < n1 || k < n2; k += b.size {
+		b.rows = append(b.rows, binaryRow{
+			off1: i1 + k, n1: minInt(maxInt(n1-k, 0), b.size),
+			off2: i2 + k, n2: minInt(maxInt(n2-k, 0), b.size),
+			gutter: gutter,
+		})
+	}
+}
+
+// Mark the unchanged bytes that are not shown
+func (b *binaryRows) skip() {
+	b.open = false
+	b.rows = append(b.rows, binaryRow{gutter: ' '})
+}
+
+// Rows to show for a group of changes, with a few rows of unchanged bytes around the changes
+func (chg *DiffChangerBinary) groupRows(group []diff.DiffOp) []binaryRow {
+
+	b := &binaryRows{size: chg.rowSize}
+	context := BinaryContextRows * chg.rowSize
+
+	for i, v := range group {
+		n1, n2 := v.End1-v.Start1, v.End2-v.Start2
+		switch {
+		case v.Op == diff.DiffOpSame:
+			head, tail := n1, 0
+			switch {
+			case i == 0:
+				head, tail = 0, minInt(n1, context)
+			case i == len(group)-1:
+				head = minInt(n1, b.room()+context)
+			case n1 > b.room()+2*context+chg.rowSize:
+				head, tail = b.room()+context, context
+			}
+			b.addBytes(v.Start1, v.Start2, head, false)
+			if i > 0 && i < len(group)-1 && head+tail < n1 {
+				b.skip()
+			}
+			b.addBytes(v.End1-tail, v.End2-tail, tail, false)
+
+		case v.Op == diff.DiffOpModify && n1 == n2:
+			b.addBytes(v.Start1, v.Start2, n1, true)
+
+		case v.Op == diff.DiffOpModify:
+			b.addBlock(v.Start1, n1, v.Start2, n2, '|')
+
+		case v.Op == diff.DiffOpRemove:
+			b.addBlock(v.Start1, n1, v.Start2, 0, '<')
+
+		default:
+			b.addBlock(v.Start1, 0, v.Start2, n2, '>')
+		}
+	}
+	return b.rows
+}
+
+// printable ascii, other bytes are shown as '.'
+func asciiByte(c byte) byte {
+	if c < ' ' || c > '~' {
+		return '.'
+	}
+	return c
+}
+
+// Write the offset, hex and ascii of the bytes in a row of the text output
+func (chg *DiffChangerBinary) writeTextCell(buf *bytes.Buffer, data []byte, off int, changed []bool, color string) {
+
+	useColor := chg.cfg.color && color != ""
+	if useColor {
+		buf.WriteString(color)
+	}
+	fmt.Fprintf(buf, "%08x ", off)
+
+	for _, part := range [2]bool{true, false} {
+		inChg := false
+		for i, c := range data {
+			if isChg := useColor && changed != nil && changed[i]; isChg != inChg {
+				if isChg {
+					buf.WriteString(AnsiChg)
+				} else {
+					buf.WriteString(AnsiChgOff)
+				}
+				inChg = isChg
+			}
+			if part {
+				fmt.Fprintf(buf, " %02x", c)
+			} else {
+				buf.WriteByte(asciiByte(c))
+			}
+		}
+		if inChg {
+			buf.WriteString(AnsiChgOff)
+		}
+		if part {
+			buf.WriteString(strings.Repeat("   ", chg.rowSize-len(data)))
+			buf.WriteString("  ")
+		}
+	}
+
+	if useColor {
+		buf.WriteString(AnsiReset)
+	}
+	buf.WriteString(strings.Repeat(" ", chg.rowSize-len(data)))
+}
+
+// Output the changes in text format, both files side by side
+func (chg *DiffChangerBinary) writeText() {
+
+	cfg := chg.cfg
+	cfg.outAcquireLock()
+	defer cfg.outReleaseLock()
+
+	writeTextHeader(chg.OutputFormat)
+
+	// offset, hex and ascii
+	cellWidth := 9 + chg.rowSize*4 + 2
+	colors := map[byte][2]string{' ': {"", ""}, '|': {AnsiUpd, AnsiUpd}, '<': {AnsiDel, ""}, '>': {"", AnsiAdd}}
+
+	for g, group := range chg.groups {
+		if g > 0 {
+			out.WriteString("---\n")
+		}
+		for _, r := range chg.groupRows(group) {
+			chg.buf1.Reset()
+			switch {
+			case r.n1 == 0 && r.n2 == 0:
+				chg.buf1.WriteString("...")
+			case r.n1 == 0:
+				chg.buf1.WriteString(strings.Repeat(" ", cellWidth))
+			default:
+				chg.writeTextCell(&chg.buf1, chg.data1[r.off1:r.off1+r.n1], r.off1, r.changed, colors[r.gutter][0])
+			}
+			if r.n2 > 0 {
+				chg.buf1.WriteByte(' ')
+				chg.buf1.WriteByte(r.gutter)
+				chg.buf1.WriteByte(' ')
+				chg.writeTextCell(&chg.buf1, chg.data2[r.off2:r.off2+r.n2], r.off2, r.changed, colors[r.gutter][1])
+			} else if r.n1 > 0 {
+				chg.buf1.WriteByte(' ')
+				chg.buf1.WriteByte(r.gutter)
+			}
+			out.Write(bytes.TrimRight(chg.buf1.Bytes(), " "))
+			out.WriteByte('\n')
+		}
+	}
+	out.WriteByte('\n')
+}
+
+// Write the offset, hex and ascii of the bytes in a row of the html output
+func writeHtmlHexRow(buf *bytes.Buffer, data []byte, off int, changed []bool) {
+
+	fmt.Fprintf(buf, "<span class=\"lno\">%08x </span>", off)
+
+	for _, part := range [2]bool{true, false} {
+		inChg := false
+		for i, c := range data {
+			if isChg := changed != nil && changed[i]; isChg != inChg {
+				if isChg {
+					buf.WriteString("<span class=\"chg\">")
+				} else {
+					buf.WriteString("</span>")
+				}
+				inChg = isChg
+			}
+			if part {
+				fmt.Fprintf(buf, "%02x ", c)
+			} else {
+				writeHtmlBytes(buf, []byte{asciiByte(c)})
+			}
+		}
+		if inChg {
+			buf.WriteString("</span>")
+		}
+		if part {
+			buf.WriteString(strings.Repeat("   ", BinaryRowSize-len(data)))
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteByte('\n')
+}
+
+// Output the changes in html format, both files side by side
+func (chg *DiffChangerBinary) writeHtml() {
+
+	htmlFileTable(chg.OutputFormat)
+
+	classes := map[byte][2]string{' ': {"nop", "nop"}, '|': {"upd", "upd"}, '<': {"del", "nop"}, '>': {"nop", "add"}}
+
+	for _, group := range chg.groups {
+		chg.buf1.Reset()
+		chg.buf2.Reset()
+		for _, r := range chg.groupRows(group) {
+			class := classes[r.gutter]
+			for i, buf := range [2]*bytes.Buffer{&chg.buf1, &chg.buf2} {
+				data, off, n := chg.data1, r.off1, r.n1
+				if i > 0 {
+					data, off, n = chg.data2, r.off2, r.n2
+				}
+				fmt.Fprintf(buf, "<span class=\"%s\">", class[i])
+				switch {
+				case r.n1 == 0 && r.n2 == 0:
+					buf.WriteString("<span class=\"lno\">...</span>\n")
+				case n == 0:
+					buf.WriteString("<span class=\"lno\"> </span>\n")
+				default:
+					writeHtmlHexRow(buf, data[off:off+n], off, r.changed)
+				}
+				buf.WriteString("</span>")
+			}
+		}
+		out.WriteString("<tr><td class=\"ttd\">")
+		out.Write(chg.buf1.Bytes())
+		out.WriteString("</td><td class=\"ttd\">")
+		out.Write(chg.buf2.Bytes())
+		out.WriteString("</td></tr>\n")
+	}
+
+	htmlFileTableEnd(chg.OutputFormat)
+}
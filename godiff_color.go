@@ -0,0 +1,138 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// Options for -color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI escape sequences for colour terminal output
+const (
+	AnsiReset  = "\x1b[0m"
+	AnsiBold   = "\x1b[1m"
+	AnsiDel    = "\x1b[31m"
+	AnsiAdd    = "\x1b[32m"
+	AnsiUpd    = "\x1b[33m"
+	AnsiInf    = "\x1b[36m"
+	AnsiChg    = "\x1b[7m"
+	AnsiChgOff = "\x1b[27m"
+)
+
+// Check if the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Decide if colours are used for the -color option.
+// In auto mode, colours are used when writing to a terminal, unless NO_COLOR is set,
+// or the output is written to a file with -o or -o-dir.
+func useColor(mode string, toFile bool) (bool, bool) {
+	switch mode {
+	case ColorAlways:
+		return true, true
+	case ColorNever:
+		return false, true
+	case ColorAuto:
+		return !toFile && isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "", true
+	}
+	return false, false
+}
+
+// Wrap the text with the ANSI escape code, if colours are used
+func (cfg *DiffConfig) colored(code, s string) string {
+	if !cfg.color || s == "" {
+		return s
+	}
+	return code + s + AnsiReset
+}
+
+// Write a single line in colour, with the changes within the line highlighted
+func writeColorLine(prefix, code string, line []byte, pos []int, change []bool) {
+	out.WriteString(code)
+	out.WriteString(prefix)
+	inChg := false
+	for i := 0; i+1 < len(pos); i++ {
+		if change[i] != inChg {
+			if change[i] {
+				out.WriteString(AnsiChg)
+			} else {
+				out.WriteString(AnsiChgOff)
+			}
+			inChg = change[i]
+		}
+		out.Write(line[pos[i]:pos[i+1]])
+	}
+	if pos == nil {
+		out.Write(line)
+	}
+	out.WriteString(AnsiReset)
+	out.WriteByte('\n')
+}
+
+// Write the removed lines with prefix1, and the added lines with prefix2, and a separator in between.
+// In colour, the lines modified in pairs have the changes within the lines highlighted.
+func writeChangedLines(cfg *DiffConfig, prefix1, prefix2, separator string, v diff.DiffOp, file1, file2 [][]byte, noNewline1, noNewline2 bool) {
+
+	// the changes within lines, for each pair of modified lines
+	n := 0
+	var pos1, pos2 [][]int
+	var change1, change2 [][]bool
+	if cfg.color && v.Op == diff.DiffOpModify && !cfg.suppressLineChanges {
+		n = minInt(v.End1-v.Start1, v.End2-v.Start2)
+		pos1, pos2 = make([][]int, n), make([][]int, n)
+		change1, change2 = make([][]bool, n), make([][]bool, n)
+		for i := 0; i < n; i++ {
+			pos1[i], change1[i], pos2[i], change2[i] = lineChanges(cfg, file1[v.Start1+i], file2[v.Start2+i])
+		}
+	}
+
+	writeColorTextLines(cfg, prefix1, AnsiDel, file1, v.Start1, v.End1, noNewline1, pos1, change1)
+	if v.End1 > v.Start1 && v.End2 > v.Start2 {
+		out.WriteString(separator)
+	}
+	writeColorTextLines(cfg, prefix2, AnsiAdd, file2, v.Start2, v.End2, noNewline2, pos2, change2)
+}
+
+// Write lines[start:end] in text format, in colour if enabled.
+// pos and change are the changes within the first lines, if any.
+func writeColorTextLines(cfg *DiffConfig, prefix, code string, lines [][]byte, start, end int, noNewline bool, pos [][]int, change [][]bool) {
+	if !cfg.color {
+		writeTextLines(prefix, lines, start, end, noNewline)
+		return
+	}
+	for i := start; i < end; i++ {
+		if i-start < len(pos) {
+			writeColorLine(prefix, code, lines[i], pos[i-start], change[i-start])
+		} else {
+			writeColorLine(prefix, code, lines[i], nil, nil)
+		}
+		if noNewline && i == len(lines)-1 {
+			out.WriteString(MsgNoNewlineAtEOF)
+			out.WriteByte('\n')
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// MagicSize number of bytes read to detect compressed files and archives
+const MagicSize = 512
+
+// Decompressor a compressed file format, detected by the magic bytes at the start of the file
+type Decompressor struct {
+	name   string
+	magic  []byte
+	reader func(r io.Reader) (io.Reader, error)
+}
+
+// compressed file formats, see registerDecompressor()
+var decompressors []*Decompressor
+
+func init() {
+	registerDecompressor("gzip", []byte{0x1f, 0x8b, 0x08}, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+	registerDecompressor("bzip2", []byte("BZh"), func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	})
+	registerDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	})
+	registerDecompressor("xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	})
+	registerDecompressor("lz4", []byte{0x04, 0x22, 0x4d, 0x18}, newLz4Reader)
+}
+
+// Add a compressed file format. Files starting with the magic bytes are read
+// through the reader returned by newReader, it is closed afterwards if it is an io.Closer.
+func registerDecompressor(name string, magic []byte, newReader func(r io.Reader) (io.Reader, error)) {
+	decompressors = append(decompressors, &Decompressor{name: name, magic: magic, reader: newReader})
+}
+
+// Find the format of a compressed file from the first bytes of the file, nil if not compressed
+func findDecompressor(data []byte) *Decompressor {
+	for _, d := range decompressors {
+		if bytes.HasPrefix(data, d.magic) {
+			return d
+		}
+	}
+	return nil
+}
+
+// Read the start of a file, to detect its format
+func readMagic(fName string) []byte {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	magic := make([]byte, MagicSize)
+	n, _ := io.ReadFull(f, magic)
+	return magic[:n]
+}
+
+// Set the file content, decompress it if necessary
+func (file *FileData) setData(data []byte, maxSize int64) {
+	if d := findDecompressor(data); d != nil {
+		file.decompress(d, bytes.NewReader(data), maxSize)
+	} else {
+		file.data = data
+	}
+}
+
+// Replace the file data with the decompressed content of r
+func (file *FileData) decompress(d *Decompressor, r io.Reader, maxSize int64) {
+
+	reader, err := d.reader(r)
+	if err != nil {
+		file.errorMsg = d.name + ": " + err.Error()
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if c, ok := reader.(io.Closer); ok {
+		c.Close()
+	}
+
+	switch {
+	case err != nil:
+		file.errorMsg = d.name + ": " + err.Error()
+	case int64(len(data)) > maxSize:
+		file.errorMsg = MsgFileTooBig
+	default:
+		file.data = data
+	}
+}
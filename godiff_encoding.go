@@ -0,0 +1,223 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// EncodingAuto detect the encoding of each file
+const EncodingAuto = "auto"
+
+// Encoding character encoding of a text file, converted to UTF-8 before comparing lines
+type Encoding struct {
+	name     string
+	encoding encoding.Encoding // nil for UTF-8, the data is used as is
+}
+
+// Encodings found by detectEncoding()
+var (
+	EncodingUTF8    = &Encoding{name: "utf-8"}
+	EncodingUTF16LE = &Encoding{name: "utf-16le", encoding: unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)}
+	EncodingUTF16BE = &Encoding{name: "utf-16be", encoding: unicode.UTF16(unicode.BigEndian, unicode.UseBOM)}
+	EncodingLatin1  = &Encoding{name: "windows-1252", encoding: charmap.Windows1252}
+	EncodingSJIS    = &Encoding{name: "shift_jis", encoding: japanese.ShiftJIS}
+)
+
+// Parse the -encoding option: auto, a single encoding for both files, or one for each file separated by a comma.
+// The encodings are named as in html pages, eg. utf-16le, latin1, shift_jis.
+// Returns nil for the files whose encoding is detected.
+func parseEncodings(s string) ([2]*Encoding, error) {
+
+	var encodings [2]*Encoding
+
+	names := strings.Split(s, ",")
+	if len(names) > 2 {
+		return encodings, fmt.Errorf("Invalid encoding: %s", s)
+	}
+
+	for i := range encodings {
+		name := strings.TrimSpace(names[minInt(i, len(names)-1)])
+		if strings.EqualFold(name, EncodingAuto) {
+			continue
+		}
+		enc, err := htmlindex.Get(name)
+		if err != nil {
+			return encodings, fmt.Errorf("Invalid encoding: %s", name)
+		}
+		canonical, _ := htmlindex.Name(enc)
+		if enc == unicode.UTF8 {
+			encodings[i] = EncodingUTF8
+		} else {
+			encodings[i] = &Encoding{name: canonical, encoding: enc}
+		}
+	}
+	return encodings, nil
+}
+
+// Guess the encoding of the data, from the byte order mark, or the bytes used.
+// Returns nil for binary files.
+func detectEncoding(data []byte) *Encoding {
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+
+	header := data[0:minInt(len(data), BinaryCheckSize)]
+	if bytes.IndexByte(header, 0) < 0 {
+		switch {
+		case utf8.Valid(data):
+			return EncodingUTF8
+		case isShiftJIS(data):
+			return EncodingSJIS
+		}
+		return EncodingLatin1
+	}
+
+	// UTF-16 without byte order mark, the text is mostly ascii with a 0 in every other byte
+	var zeros [2]int
+	for i, b := range header {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+	n := len(header) / 2
+	switch {
+	case zeros[1] > n*3/10 && zeros[0] < n/20:
+		return EncodingUTF16LE
+	case zeros[0] > n*3/10 && zeros[1] < n/20:
+		return EncodingUTF16BE
+	}
+	return nil
+}
+
+// Check if all the non ascii bytes form valid Shift-JIS characters, with at least one double byte character
+func isShiftJIS(data []byte) bool {
+	double := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b < 0x80 || (b >= 0xa1 && b <= 0xdf):
+			// ascii, or half width katakana
+		case (b >= 0x81 && b <= 0x9f) || (b >= 0xe0 && b <= 0xfc):
+			if i+1 >= len(data) {
+				return false
+			}
+			if t := data[i+1]; t < 0x40 || t == 0x7f || t > 0xfc {
+				return false
+			}
+			i++
+			double++
+		default:
+			return false
+		}
+	}
+	return double > 0
+}
+
+// Check if newlines and ascii characters are single bytes in this encoding,
+// so that the lines can be split and compared without converting them.
+func (enc *Encoding) asciiCompatible() bool {
+	newline, err := enc.encoding.NewEncoder().Bytes([]byte("\n"))
+	return err == nil && string(newline) == "\n"
+}
+
+// Text diffs output the bytes of the files, so that they can be applied with patch, same as gnu diff.
+// The html pages, side by side and json output show the text converted to UTF-8.
+func (cfg *DiffConfig) keepFileBytes() bool {
+	return cfg.outputFormat == FormatText && !cfg.sideBySide
+}
+
+// Convert the data of the file (on side 0 or 1) to UTF-8, the lines are always compared converted.
+// The encoding is detected unless chosen with -encoding.
+// For text diffs, the bytes of encodings that are ascii compatible are kept in raw, to output the lines as they are.
+func (file *FileData) decode(cfg *DiffConfig, side int) {
+
+	if len(file.data) == 0 || file.errorMsg != "" {
+		return
+	}
+	file.bom = hasBOM(file.data)
+	enc := cfg.encodings[side]
+	if enc == nil {
+		enc = detectEncoding(file.data)
+	}
+	if enc == nil {
+		return
+	}
+
+	file.encoding = enc.name
+	if enc.encoding == nil {
+		return
+	}
+
+	data, err := enc.encoding.NewDecoder().Bytes(file.data)
+	if err != nil {
+		file.errorMsg = enc.name + ": " + err.Error()
+		return
+	}
+
+	switch {
+	case cfg.keepFileBytes() && enc.asciiCompatible():
+		file.raw = file.data
+	case file.isMapped:
+		// the converted data replaces the mapped file
+		unmap_file(file.data)
+		file.isMapped = false
+	}
+	file.data = data
+}
+
+// The lines to output: the lines of the bytes kept by decode(), or the lines given.
+// The newlines of ascii compatible encodings are the same bytes, the lines end at the same places.
+func (file *FileData) outputLines(lines [][]byte) [][]byte {
+	if file.raw == nil {
+		return lines
+	}
+
+	raw := make([][]byte, 0, len(lines))
+	data := file.raw
+	for _, eol := range file.eols {
+		end := bytes.IndexAny(data, "\r\n")
+		if end < 0 {
+			end = len(data)
+		}
+		raw = append(raw, data[:end])
+		switch eol {
+		case EolCRLF:
+			end += 2
+		case EolLF, EolCR:
+			end++
+		}
+		data = data[minInt(end, len(data)):]
+	}
+	if len(raw) != len(lines) {
+		return lines
+	}
+	return raw
+}
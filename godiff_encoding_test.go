@@ -0,0 +1,73 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+// UTF-16 little endian without byte order mark
+func utf16le(s string) string {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return string(b)
+}
+
+// The lines are compared converted to UTF-8, whatever the output format
+func TestEncoding(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"utf8":    "un caf\xc3\xa9 au lait, merci bien\nx\n",
+		"latin1":  "un caf\xe9 au lait, merci bien\nx\n",
+		"latin1b": "un caf\xe9 au lait, merci bien\ny\n",
+		"utf16":   utf16le("un café au lait, merci bien\nx\n"),
+	})
+
+	for _, args := range [][]string{
+		{"-n"},
+		{"-n", "-u"},
+		{"-n", "-q"},
+		{"-n", "-y"},
+		{"-format", "json"},
+		{"-format", "html"},
+	} {
+		for _, files := range [][]string{{"utf8", "latin1"}, {"latin1", "utf16"}} {
+			output, _, status := runGodiff(t, dir, "", append(args, files...)...)
+			if status != 0 || (output != "" && args[0] == "-n") {
+				t.Errorf("%v %v: got %q, exit status %d, want the same files", args, files, output, status)
+			}
+		}
+
+		_, _, status := runGodiff(t, dir, "", append(args, "utf8", "latin1b")...)
+		if status != 1 {
+			t.Errorf("%v utf8 latin1b: exit status %d, want 1", args, status)
+		}
+	}
+
+	// text diffs output the lines as they are in the files
+	output, _, _ := runGodiff(t, dir, "", "-n", "-u", "latin1", "latin1b")
+	if got, want := unifiedHunks(output), "@@ -1,2 +1,2 @@\n un caf\xe9 au lait, merci bien\n-x\n+y\n"; got != want {
+		t.Errorf("-u latin1 latin1b: got %q, want %q", got, want)
+	}
+	output, _, _ = runGodiff(t, dir, "", "-n", "-u", "utf16", "latin1b")
+	if got, want := unifiedHunks(output), "@@ -1,2 +1,2 @@\n un caf\xc3\xa9 au lait, merci bien\n-x\n+y\n"; got != want {
+		t.Errorf("-u utf16 latin1b: got %q, want %q", got, want)
+	}
+}
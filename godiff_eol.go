@@ -0,0 +1,244 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// Line ending of each line
+const (
+	EolNone = iota // last line of the file, without a newline
+	EolLF
+	EolCRLF
+	EolCR
+)
+
+// Messages for files with different line endings, or byte order mark
+const (
+	MsgEolDiffers = "Line endings differ (%s vs %s)"
+	MsgBomDiffers = "Byte order mark differs (%s vs %s)"
+)
+
+// MarkerCR shown after the lines ending with a carriage return, when the line endings are compared
+const MarkerCR = "␍"
+
+var eolNames = [...]string{"none", "LF", "CRLF", "CR"}
+
+// UTF-8 byte order mark, UTF-16 files are converted without it
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// Check if the data starts with a UTF-8 or UTF-16 byte order mark
+func hasBOM(data []byte) bool {
+	return bytes.HasPrefix(data, utf8BOM) || bytes.HasPrefix(data, []byte{0xff, 0xfe}) || bytes.HasPrefix(data, []byte{0xfe, 0xff})
+}
+
+// Remove the UTF-8 byte order mark from the first line, it is reported in the note and not as a change
+func stripBOM(lines [][]byte) [][]byte {
+	if len(lines) == 0 || !bytes.HasPrefix(lines[0], utf8BOM) {
+		return lines
+	}
+	return append([][]byte{lines[0][len(utf8BOM):]}, lines[1:]...)
+}
+
+// Unified text diffs compare and output the lines as they are in the files, split at each LF, same as gnu diff,
+// so that they can be applied with patch. The carriage returns and byte order mark are part of the lines.
+func (cfg *DiffConfig) compareRawLines() bool {
+	return cfg.outputFormat == FormatText && cfg.unifiedContext && !cfg.sideBySide && !cfg.stripTrailingCR
+}
+
+// Split the data at each LF, the lines keep their carriage returns
+func splitLF(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte{'\n'})
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = bytes.TrimSuffix(line, []byte{'\n'})
+	}
+	return lines
+}
+
+// The line endings used in the file, one bit for each of EolLF, EolCRLF and EolCR
+func eolStyle(eols []byte) int {
+	style := 0
+	for _, eol := range eols {
+		if eol != EolNone {
+			style |= 1 << eol
+		}
+	}
+	return style
+}
+
+// Name of the line endings used in the file
+func eolStyleName(style int) string {
+	switch {
+	case style == 0:
+		return eolNames[EolNone]
+	case bits.OnesCount(uint(style)) > 1:
+		return "mixed"
+	}
+	return eolNames[bits.TrailingZeros(uint(style))]
+}
+
+// Check if the line endings are compared line by line.
+// When each file uses the same line ending for all lines, the lines are compared without them,
+// and only a single message is shown if they are different.
+func compareLineEndings(cfg *DiffConfig, file1, file2 *FileData) bool {
+	if cfg.stripTrailingCR {
+		return false
+	}
+	style1, style2 := eolStyle(file1.eols), eolStyle(file2.eols)
+	return bits.OnesCount(uint(style1)) > 1 || bits.OnesCount(uint(style2)) > 1
+}
+
+// Message for the differences in line endings and byte order mark, that are not shown as changes
+func lineEndingsNote(cfg *DiffConfig, file1, file2 *FileData) string {
+
+	if cfg.compareRawLines() {
+		return ""
+	}
+
+	var notes []string
+
+	style1, style2 := eolStyle(file1.eols), eolStyle(file2.eols)
+	if !cfg.stripTrailingCR && style1 != 0 && style2 != 0 && style1 != style2 {
+		notes = append(notes, fmt.Sprintf(MsgEolDiffers, eolStyleName(style1), eolStyleName(style2)))
+	}
+
+	if file1.bom != file2.bom {
+		names := map[bool]string{true: "BOM", false: "no BOM"}
+		notes = append(notes, fmt.Sprintf(MsgBomDiffers, names[file1.bom], names[file2.bom]))
+	}
+
+	return strings.Join(notes, ", ")
+}
+
+// Lines to compare, including the carriage return at the end of the lines if the line endings are compared.
+// The carriage return is in the file data, just after the line.
+func linesWithCR(lines [][]byte, eols []byte) [][]byte {
+	cmp := make([][]byte, len(lines))
+	for i, line := range lines {
+		if eols[i] == EolCRLF || eols[i] == EolCR {
+			line = line[:len(line)+1]
+		}
+		cmp[i] = line
+	}
+	return cmp
+}
+
+// Lines to display, with a marker after the lines ending with a carriage return
+func markLineEndings(lines [][]byte, eols []byte) [][]byte {
+	marked := make([][]byte, len(lines))
+	for i, line := range lines {
+		if eols[i] == EolCRLF || eols[i] == EolCR {
+			line = append(line[:len(line):len(line)], MarkerCR...)
+		}
+		marked[i] = line
+	}
+	return marked
+}
+
+// Find the changes between the lines of two files.
+// If the line endings are compared, the lines returned are marked with their line endings, to be displayed.
+func compareFileLines(cfg *DiffConfig, file1, file2 *FileData, lines1, lines2 [][]byte) ([][]byte, [][]byte, *diff.LinesData, *diff.LinesData) {
+
+	if cfg.compareRawLines() {
+		cmp1, out1 := file1.rawLines()
+		cmp2, out2 := file2.rawLines()
+		info1, info2 := compareLines(cfg, file1, file2, cmp1, cmp2)
+		return out1, out2, info1, info2
+	}
+
+	// the lines are compared converted to UTF-8, and output as they are in the files for text diffs
+	lines1, lines2 = stripBOM(lines1), stripBOM(lines2)
+	out1, out2 := stripBOM(file1.outputLines(lines1)), stripBOM(file2.outputLines(lines2))
+
+	if !compareLineEndings(cfg, file1, file2) {
+		info1, info2 := compareLines(cfg, file1, file2, lines1, lines2)
+		return out1, out2, info1, info2
+	}
+
+	cmp1, cmp2 := linesWithCR(lines1, file1.eols), linesWithCR(lines2, file2.eols)
+	info1, info2 := compareLines(cfg, file1, file2, cmp1, cmp2)
+
+	// patches and json keep the carriage returns, same as gnu diff
+	if cfg.outputFormat == FormatJson || (cfg.outputFormat == FormatText && cfg.unifiedContext) {
+		return linesWithCR(out1, file1.eols), linesWithCR(out2, file2.eols), info1, info2
+	}
+	return markLineEndings(out1, file1.eols), markLineEndings(out2, file2.eols), info1, info2
+}
+
+// The lines of the file split at each LF, to compare and to output, see compareRawLines().
+// The last line does not end with a newline if the file does not end with LF.
+func (file *FileData) rawLines() ([][]byte, [][]byte) {
+	cmp := splitLF(file.data)
+	file.noNewline = len(file.data) > 0 && file.data[len(file.data)-1] != '\n'
+	if file.raw == nil {
+		return cmp, cmp
+	}
+	if out := splitLF(file.raw); len(out) == len(cmp) {
+		return cmp, out
+	}
+	return cmp, cmp
+}
+
+// Check if the lines of two files are the same, including the line endings and byte order mark reported in the note.
+// Used in brief mode, to report the same files as compareFileLines().
+func sameFileLines(cfg *DiffConfig, file1, file2 *FileData, lines1, lines2 [][]byte) bool {
+
+	if file1.isBinary || file2.isBinary || lineEndingsNote(cfg, file1, file2) != "" {
+		return false
+	}
+
+	if cfg.compareRawLines() {
+		lines1, _ = file1.rawLines()
+		lines2, _ = file2.rawLines()
+	} else {
+		lines1, lines2 = stripBOM(lines1), stripBOM(lines2)
+		if compareLineEndings(cfg, file1, file2) {
+			lines1, lines2 = linesWithCR(lines1, file1.eols), linesWithCR(lines2, file2.eols)
+		}
+	}
+	return file1.noNewline == file2.noNewline && diff.Equal(lines1, lines2, &cfg.cmpOptions)
+}
+
+// Write the message for line endings and byte order mark after the header of the text output
+func writeTextNote(cfg *DiffConfig, note string) {
+	if note != "" {
+		out.WriteString(cfg.colored(AnsiInf, note))
+		out.WriteByte('\n')
+	}
+}
+
+// Line ending from the newline characters after the line
+func lineEnding(newline []byte) byte {
+	switch {
+	case len(newline) == 0:
+		return EolNone
+	case len(newline) == 2:
+		return EolCRLF
+	case newline[0] == '\r':
+		return EolCR
+	}
+	return EolLF
+}
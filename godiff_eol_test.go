@@ -0,0 +1,85 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Files which differ only by their line endings or byte order mark
+func TestLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"crlf":  "1\r\n2\r\n3\r\n",
+		"crlf2": "1\r\n2\r\nx\r\n",
+		"lf":    "1\n2\n3\n",
+		"bom":   "\xef\xbb\xbf1\n2\n3\n",
+	})
+	eolNote := "Line endings differ (CRLF vs LF)"
+	bomNote := "Byte order mark differs (BOM vs no BOM)"
+
+	for _, maxSize := range []string{"0", "1"} {
+		// brief mode reports the same differences as the text diff
+		for _, args := range [][]string{{"-q"}, {"-q", "-b"}, {"-b"}} {
+			for _, files := range [][]string{{"crlf", "lf"}, {"bom", "lf"}} {
+				_, _, status := runGodiff(t, dir, "", append(append([]string{"-n", "-max-size", maxSize}, args...), files...)...)
+				if status != 1 {
+					t.Errorf("-max-size %s %v %v: exit status %d, want 1", maxSize, args, files, status)
+				}
+			}
+		}
+
+		// the line endings are reported as a note, not as changed lines
+		output, _, _ := runGodiff(t, dir, "", "-n", "-max-size", maxSize, "crlf", "lf")
+		if !strings.Contains(output, eolNote) || strings.Contains(output, "1c1") {
+			t.Errorf("-max-size %s crlf lf: got %q, want only %q", maxSize, output, eolNote)
+		}
+
+		// unified diffs compare and output the lines with their carriage returns, and can be applied by patch,
+		// which keeps the line endings of the patched file
+		for _, files := range [][]string{{"crlf", "crlf2"}, {"bom", "lf"}} {
+			diff, _, status := runGodiff(t, dir, "", "-n", "-u", "-max-size", maxSize, files[0], files[1])
+			if status != 1 || strings.Contains(diff, "<<<") {
+				t.Errorf("-u -max-size %s %v: got %q, exit status %d", maxSize, files, diff, status)
+				continue
+			}
+			patchDir := t.TempDir()
+			writeFiles(t, patchDir, map[string]string{files[0]: readFile(t, filepath.Join(dir, files[0]))})
+			if _, stderr, status := runGodiff(t, patchDir, diff, "patch"); status != 0 {
+				t.Errorf("-u -max-size %s %v: patch exit status %d: %s", maxSize, files, status, stderr)
+			} else if got, want := readFile(t, filepath.Join(patchDir, files[0])), readFile(t, filepath.Join(dir, files[1])); got != want {
+				t.Errorf("-u -max-size %s %v: patched file %q, want %q", maxSize, files, got, want)
+			}
+		}
+	}
+
+	output, _, status := runGodiff(t, dir, "", "-n", "-u", "crlf", "lf")
+	if status != 1 || strings.Contains(output, "<<<") {
+		t.Errorf("-u crlf lf: got %q, exit status %d", output, status)
+	}
+	if got, want := unifiedHunks(output), "@@ -1,3 +1,3 @@\n-1\r\n-2\r\n-3\r\n+1\n+2\n+3\n"; got != want {
+		t.Errorf("-u crlf lf: got %q, want %q", got, want)
+	}
+
+	// the byte order mark is not also reported as a changed line
+	output, _, _ = runGodiff(t, dir, "", "-n", "bom", "lf")
+	if !strings.Contains(output, bomNote) || strings.Contains(output, "1c1") {
+		t.Errorf("bom lf: got %q, want only %q", output, bomNote)
+	}
+}
@@ -0,0 +1,189 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// Status of a JSON record
+const (
+	JsonStatusIdentical = "identical"
+	JsonStatusModified  = "modified"
+	JsonStatusMissing   = "missing"
+	JsonStatusBinary    = "binary"
+	JsonStatusRenamed   = "renamed"
+	JsonStatusError     = "error"
+)
+
+// JsonRecord the result of comparing a pair of files, output as a single line of JSON
+type JsonRecord struct {
+	Status     string     `json:"status"`
+	File1      *JsonFile  `json:"file1"`
+	File2      *JsonFile  `json:"file2"`
+	Similarity int        `json:"similarity,omitempty"`
+	Hunks      []JsonHunk `json:"hunks,omitempty"`
+}
+
+// JsonFile details of one of the files being compared
+type JsonFile struct {
+	Name    string `json:"name"`
+	Exists  bool   `json:"exists"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// JsonHunk a group of changes, with the context lines around them.
+// Line numbers start from 0, the end of each range is exclusive.
+type JsonHunk struct {
+	Start1 int      `json:"start1"`
+	End1   int      `json:"end1"`
+	Start2 int      `json:"start2"`
+	End2   int      `json:"end2"`
+	Ops    []JsonOp `json:"ops"`
+}
+
+// JsonOp a range of lines that are the same, modified, inserted or removed
+type JsonOp struct {
+	Op       string     `json:"op"`
+	Start1   int        `json:"start1"`
+	End1     int        `json:"end1"`
+	Start2   int        `json:"start2"`
+	End2     int        `json:"end2"`
+	Lines1   []string   `json:"lines1,omitempty"`
+	Lines2   []string   `json:"lines2,omitempty"`
+	Changes1 []JsonSpan `json:"changes1,omitempty"`
+	Changes2 []JsonSpan `json:"changes2,omitempty"`
+}
+
+// JsonSpan changes within a modified line, as byte offsets. End is exclusive.
+type JsonSpan struct {
+	Line  int `json:"line"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// DiffChangerJson changes to be output as JSON records
+type DiffChangerJson struct {
+	DiffChangerData
+	hunks []JsonHunk
+}
+
+var jsonOpNames = map[int]string{
+	diff.DiffOpSame:   "same",
+	diff.DiffOpModify: "modify",
+	diff.DiffOpInsert: "insert",
+	diff.DiffOpRemove: "remove",
+}
+
+func newJsonFile(name string, info os.FileInfo, msg string) *JsonFile {
+	file := &JsonFile{Name: name, Message: msg}
+	if info != nil {
+		file.Exists = true
+		file.Size = info.Size()
+		file.ModTime = info.ModTime().Format(time.RFC3339Nano)
+	}
+	return file
+}
+
+// Determine the status of a pair of files from the messages reported.
+// Files reported with other messages, e.g. when only the line endings differ, are modified.
+func jsonStatus(msg1, msg2 string, isError bool) string {
+	switch {
+	case msg1 == MsgFileIdentical:
+		return JsonStatusIdentical
+	case msg1 == MsgFileNotExists || msg2 == MsgFileNotExists || msg1 == MsgDirNotExists || msg2 == MsgDirNotExists:
+		return JsonStatusMissing
+	case msg1 == MsgBinFileDiffers || msg2 == MsgBinFileDiffers:
+		return JsonStatusBinary
+	case isError:
+		return JsonStatusError
+	}
+	return JsonStatusModified
+}
+
+// Write a record as a single line of JSON
+func outputJsonRecord(cfg *DiffConfig, record *JsonRecord) {
+	cfg.outAcquireLock()
+	json.NewEncoder(out).Encode(record)
+	cfg.outReleaseLock()
+}
+
+func jsonLines(lines [][]byte) []string {
+	s := make([]string, len(lines))
+	for i, line := range lines {
+		s[i] = string(line)
+	}
+	return s
+}
+
+// Convert the changes within a line into spans of byte offsets
+func jsonSpans(spans []JsonSpan, lineno int, pos []int, change []bool) []JsonSpan {
+	for i, end := 0, len(change); i < end; {
+		j := i + 1
+		for j < end && change[j] == change[i] {
+			j++
+		}
+		if change[i] {
+			spans = append(spans, JsonSpan{Line: lineno, Start: pos[i], End: pos[j]})
+		}
+		i = j
+	}
+	return spans
+}
+
+func (chg *DiffChangerJson) DiffLines(ops []diff.DiffOp) {
+
+	hunk := JsonHunk{
+		Start1: ops[0].Start1,
+		End1:   ops[len(ops)-1].End1,
+		Start2: ops[0].Start2,
+		End2:   ops[len(ops)-1].End2,
+		Ops:    make([]JsonOp, 0, len(ops)),
+	}
+
+	for _, v := range ops {
+		w := chg.window(v)
+		op := JsonOp{
+			Op:     jsonOpNames[v.Op],
+			Start1: v.Start1,
+			End1:   v.End1,
+			Start2: v.Start2,
+			End2:   v.End2,
+			Lines1: jsonLines(chg.file1[w.Start1:w.End1]),
+			Lines2: jsonLines(chg.file2[w.Start2:w.End2]),
+		}
+
+		// report on changes within the modified lines
+		if v.Op == diff.DiffOpModify && !chg.cfg.suppressLineChanges {
+			for i1, i2 := v.Start1, v.Start2; i1 < v.End1 && i2 < v.End2; i1, i2 = i1+1, i2+1 {
+				pos1, change1, pos2, change2 := lineChanges(chg.cfg, chg.file1[i1-chg.first1], chg.file2[i2-chg.first2])
+				op.Changes1 = jsonSpans(op.Changes1, i1, pos1, change1)
+				op.Changes2 = jsonSpans(op.Changes2, i2, pos2, change2)
+			}
+		}
+
+		hunk.Ops = append(hunk.Ops, op)
+	}
+
+	chg.hunks = append(chg.hunks, hunk)
+}
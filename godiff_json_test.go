@@ -0,0 +1,80 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJsonOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"same1": "a\nb\n",
+		"same2": "a\nb\n",
+		"mod1":  "a\nb\n",
+		"mod2":  "a\nB\n",
+		"crlf1": "a\r\nb\r\n",
+		"crlf2": "a\nB\n",
+		"eol1":  "a\r\nb\r\n",
+		"eol2":  "a\nb\n",
+	})
+	eolNote := "Line endings differ (CRLF vs LF)"
+
+	tests := []struct {
+		file1, file2 string
+		status       string
+		message      string
+		hunks        int
+		exit         int
+	}{
+		{"same1", "same2", "", "", 0, 0},
+		{"mod1", "mod2", JsonStatusModified, "", 1, 1},
+		{"crlf1", "crlf2", JsonStatusModified, eolNote, 1, 1},
+		{"eol1", "eol2", JsonStatusModified, eolNote, 0, 1},
+	}
+
+	for _, test := range tests {
+		output, _, exit := runGodiff(t, dir, "", "-format", "json", test.file1, test.file2)
+		if exit != test.exit {
+			t.Errorf("%s %s: exit status %d, want %d", test.file1, test.file2, exit, test.exit)
+		}
+		if test.status == "" {
+			if output != "" {
+				t.Errorf("%s %s: got %q, want no output", test.file1, test.file2, output)
+			}
+			continue
+		}
+
+		var record JsonRecord
+		dec := json.NewDecoder(strings.NewReader(output))
+		if err := dec.Decode(&record); err != nil {
+			t.Errorf("%s %s: %v in %q", test.file1, test.file2, err, output)
+			continue
+		}
+		if dec.More() {
+			t.Errorf("%s %s: more than one record in %q", test.file1, test.file2, output)
+		}
+		if record.Status != test.status || len(record.Hunks) != test.hunks {
+			t.Errorf("%s %s: status %q with %d hunks, want %q with %d", test.file1, test.file2, record.Status, len(record.Hunks), test.status, test.hunks)
+		}
+		if test.message != "" && (record.File1.Message != test.message || record.File2.Message != test.message) {
+			t.Errorf("%s %s: messages %q and %q, want %q", test.file1, test.file2, record.File1.Message, record.File2.Message, test.message)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// LargeFileMaxLine lines longer than this cannot be compared
+const LargeFileMaxLine = 64 << 20
+
+// LargeFile a file larger than -max-size. Lines are converted to ids while the file is read,
+// only the offset of each line is kept, and the lines are read again when they are displayed.
+type LargeFile struct {
+	FileData
+	offsets []int64  // start of each line, and the end of the last line
+	lines   [][]byte // lines of the group of changes being displayed
+	first   int      // line number of lines[0]
+	lfOnly  bool     // lines are split at each LF and keep their carriage returns, see compareRawLines()
+}
+
+// Parse a size in bytes, with an optional K, M or G suffix
+func parseSize(s string) (int64, error) {
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n << shift, nil
+}
+
+// Files larger than -max-size are read one line at a time
+func (cfg *DiffConfig) isLargeFile(fName string, fInfo os.FileInfo) bool {
+	return fInfo.Size() > cfg.maxSize && cfg.canReadLines(fName, fInfo)
+}
+
+// Pipes, compressed files, and files in archives cannot be read one line at a time,
+// they are always read into memory.
+func (cfg *DiffConfig) canReadLines(fName string, fInfo os.FileInfo) bool {
+	if isStream(fName, fInfo) {
+		return false
+	}
+	if _, _, ok := cfg.archiveFile(fName); ok {
+		return false
+	}
+	return findDecompressor(readMagic(fName)) == nil
+}
+
+// Compare the content of two files, without reading them into memory
+func sameContent(name1, name2 string, info1, info2 os.FileInfo) (bool, error) {
+
+	if info1.Size() != info2.Size() {
+		return false, nil
+	}
+
+	f1, err := os.Open(name1)
+	if err != nil {
+		return false, err
+	}
+	defer f1.Close()
+
+	f2, err := os.Open(name2)
+	if err != nil {
+		return false, err
+	}
+	defer f2.Close()
+
+	buf1, buf2 := make([]byte, OutputBufSize), make([]byte, OutputBufSize)
+	for {
+		n1, err1 := io.ReadFull(f1, buf1)
+		n2, err2 := io.ReadFull(f2, buf2)
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, err1
+		}
+		if err2 != nil {
+			return false, err2
+		}
+	}
+}
+
+// Open a large file, and call eachLine (if not nil) for the first maxLines lines, or all lines if maxLines is 0.
+// The lines are split at each LF only if lfOnly is set, otherwise at each LF, CRLF or CR.
+// The file is kept open to read the lines displayed.
+func openLargeFile(fName string, fInfo os.FileInfo, maxLines int, lfOnly bool, eachLine func(line []byte)) *LargeFile {
+
+	file := &LargeFile{FileData: FileData{name: fName, info: fInfo}, lfOnly: lfOnly}
+
+	f, err := os.Open(fName)
+	if err != nil {
+		file.errorMsg = err.Error()
+		return file
+	}
+	file.osFile = f
+
+	// binary files are not compared by lines
+	header := make([]byte, BinaryCheckSize)
+	n, _ := io.ReadFull(f, header)
+	if bytes.IndexByte(header[:n], 0) >= 0 {
+		file.isBinary = true
+		return file
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		file.errorMsg = err.Error()
+		return file
+	}
+
+	var pos int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, OutputBufSize), LargeFileMaxLine)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, line := file.splitLine(data, atEOF)
+		if advance > 0 {
+			file.offsets = append(file.offsets, pos)
+			file.eols = append(file.eols, lineEnding(data[len(line):advance]))
+			file.noNewline = advance == len(line)
+			pos += int64(advance)
+		}
+		return advance, line, nil
+	})
+
+	for (maxLines == 0 || len(file.offsets) < maxLines) && scanner.Scan() {
+		if eachLine != nil {
+			eachLine(scanner.Bytes())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.errorMsg = err.Error()
+		return file
+	}
+
+	file.offsets = append(file.offsets, pos)
+	return file
+}
+
+// Number of lines read by openLargeFile()
+func (file *LargeFile) numLines() int {
+	return len(file.offsets) - 1
+}
+
+// Count the lines from offset pos to the end of the file, without keeping them
+func (file *LargeFile) countLines(pos int64) (int, error) {
+	scanner := bufio.NewScanner(io.NewSectionReader(file.osFile, pos, file.info.Size()-pos))
+	scanner.Buffer(make([]byte, OutputBufSize), LargeFileMaxLine)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, line := file.splitLine(data, atEOF)
+		return advance, line, nil
+	})
+	n := 0
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Convert each line read to its id, and note the lines matching -I
+func readLineIds(lineIds *diff.LineIds, opts *diff.Options, ids *[]int, ignore *[]bool) func([]byte) {
+	return func(line []byte) {
+		*ids = append(*ids, lineIds.Id(line))
+		if len(opts.IgnoreMatching) > 0 {
+			*ignore = append(*ignore, opts.IgnoreLine(line))
+		}
+	}
+}
+
+// Find the next line, accepting dos, unix and mac newlines, same as splitLines(), or only LF if file.lfOnly is set.
+// Returns the number of bytes used, including the newline.
+func (file *LargeFile) splitLine(data []byte, atEOF bool) (int, []byte) {
+	if file.lfOnly {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i + 1, data[:i]
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data
+		}
+		return 0, nil
+	}
+
+	for i, b := range data {
+		switch {
+		case b == '\n':
+			return i + 1, data[:i]
+		case b == '\r' && i+1 < len(data) && data[i+1] == '\n':
+			return i + 2, data[:i]
+		case b == '\r' && (i+1 < len(data) || atEOF):
+			return i + 1, data[:i]
+		case b == '\r':
+			// need more data, the next byte may be '\n'
+			return 0, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data
+	}
+	return 0, nil
+}
+
+// Open a file that only exists on one side (0 or 1), and split it into lines.
+// Returns the lines and the number of lines: only the first lines of large files are read, for the preview.
+func openMissingFile(cfg *DiffConfig, side int, fName string, fInfo os.FileInfo) (*FileData, [][]byte, int) {
+
+	if !cfg.isLargeFile(fName, fInfo) {
+		file := openFile(cfg, fName, fInfo)
+		if file.errorMsg != "" {
+			cfg.setExitStatus(ExitTrouble)
+		}
+		file.decode(cfg, side)
+		file.checkBinary()
+		lines := file.splitLines()
+		return file, lines, len(lines)
+	}
+
+	file := openLargeFile(fName, fInfo, NumPreviewLines, false, nil)
+	switch {
+	case file.errorMsg != "":
+		cfg.setExitStatus(ExitTrouble)
+		return &file.FileData, nil, 0
+	case file.isBinary:
+		file.errorMsg = MsgFileIsBinary
+		return &file.FileData, nil, 0
+	}
+
+	n := file.numLines()
+	err := file.readLines(0, n)
+
+	// the other lines are only counted for the diffstat and the html report
+	end := file.offsets[n]
+	if err == nil && end < fInfo.Size() && cfg.needLineCount() {
+		var more int
+		more, err = file.countLines(end)
+		n += more
+	}
+	if err != nil {
+		cfg.setExitStatus(ExitTrouble)
+		file.errorMsg = err.Error()
+		return &file.FileData, nil, 0
+	}
+	return &file.FileData, file.lines, n
+}
+
+// The number of lines of a missing file is shown in the diffstat and the html report
+func (cfg *DiffConfig) needLineCount() bool {
+	return cfg.stats != nil || cfg.outputFormat == FormatHtml
+}
+
+// Read the lines from start to end (exclusive) into file.lines, replacing the lines read before
+func (file *LargeFile) readLines(start, end int) error {
+
+	file.lines, file.first = make([][]byte, end-start), start
+	if start >= end {
+		return nil
+	}
+
+	first := file.offsets[start]
+	buf := make([]byte, file.offsets[end]-first)
+	if _, err := file.osFile.ReadAt(buf, first); err != nil && err != io.EOF {
+		return err
+	}
+
+	for i := start; i < end; i++ {
+		line := buf[file.offsets[i]-first : file.offsets[i+1]-first]
+		if i < file.numLines()-1 || !file.noNewline {
+			line = bytes.TrimSuffix(line, []byte{'\n'})
+			if !file.lfOnly {
+				line = bytes.TrimSuffix(line, []byte{'\r'})
+			}
+		}
+		file.lines[i-start] = line
+	}
+	return nil
+}
+
+// Release the lines read by readLines()
+func (file *LargeFile) releaseLines() {
+	file.lines = nil
+}
+
+// Read the lines of each group of changes before they are output, and release them afterwards.
+// The changer only holds the lines of the group of changes.
+func largeFileChanger(cfg *DiffConfig, file1, file2 *LargeFile) func(diff.DiffChanger) diff.DiffChanger {
+	return func(chg diff.DiffChanger) diff.DiffChanger {
+		data := chg.(changerData).data()
+		return diff.DiffChangerFunc(func(ops []diff.DiffOp) {
+			first, last := ops[0], ops[len(ops)-1]
+			for _, err := range []error{file1.readLines(first.Start1, last.End1), file2.readLines(first.Start2, last.End2)} {
+				if err != nil {
+					cfg.setExitStatus(ExitTrouble)
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				}
+			}
+			data.file1, data.first1 = file1.lines, file1.first
+			data.file2, data.first2 = file2.lines, file2.first
+
+			// the last line of the group is the last line of the file that does not end with a newline
+			data.noNewline1 = file1.noNewline && last.End1 == file1.numLines()
+			data.noNewline2 = file2.noNewline && last.End2 == file2.numLines()
+
+			chg.DiffLines(ops)
+			file1.releaseLines()
+			file2.releaseLines()
+			data.file1, data.file2 = nil, nil
+		})
+	}
+}
+
+// compare 2 files larger than -max-size, without reading them into memory
+func diffLargeFile(cfg *DiffConfig, filename1, filename2 string, fInfo1, fInfo2 os.FileInfo) {
+
+	same, err := sameContent(filename1, filename2, fInfo1, fInfo2)
+	if err != nil {
+		cfg.setExitStatus(ExitTrouble)
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, err.Error(), err.Error(), true)
+		return
+	} else if same {
+		if cfg.showIdenticalFiles {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
+		}
+		return
+	}
+
+	if cfg.brief && !cfg.ignoreChanges() {
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileDiffers, MsgFileDiffers, false)
+		return
+	}
+
+	lineIds := diff.NewLineIds(&cfg.cmpOptions)
+	var ids1, ids2 []int
+	var ignore1, ignore2 []bool
+	file1 := openLargeFile(filename1, fInfo1, 0, cfg.compareRawLines(), readLineIds(lineIds, &cfg.cmpOptions, &ids1, &ignore1))
+	defer file1.closeFile()
+	file2 := openLargeFile(filename2, fInfo2, 0, cfg.compareRawLines(), readLineIds(lineIds, &cfg.cmpOptions, &ids2, &ignore2))
+	defer file2.closeFile()
+
+	switch {
+	case file1.errorMsg != "" || file2.errorMsg != "":
+		cfg.setExitStatus(ExitTrouble)
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, file1.errorMsg, file2.errorMsg, true)
+		return
+
+	case file1.isBinary || file2.isBinary:
+		msg1, msg2 := MsgFileDiffers, MsgFileDiffers
+		if file1.isBinary {
+			msg1 = MsgBinFileDiffers
+		}
+		if file2.isBinary {
+			msg2 = MsgBinFileDiffers
+		}
+		if cfg.brief {
+			msg1, msg2 = MsgFileDiffers, MsgFileDiffers
+		}
+		outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, msg1, msg2, !cfg.brief)
+		return
+	}
+
+	splitLastLines(&file1.FileData, &file2.FileData, ids1, ids2)
+	info1, info2 := diff.CompareIds(ids1, ids2, &cfg.cmpOptions)
+	info1.Ignore, info2.Ignore = ignore1, ignore2
+	diff.IgnoreChanges(info1, info2)
+
+	if cfg.brief {
+		if countChanges(info1) > 0 || countChanges(info2) > 0 || lineEndingsNote(cfg, &file1.FileData, &file2.FileData) != "" {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileDiffers, MsgFileDiffers, false)
+		} else if cfg.showIdenticalFiles {
+			outputDiffMessage(cfg, filename1, filename2, fInfo1, fInfo2, MsgFileIdentical, MsgFileIdentical, false)
+		}
+		return
+	}
+
+	outputFileChanges(cfg, &file1.FileData, &file2.FileData, nil, nil, info1, info2, largeFileChanger(cfg, file1, file2))
+}
@@ -0,0 +1,72 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Files larger than -max-size must give the same output as files read into memory
+func TestLargeFile(t *testing.T) {
+	var lines1, lines2 []string
+	for i := 0; i < 200; i++ {
+		line := fmt.Sprintf("line %d", i)
+		lines1 = append(lines1, line)
+		switch {
+		case i%50 == 10:
+			lines2 = append(lines2, "changed "+line)
+		case i%70 == 20:
+			lines2 = append(lines2, line, "added")
+		case i == 100:
+			// removed
+		default:
+			lines2 = append(lines2, line)
+		}
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"file1":   strings.Join(lines1, "\n") + "\n",
+		"file2":   strings.Join(lines2, "\n"),
+		"a/large": strings.Join(lines1, "\n") + "\n",
+		"a/same":  "x\n",
+		"b/same":  "x\n",
+	})
+
+	for _, args := range [][]string{
+		{"-n", "file1", "file2"},
+		{"-n", "-u", "file1", "file2"},
+		{"-n", "-u", "-c", "0", "file1", "file2"},
+		{"-n", "-y", "-width", "60", "file1", "file2"},
+		{"-n", "-granularity", "word", "file1", "file2"},
+		{"-format", "json", "file1", "file2"},
+		{"-format", "json", "file2", "file1"},
+		{"-n", "-stat", "a", "b"},
+		{"-n", "-stat", "b", "a"},
+		{"-n", "a", "b"},
+	} {
+		want, _, wantStatus := runGodiff(t, dir, "", args...)
+		if wantStatus != 1 {
+			t.Fatalf("%v: exit status %d, want 1", args, wantStatus)
+		}
+		got, _, status := runGodiff(t, dir, "", append([]string{"-max-size", "1"}, args...)...)
+		if got != want || status != wantStatus {
+			t.Errorf("%v: got %q, exit status %d, want %q, exit status %d", args, got, status, want, wantStatus)
+		}
+	}
+}
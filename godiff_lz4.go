@@ -0,0 +1,213 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// lz4 frame format, see https://github.com/lz4/lz4/blob/dev/doc/lz4_Frame_format.md
+const (
+	lz4Magic          = 0x184d2204
+	lz4WindowSize     = 64 << 10
+	lz4FlagBlockCheck = 0x10
+	lz4FlagSize       = 0x08
+	lz4FlagCheck      = 0x04
+	lz4FlagDictId     = 0x01
+)
+
+var errLz4Corrupt = errors.New("lz4: corrupt input")
+
+// Lz4Reader decompress lz4 frames. Checksums are skipped, not verified.
+type Lz4Reader struct {
+	r         *bufio.Reader
+	flags     byte
+	inFrame   bool
+	block     []byte // compressed block
+	out       []byte // last 64K of the previous blocks, followed by the current block
+	outPos    int    // data of the current block not read yet
+	lastError error
+}
+
+func newLz4Reader(r io.Reader) (io.Reader, error) {
+	z := &Lz4Reader{r: bufio.NewReader(r)}
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Read the frame header, returns io.EOF if there are no more frames
+func (z *Lz4Reader) readHeader() error {
+
+	var header [6]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != lz4Magic || header[4]>>6 != 1 {
+		return errLz4Corrupt
+	}
+	z.flags = header[4]
+
+	// content size, dictionary id, and header checksum (the BD byte has already been read)
+	skip := 0
+	if z.flags&lz4FlagSize != 0 {
+		skip += 8
+	}
+	if z.flags&lz4FlagDictId != 0 {
+		skip += 4
+	}
+	if _, err := z.r.Discard(skip + 1); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	z.inFrame = true
+	return nil
+}
+
+func (z *Lz4Reader) Read(p []byte) (int, error) {
+	for z.outPos == len(z.out) {
+		if z.lastError != nil {
+			return 0, z.lastError
+		}
+		z.lastError = z.readBlock()
+	}
+	n := copy(p, z.out[z.outPos:])
+	z.outPos += n
+	return n, nil
+}
+
+// Decompress the next block, or start the next frame
+func (z *Lz4Reader) readBlock() error {
+
+	if !z.inFrame {
+		err := z.readHeader()
+		if err == io.ErrUnexpectedEOF {
+			return errLz4Corrupt
+		}
+		return err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(z.r, size[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+
+	// end of frame, followed by the content checksum
+	if n == 0 {
+		z.inFrame = false
+		if z.flags&lz4FlagCheck != 0 {
+			if _, err := z.r.Discard(4); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+		}
+		return nil
+	}
+
+	uncompressed := n&0x80000000 != 0
+	n &= 0x7fffffff
+	if n > 4<<20 {
+		return errLz4Corrupt
+	}
+	if cap(z.block) < int(n) {
+		z.block = make([]byte, n)
+	}
+	z.block = z.block[:n]
+	if _, err := io.ReadFull(z.r, z.block); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if z.flags&lz4FlagBlockCheck != 0 {
+		if _, err := z.r.Discard(4); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+
+	// keep the last 64K, later blocks can refer to it
+	if len(z.out) > lz4WindowSize {
+		z.out = append(z.out[:0], z.out[len(z.out)-lz4WindowSize:]...)
+	}
+	z.outPos = len(z.out)
+
+	if uncompressed {
+		z.out = append(z.out, z.block...)
+		return nil
+	}
+
+	out, err := lz4DecodeBlock(z.out, z.block)
+	z.out = out
+	return err
+}
+
+// Decompress a block, appending the result to dst
+func lz4DecodeBlock(dst, src []byte) ([]byte, error) {
+
+	// length of literals or match, with the extra bytes that follow
+	length := func(n int, i int) (int, int, error) {
+		if n == 15 {
+			for {
+				if i >= len(src) {
+					return 0, 0, errLz4Corrupt
+				}
+				b := src[i]
+				i++
+				n += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		return n, i, nil
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		n, i2, err := length(int(token>>4), i)
+		if err != nil || i2+n > len(src) {
+			return dst, errLz4Corrupt
+		}
+		dst = append(dst, src[i2:i2+n]...)
+		i = i2 + n
+
+		// the last sequence only has literals
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return dst, errLz4Corrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+
+		n, i, err = length(int(token&15), i)
+		if err != nil || offset == 0 || offset > len(dst) {
+			return dst, errLz4Corrupt
+		}
+
+		// the match can overlap the bytes being copied
+		start := len(dst) - offset
+		for k := 0; k < n+4; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,408 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"time"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// command line arguments for the merge sub-command
+var (
+	flagMergeOutput  string = ""
+	flagMergeMaxSize string = "100M"
+)
+
+// Files in a three-way comparison, in the order of the command line: base, mine, theirs
+type Merge3Files struct {
+	names     [3]string
+	infos     [3]os.FileInfo
+	lines     [3][][]byte
+	eols      [3][]byte
+	noNewline [3]bool
+	data      [3]*FileData // the lines point into the file data, which stays open until close()
+}
+
+// Read in the base, mine and theirs files, close() them once done.
+// Errors are reported on stderr, these files cannot be compared.
+func readMerge3Files(cfg *DiffConfig, names []string) (*Merge3Files, bool) {
+
+	files := &Merge3Files{}
+	ok := true
+
+	for i, name := range names {
+		files.names[i] = name
+		info, err := statFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			ok = false
+			continue
+		}
+		if info.IsDir() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, MsgThisIsDir)
+			ok = false
+			continue
+		}
+		files.infos[i] = info
+
+		fData := openFile(cfg, name, info)
+		if fData.errorMsg == "" && len(fData.data) > 0 {
+			fData.checkBinary()
+		}
+		if fData.errorMsg == "" {
+			files.lines[i] = fData.splitLines()
+			files.eols[i] = fData.eols
+			files.noNewline[i] = fData.noNewline
+		}
+		if fData.errorMsg != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, fData.errorMsg)
+			ok = false
+		}
+		files.data[i] = fData
+	}
+
+	return files, ok
+}
+
+// Release the data of the files, their lines can no longer be used
+func (files *Merge3Files) close() {
+	for _, fData := range files.data {
+		if fData != nil {
+			fData.closeFile()
+		}
+	}
+}
+
+// Three-way comparison of base, mine and theirs, output as html or text.
+// Returns false if the files cannot be compared, the exit status is ExitDifferent if mine or theirs has changes.
+func diff3Files(cfg *DiffConfig, names []string) bool {
+
+	files, ok := readMerge3Files(cfg, names)
+	defer files.close()
+	if !ok {
+		return false
+	}
+
+	chunks := diff.Diff3(files.lines[0], files.lines[1], files.lines[2], &cfg.cmpOptions)
+	for _, c := range chunks {
+		if c.Kind != diff.Merge3Same {
+			cfg.setExitStatus(ExitDifferent)
+		}
+	}
+
+	if cfg.outputFormat == FormatText {
+		diff3Text(files, chunks)
+	} else {
+		diff3Html(cfg, files, chunks)
+	}
+	return true
+}
+
+// File numbers in each group of a diff3 text chunk, files with identical lines are grouped together
+var diff3TextGroups = map[int][][]int{
+	diff.Merge3Mine:     {{0, 2}, {1}},
+	diff.Merge3Theirs:   {{0, 1}, {2}},
+	diff.Merge3Both:     {{0}, {1, 2}},
+	diff.Merge3Conflict: {{0}, {1}, {2}},
+}
+
+// Separator line of a diff3 text chunk
+var diff3TextSeparator = map[int]string{
+	diff.Merge3Mine:     "====2",
+	diff.Merge3Theirs:   "====3",
+	diff.Merge3Both:     "====1",
+	diff.Merge3Conflict: "====",
+}
+
+// Output changes in the same format as gnu diff3
+func diff3Text(files *Merge3Files, chunks []diff.Merge3Chunk) {
+
+	for _, c := range chunks {
+		if c.Kind == diff.Merge3Same {
+			continue
+		}
+
+		out.WriteString(diff3TextSeparator[c.Kind])
+		out.WriteByte('\n')
+
+		starts := [3]int{c.BaseStart, c.MineStart, c.TheirsStart}
+		ends := [3]int{c.BaseEnd, c.MineEnd, c.TheirsEnd}
+
+		for _, group := range diff3TextGroups[c.Kind] {
+			for _, n := range group {
+				start, end := starts[n], ends[n]
+				switch end - start {
+				case 0:
+					fmt.Fprintf(out, "%d:%da\n", n+1, start)
+				case 1:
+					fmt.Fprintf(out, "%d:%dc\n", n+1, end)
+				default:
+					fmt.Fprintf(out, "%d:%d,%dc\n", n+1, start+1, end)
+				}
+			}
+			n := group[len(group)-1]
+			writeTextLines("  ", files.lines[n], starts[n], ends[n], files.noNewline[n])
+		}
+	}
+}
+
+// Html class for the lines of a three-way chunk, in each of the base, mine and theirs columns
+func diff3HtmlClass(kind, n int) string {
+	switch {
+	case n == 0 || kind == diff.Merge3Same:
+		return "nop"
+	case kind == diff.Merge3Conflict:
+		return "cfl"
+	case kind == diff.Merge3Both,
+		kind == diff.Merge3Mine && n == 1,
+		kind == diff.Merge3Theirs && n == 2:
+		return "upd"
+	}
+	return "nop"
+}
+
+// Output changes in a three columns html table: base, mine and theirs
+func diff3Html(cfg *DiffConfig, files *Merge3Files, chunks []diff.Merge3Chunk) {
+
+	out.WriteString(HtmlHeader)
+	fmt.Fprintf(out, "<title>Compare %s, %s and %s</title>\n", html.EscapeString(files.names[0]), html.EscapeString(files.names[1]), html.EscapeString(files.names[2]))
+	out.WriteString(HtmlCss)
+	out.WriteString("</head><body>\n")
+	fmt.Fprintf(out, "<p>Compare <strong>%s</strong> with <strong>%s</strong> and <strong>%s</strong></p>\n", html.EscapeString(files.names[0]), html.EscapeString(files.names[1]), html.EscapeString(files.names[2]))
+
+	linenoWidth := len(fmt.Sprintf("%d", maxInt(len(files.lines[0]), maxInt(len(files.lines[1]), len(files.lines[2])))))
+	conflicts, changed := 0, false
+	for _, c := range chunks {
+		if c.Kind == diff.Merge3Conflict {
+			conflicts++
+		}
+		changed = changed || c.Kind != diff.Merge3Same
+	}
+
+	if changed || cfg.showIdenticalFiles {
+		out.WriteString("<table class=\"tab\"><tr>")
+		for i := range files.names {
+			out.WriteString("<td class=\"tth\"><span class=\"hdr\">")
+			out.WriteString(html.EscapeString(files.names[i]))
+			out.WriteString("</span>")
+			if files.infos[i] != nil {
+				fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", files.infos[i].Size(), files.infos[i].ModTime().Format(time.RFC1123))
+			}
+			out.WriteString("</td>")
+		}
+		out.WriteString("</tr>\n")
+	}
+
+	if !changed {
+		if cfg.showIdenticalFiles {
+			out.WriteString("<tr>")
+			for range files.names {
+				fmt.Fprintf(out, "<td class=\"ttd\"><span class=\"msg\">%s</span></td>", html.EscapeString(MsgFileIdentical))
+			}
+			out.WriteString("</tr>\n</table><br>\n")
+		}
+	} else {
+		var bufs [3]bytes.Buffer
+
+		// write out one table row of changes
+		flush := func() {
+			if bufs[0].Len() == 0 && bufs[1].Len() == 0 && bufs[2].Len() == 0 {
+				return
+			}
+			out.WriteString("<tr>")
+			for i := range bufs {
+				out.WriteString("<td class=\"ttd\">")
+				out.Write(bufs[i].Bytes())
+				out.WriteString("</td>")
+				bufs[i].Reset()
+			}
+			out.WriteString("</tr>\n")
+		}
+
+		// add lines[start:end] from each file, offset from the start of the chunk
+		addLines := func(c *diff.Merge3Chunk, start, end int) {
+			starts := [3]int{c.BaseStart, c.MineStart, c.TheirsStart}
+			for i := range bufs {
+				writeHtmlLines(&bufs[i], "nop", files.lines[i][starts[i]+start:starts[i]+end], starts[i]+start, linenoWidth)
+			}
+		}
+
+		context := cfg.cmpOptions.ContextLines
+		for k := range chunks {
+			c := &chunks[k]
+
+			if c.Kind == diff.Merge3Same {
+				n := c.BaseEnd - c.BaseStart
+				first, last := k == 0, k == len(chunks)-1
+				switch {
+				case first && last:
+				case first:
+					addLines(c, maxInt(0, n-context), n)
+				case last:
+					addLines(c, 0, minInt(n, context))
+					flush()
+				case n > context*2:
+					addLines(c, 0, context)
+					flush()
+					addLines(c, n-context, n)
+				default:
+					addLines(c, 0, n)
+				}
+				continue
+			}
+
+			starts := [3]int{c.BaseStart, c.MineStart, c.TheirsStart}
+			ends := [3]int{c.BaseEnd, c.MineEnd, c.TheirsEnd}
+			height := maxInt(ends[0]-starts[0], maxInt(ends[1]-starts[1], ends[2]-starts[2]))
+			for i := range bufs {
+				writeHtmlLines(&bufs[i], diff3HtmlClass(c.Kind, i), files.lines[i][starts[i]:ends[i]], starts[i], linenoWidth)
+				writeHtmlBlanks(&bufs[i], height-(ends[i]-starts[i]))
+			}
+		}
+		flush()
+
+		out.WriteString("</table>\n")
+		fmt.Fprintf(out, "<p class=\"msg\">%s</p><br>\n", html.EscapeString(fmt.Sprintf(MsgMergeConflicts, conflicts)))
+	}
+
+	fmt.Fprintf(out, "Generated on %s<br>", time.Now().Format(time.RFC1123))
+	out.WriteString("</body></html>\n")
+}
+
+func mergeUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "Merge the changes from base to mine, and from base to theirs, into a single file\n\n")
+		fmt.Fprint(os.Stderr, "usage: godiff merge <options> base mine theirs\n")
+		fmt.Fprint(os.Stderr, "\n<options>\n")
+		fs.PrintDefaults()
+	}
+}
+
+// godiff merge sub-command. Returns the exit code:
+// 0 merged without conflicts, 1 some conflicts, 2 on trouble.
+func mergeMain(args []string) int {
+
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = mergeUsage(fs)
+	fs.StringVar(&flagMergeOutput, "o", flagMergeOutput, "Write the merged file to this file instead of stdout")
+	fs.StringVar(&flagMergeMaxSize, "max-size", flagMergeMaxSize, "Refuse to merge files larger than this (in bytes, or with a K, M or G suffix)")
+	fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		fmt.Fprint(os.Stderr, "Three files required: base mine theirs\n")
+		fs.Usage()
+		return 2
+	}
+
+	maxSize, err := parseSize(flagMergeMaxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid max-size: %s\n", flagMergeMaxSize)
+		fs.Usage()
+		return 2
+	}
+
+	cfg := &DiffConfig{maxSize: maxSize}
+	files, ok := readMerge3Files(cfg, fs.Args())
+	defer files.close()
+	if !ok {
+		return 2
+	}
+
+	// lines are merged only if they are exactly the same
+	chunks := diff.Diff3(files.lines[0], files.lines[1], files.lines[2], &diff.Options{})
+	merged, conflicts := mergeLines(files, chunks)
+
+	if flagMergeOutput != "" {
+		if err := os.WriteFile(flagMergeOutput, merged, 0666); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+	} else {
+		out.Write(merged)
+		out.Flush()
+	}
+
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, MsgMergeConflicts+"\n", conflicts)
+		return 1
+	}
+	return 0
+}
+
+// line ending written after each kind of line, the last line without a newline gets one
+// so that a marker can follow it, and it is removed at the end of the merged file.
+var eolText = [...]string{EolNone: "\n", EolLF: "\n", EolCRLF: "\r\n", EolCR: "\r"}
+
+// Merge the three files, conflicting changes are marked in the same way as diff3 -m.
+// Returns the merged data and the number of conflicts.
+func mergeLines(files *Merge3Files, chunks []diff.Merge3Chunk) ([]byte, int) {
+
+	var buf bytes.Buffer
+	lastNoNewline := false
+	conflicts := 0
+
+	// each line keeps its line ending, the markers end with a newline same as diff3 -m
+	writeLines := func(n, start, end int) {
+		for i, line := range files.lines[n][start:end] {
+			buf.Write(line)
+			buf.WriteString(eolText[files.eols[n][start+i]])
+		}
+		if start < end {
+			lastNoNewline = files.noNewline[n] && end == len(files.lines[n])
+		}
+	}
+
+	writeMarker := func(marker string, n int) {
+		buf.WriteString(marker)
+		if n >= 0 {
+			buf.WriteByte(' ')
+			buf.WriteString(files.names[n])
+		}
+		buf.WriteByte('\n')
+		lastNoNewline = false
+	}
+
+	for _, c := range chunks {
+		switch c.Kind {
+		case diff.Merge3Theirs:
+			writeLines(2, c.TheirsStart, c.TheirsEnd)
+		case diff.Merge3Conflict:
+			conflicts++
+			writeMarker("<<<<<<<", 1)
+			writeLines(1, c.MineStart, c.MineEnd)
+			writeMarker("|||||||", 0)
+			writeLines(0, c.BaseStart, c.BaseEnd)
+			writeMarker("=======", -1)
+			writeLines(2, c.TheirsStart, c.TheirsEnd)
+			writeMarker(">>>>>>>", 2)
+		default:
+			writeLines(1, c.MineStart, c.MineEnd)
+		}
+	}
+
+	merged := buf.Bytes()
+	if lastNoNewline {
+		merged = merged[:len(merged)-1]
+	}
+	return merged, conflicts
+}
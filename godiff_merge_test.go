@@ -0,0 +1,113 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	// larger than MmapThreshold, the files are mapped
+	var large strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&large, "%d\n", i)
+	}
+	largeBase := large.String()
+
+	tests := []struct {
+		name               string
+		base, mine, theirs string
+		args               []string
+		want               string
+		status             int
+	}{
+		{
+			name: "no conflicts",
+			base: "a\nb\nc\nd\ne\n", mine: "A\nb\nc\nd\ne\n", theirs: "a\nb\nc\nd\nE\n",
+			want: "A\nb\nc\nd\nE\n",
+		},
+		{
+			name: "same change",
+			base: "a\nb\n", mine: "a\nB\n", theirs: "a\nB\n",
+			want: "a\nB\n",
+		},
+		{
+			name: "conflict",
+			base: "a\nb\n", mine: "a\nmine\n", theirs: "a\ntheirs\n",
+			want:   "a\n<<<<<<< mine\nmine\n||||||| base\nb\n=======\ntheirs\n>>>>>>> theirs\n",
+			status: 1,
+		},
+		{
+			name: "no newline",
+			base: "a\nb", mine: "A\nb", theirs: "a\nb",
+			want: "A\nb",
+		},
+		{
+			name: "line endings",
+			base: "a\r\nb\r\nc\n", mine: "A\r\nb\r\nc\n", theirs: "a\r\nb\r\nC\r\n",
+			want: "A\r\nb\r\nC\r\n",
+		},
+		{
+			name: "conflict with line endings",
+			base: "a\r\nb\r\n", mine: "a\r\nmine\r\n", theirs: "a\r\ntheirs\r\n",
+			want:   "a\r\n<<<<<<< mine\nmine\r\n||||||| base\nb\r\n=======\ntheirs\r\n>>>>>>> theirs\n",
+			status: 1,
+		},
+		{
+			name:   "large files",
+			base:   largeBase,
+			mine:   strings.Replace(largeBase, "\n10\n", "\nten\n", 1),
+			theirs: strings.Replace(largeBase, "\n4000\n", "\nx\n", 1),
+			want:   strings.Replace(strings.Replace(largeBase, "\n10\n", "\nten\n", 1), "\n4000\n", "\nx\n", 1),
+		},
+		{
+			name: "binary",
+			base: "a\n", mine: "a\x00\n", theirs: "a\n",
+			status: 2,
+		},
+		{
+			name: "too big",
+			base: "a\n", mine: "a\nb\n", theirs: "a\n",
+			args:   []string{"-max-size", "3"},
+			status: 2,
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"base": test.base, "mine": test.mine, "theirs": test.theirs})
+
+		output, _, status := runGodiff(t, dir, "", append(append([]string{"merge"}, test.args...), "base", "mine", "theirs")...)
+		if status != test.status {
+			t.Errorf("%s: exit status %d, want %d", test.name, status, test.status)
+		}
+		if output != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, output, test.want)
+		}
+
+		// same result written to a file
+		if test.status < 2 {
+			runGodiff(t, dir, "", "merge", "-o", "merged", "base", "mine", "theirs")
+			if got := readFile(t, filepath.Join(dir, "merged")); got != test.want {
+				t.Errorf("%s: -o wrote %q, want %q", test.name, got, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IndexPageName name of the index page written to the -o-dir directory
+const IndexPageName = "index.html"
+
+// ReportPage a pair of files in the html report, shown in its own section (or page with -o-dir)
+type ReportPage struct {
+	name1, name2 string
+	anchor       string // id of the section for the files
+	page         string // file name of the page, relative to the output directory. Empty if not written to a page of its own
+	message      string // message reported for the files, empty if they differ
+	isError      bool
+	added        int // number of lines added
+	removed      int // number of lines removed
+}
+
+// HtmlReport sections of the html output, listed in the summary table.
+// With -o-dir, each section is written to a page of its own.
+type HtmlReport struct {
+	dir     string // output directory for -o-dir, empty when writing a single html file
+	pages   []*ReportPage
+	file    *os.File      // file of the current page
+	mainOut *bufio.Writer // output of the index page, restored at the end of each page
+}
+
+// Open the -o output file, or the index page in the -o-dir directory.
+// Returns nil when the output is written to stdout.
+func openOutput() (*os.File, error) {
+	if flagOutputDir != "" {
+		if err := os.MkdirAll(flagOutputDir, 0777); err != nil {
+			return nil, err
+		}
+		flagOutputFile = filepath.Join(flagOutputDir, IndexPageName)
+	}
+	if flagOutputFile == "" {
+		return nil, nil
+	}
+	return openOutputFile(flagOutputFile)
+}
+
+// Create the output file, and redirect the output to it
+func openOutputFile(name string) (*os.File, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	out = bufio.NewWriterSize(f, OutputBufSize)
+	return f, nil
+}
+
+// Write the html page header, up to the start of the body
+func writeHtmlHeader(name1, name2 string) {
+	out.WriteString(HtmlHeader)
+	fmt.Fprintf(out, "<title>Compare %s vs %s</title>\n", html.EscapeString(name1), html.EscapeString(name2))
+	out.WriteString(HtmlCss)
+	out.WriteString("</head><body>\n")
+	out.WriteString(HtmlNavigation)
+	fmt.Fprintf(out, "<p>Compare <strong>%s</strong> vs <strong>%s</strong></p>\n", html.EscapeString(name1), html.EscapeString(name2))
+	out.WriteString("<div id=\"top\"></div>\n")
+}
+
+// Write the html page footer
+func writeHtmlFooter() {
+	fmt.Fprintf(out, "Generated on %s<br>", time.Now().Format(time.RFC1123))
+	out.WriteString(HtmlLegend)
+	out.WriteString(HtmlScript)
+	out.WriteString("</body></html>\n")
+}
+
+// File name of the page for a pair of files, numbered to keep each page unique
+func (r *HtmlReport) pageName(name string) string {
+	base := strings.Map(func(c rune) rune {
+		if c == '.' || c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return c
+		}
+		return '_'
+	}, filepath.Base(name))
+	return fmt.Sprintf("%05d-%s.html", len(r.pages), base)
+}
+
+// Start the section for a pair of files, in a page of its own with -o-dir.
+// Must be called with the output lock held.
+func (cfg *DiffConfig) beginPage(outFmt *OutputFormat) {
+
+	r := cfg.report
+	if r == nil {
+		return
+	}
+
+	// the file that exists, for the section title and page name
+	name := outFmt.name2
+	if outFmt.fileInfo2 == nil {
+		name = outFmt.name1
+	}
+
+	p := &ReportPage{name1: outFmt.name1, name2: outFmt.name2, message: outFmt.message, isError: outFmt.isError}
+	r.pages = append(r.pages, p)
+	p.anchor = fmt.Sprintf("f%05d", len(r.pages))
+	outFmt.page = p
+
+	if r.dir != "" {
+		page := r.pageName(name)
+		f, err := os.Create(filepath.Join(r.dir, page))
+		if err != nil {
+			// the changes are written into the index page instead
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		} else {
+			p.page = page
+			r.file = f
+			r.mainOut = out
+			out = bufio.NewWriterSize(f, OutputBufSize)
+
+			writeHtmlHeader(outFmt.name1, outFmt.name2)
+			fmt.Fprintf(out, "<p><a href=\"%s\">Index</a></p>\n", IndexPageName)
+		}
+	}
+
+	fmt.Fprintf(out, "<details open class=\"file\" id=\"%s\"><summary><span class=\"hdr\">%s</span> <span class=\"%s\">%s</span></summary>\n",
+		p.anchor, html.EscapeString(name), p.statusClass(), html.EscapeString(p.status()))
+}
+
+// Finish the section for a pair of files, and restore the output to the index page
+func (cfg *DiffConfig) endPage() {
+
+	r := cfg.report
+	if r == nil {
+		return
+	}
+
+	out.WriteString("</details><br>\n")
+	if r.file == nil {
+		return
+	}
+
+	writeHtmlFooter()
+	out.Flush()
+	if err := r.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+	}
+
+	out = r.mainOut
+	r.file, r.mainOut = nil, nil
+}
+
+// Status of the files, as shown in the summary
+func (p *ReportPage) status() string {
+	if p.message == "" {
+		return MsgFileDiffers
+	}
+	return p.message
+}
+
+// Html class for the status of the files
+func (p *ReportPage) statusClass() string {
+	if p.isError {
+		return "err"
+	}
+	return "msg"
+}
+
+// Write the summary table of all pairs of files, with links to their sections or pages
+func (r *HtmlReport) writeSummary() {
+
+	if len(r.pages) == 0 {
+		return
+	}
+
+	out.WriteString("<div id=\"summary\"><table class=\"tab\"><tr><td class=\"tth\"><span class=\"hdr\">File</span></td><td class=\"tth\"><span class=\"hdr\">Status</span></td>")
+	out.WriteString("<td class=\"tth\"><span class=\"hdr\">Added</span></td><td class=\"tth\"><span class=\"hdr\">Removed</span></td></tr>\n")
+
+	added, removed := 0, 0
+	for _, p := range r.pages {
+		link := "#" + p.anchor
+		if p.page != "" {
+			link = (&url.URL{Path: p.page}).String()
+		}
+		fmt.Fprintf(out, "<tr><td class=\"ttd\"><span class=\"hdr\"><a href=\"%s\">%s</a>", html.EscapeString(link), html.EscapeString(p.name1))
+		if p.name2 != p.name1 {
+			fmt.Fprintf(out, "<br>%s", html.EscapeString(p.name2))
+		}
+		fmt.Fprintf(out, "</span></td><td class=\"ttd\"><span class=\"%s\">%s</span></td>", p.statusClass(), html.EscapeString(p.status()))
+		fmt.Fprintf(out, "<td class=\"ttd\"><span class=\"add\">+%d</span></td><td class=\"ttd\"><span class=\"del\">-%d</span></td></tr>\n", p.added, p.removed)
+		added += p.added
+		removed += p.removed
+	}
+
+	fmt.Fprintf(out, "<tr><td class=\"tth\"><span class=\"hdr\">%d files</span></td><td class=\"tth\"></td>", len(r.pages))
+	fmt.Fprintf(out, "<td class=\"tth\"><span class=\"hdr\">+%d</span></td><td class=\"tth\"><span class=\"hdr\">-%d</span></td></tr>\n", added, removed)
+	out.WriteString("</table><br></div>\n")
+}
@@ -0,0 +1,112 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"file1":  "a\nb\nc\n",
+		"file2":  "a\nB\nc\n",
+		"base":   "a\nb\nc\n",
+		"mine":   "A\nb\nc\n",
+		"theirs": "a\nb\nC\n",
+	})
+
+	for _, args := range [][]string{
+		{"-n", "file1", "file2"},
+		{"-n", "-color", "auto", "file1", "file2"},
+		{"-format", "json", "file1", "file2"},
+		{"-3", "-n", "base", "mine", "theirs"},
+	} {
+		want, _, wantStatus := runGodiff(t, dir, "", args...)
+
+		output, _, status := runGodiff(t, dir, "", append([]string{"-o", "out.txt"}, args...)...)
+		if output != "" {
+			t.Errorf("%v: output %q written to stdout", args, output)
+		}
+		if status != wantStatus {
+			t.Errorf("%v: exit status %d, want %d", args, status, wantStatus)
+		}
+		got := readFile(t, filepath.Join(dir, "out.txt"))
+		if got != want {
+			t.Errorf("%v: got %q, want %q", args, got, want)
+		}
+		if strings.Contains(got, "\x1b[") {
+			t.Errorf("%v: colours in the output file", args)
+		}
+	}
+
+	// colours only if asked for
+	runGodiff(t, dir, "", "-o", "out.txt", "-n", "-color", "always", "file1", "file2")
+	if got := readFile(t, filepath.Join(dir, "out.txt")); !strings.Contains(got, "\x1b[") {
+		t.Errorf("-color always: no colours in %q", got)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/same.txt":    "x\n",
+		"a/changed.txt": "a\nb\n",
+		"a/sub/f.txt":   "1\n",
+		"b/same.txt":    "x\n",
+		"b/changed.txt": "a\nB\n",
+		"b/sub/f.txt":   "2\n",
+	})
+
+	output, _, status := runGodiff(t, dir, "", "-o-dir", "out", "a", "b")
+	if status != 1 {
+		t.Errorf("exit status %d, want 1", status)
+	}
+	if output != "" {
+		t.Errorf("output %q written to stdout", output)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []string
+	for _, e := range entries {
+		pages = append(pages, e.Name())
+	}
+	// a page for each pair of files that differ, and the index
+	if len(pages) != 3 {
+		t.Fatalf("got pages %v, want 2 pages and the index", pages)
+	}
+	index := readFile(t, filepath.Join(dir, "out", "index.html"))
+	for _, page := range pages {
+		if page != "index.html" && !strings.Contains(index, `href="`+page+`"`) {
+			t.Errorf("no link to %s in the index page", page)
+		}
+	}
+
+	// -o-dir is only for html output
+	if _, _, status := runGodiff(t, dir, "", "-n", "-o-dir", "out2", "a", "b"); status != 2 {
+		t.Errorf("-n -o-dir: exit status %d, want 2", status)
+	}
+	if _, _, status := runGodiff(t, dir, "", "-3", "-o-dir", "out2", "a/changed.txt", "b/changed.txt", "a/changed.txt"); status != 2 {
+		t.Errorf("-3 -o-dir: exit status %d, want 2", status)
+	}
+}
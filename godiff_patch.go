@@ -0,0 +1,263 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoToUse/godiff/patch"
+)
+
+// command line arguments for the patch sub-command
+var (
+	flagPatchStrip   int    = 1
+	flagPatchFuzz    int    = patch.DefaultFuzz
+	flagPatchDryRun  bool   = false
+	flagPatchReverse bool   = false
+	flagPatchInput   string = ""
+)
+
+func patchUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "Apply unified diff, as produced by 'godiff -n -u', to files in a directory\n\n")
+		fmt.Fprint(os.Stderr, "usage: godiff patch <options> [dir] < changes.diff\n")
+		fmt.Fprint(os.Stderr, "\n<options>\n")
+		fs.PrintDefaults()
+	}
+}
+
+// godiff patch sub-command. Returns the exit code:
+// 0 all hunks applied, 1 some hunks failed, 2 on trouble.
+func patchMain(args []string) int {
+
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	fs.Usage = patchUsage(fs)
+	fs.IntVar(&flagPatchStrip, "p", flagPatchStrip, "Strip N leading directories from file names in the diff")
+	fs.IntVar(&flagPatchFuzz, "fuzz", flagPatchFuzz, "Ignore up to N context lines at the start and end of a hunk that does not match")
+	fs.BoolVar(&flagPatchDryRun, "dry-run", flagPatchDryRun, "Print the results without changing any files")
+	fs.BoolVar(&flagPatchReverse, "reverse", flagPatchReverse, "Undo the changes, as if the old and new files in the diff are swapped")
+	fs.BoolVar(&flagPatchReverse, "R", flagPatchReverse, "Same as -reverse")
+	fs.StringVar(&flagPatchInput, "i", flagPatchInput, "Read the diff from this file instead of stdin")
+	fs.Parse(args)
+
+	dir := "."
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		dir = fs.Arg(0)
+	default:
+		fmt.Fprint(os.Stderr, "Too many directories\n")
+		fs.Usage()
+		return 2
+	}
+
+	var input io.Reader = os.Stdin
+	if flagPatchInput != "" {
+		f, err := os.Open(flagPatchInput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+		defer f.Close()
+		input = f
+	}
+
+	files, err := patch.Parse(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+	if len(files) == 0 {
+		fmt.Fprint(os.Stderr, "Only garbage was found in the patch input.\n")
+		return 2
+	}
+
+	status := 0
+	for _, fd := range files {
+		if flagPatchReverse {
+			fd = fd.Reverse()
+		}
+		status = maxInt(status, patchFile(dir, fd))
+	}
+	return status
+}
+
+// Apply the changes to a single file, write rejected hunks to a .rej file.
+func patchFile(dir string, fd *patch.FileDiff) int {
+
+	name, create := patchTarget(dir, fd)
+	if name == "" {
+		fmt.Fprintf(os.Stderr, "can't find file to patch: %s\n", fd.NewName)
+		return 1
+	}
+
+	var data []byte
+	perm := os.FileMode(0644)
+	if !create {
+		info, err := os.Stat(name)
+		if err == nil {
+			perm = info.Mode().Perm()
+			data, err = os.ReadFile(name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+	}
+
+	if flagPatchDryRun {
+		fmt.Printf("checking file %s\n", name)
+	} else {
+		fmt.Printf("patching file %s\n", name)
+	}
+
+	result, hunkResults := patch.Apply(data, fd.Hunks, &patch.Options{Fuzz: flagPatchFuzz})
+
+	rejects := &patch.FileDiff{OldName: fd.OldName, NewName: fd.NewName}
+	for i, r := range hunkResults {
+		switch {
+		case !r.Applied:
+			fmt.Printf("Hunk #%d FAILED at %d.\n", i+1, r.Line)
+			rejects.Hunks = append(rejects.Hunks, fd.Hunks[i])
+		case r.Fuzz > 0 && r.Offset != 0:
+			fmt.Printf("Hunk #%d succeeded at %d with fuzz %d (offset %s).\n", i+1, r.Line, r.Fuzz, plural(r.Offset, "line"))
+		case r.Fuzz > 0:
+			fmt.Printf("Hunk #%d succeeded at %d with fuzz %d.\n", i+1, r.Line, r.Fuzz)
+		case r.Offset != 0:
+			fmt.Printf("Hunk #%d succeeded at %d (offset %s).\n", i+1, r.Line, plural(r.Offset, "line"))
+		}
+	}
+
+	if !flagPatchDryRun {
+		var err error
+		switch {
+		case fd.DeletesFile() && len(result) == 0:
+			err = os.Remove(name)
+		case create:
+			if err = os.MkdirAll(filepath.Dir(name), 0777); err == nil {
+				err = os.WriteFile(name, result, perm)
+			}
+		default:
+			err = os.WriteFile(name, result, perm)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 2
+		}
+	}
+
+	if len(rejects.Hunks) == 0 {
+		return 0
+	}
+
+	if flagPatchDryRun {
+		fmt.Printf("%d out of %s FAILED\n", len(rejects.Hunks), plural(len(fd.Hunks), "hunk"))
+		return 1
+	}
+
+	fmt.Printf("%d out of %s FAILED -- saving rejects to file %s.rej\n", len(rejects.Hunks), plural(len(fd.Hunks), "hunk"), name)
+	rej, err := os.Create(name + ".rej")
+	if err == nil {
+		_, err = rejects.WriteTo(rej)
+		rej.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+	return 1
+}
+
+// Find the file to patch, using either the new or old name in the diff header.
+// Returns true if the file does not exist, and is created by the diff.
+func patchTarget(dir string, fd *patch.FileDiff) (string, bool) {
+
+	var names []string
+	var refused string
+	for _, name := range []string{fd.NewName, fd.OldName} {
+		if name == patch.DevNull {
+			names = append(names, "")
+			continue
+		}
+		name = stripPath(name, flagPatchStrip)
+		if !safePath(name) {
+			if name != refused {
+				fmt.Fprintf(os.Stderr, "Ignoring potentially dangerous file name %s\n", name)
+				refused = name
+			}
+			names = append(names, "")
+			continue
+		}
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		fName := filepath.Join(dir, name)
+		if info, err := os.Stat(fName); err == nil && !info.IsDir() {
+			return fName, false
+		}
+	}
+
+	// new file, all hunks must be additions
+	if names[0] == "" {
+		return "", false
+	}
+	for _, h := range fd.Hunks {
+		if h.OldStart != 0 || h.OldLines != 0 {
+			return "", false
+		}
+	}
+	return filepath.Join(dir, names[0]), true
+}
+
+// Check that a file name in the diff header is inside the directory being patched:
+// absolute names and names with .. are refused, same as gnu patch.
+func safePath(name string) bool {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") {
+		return false
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove leading directories from a file name in the diff header, same as patch -p
+func stripPath(name string, strip int) string {
+	parts := strings.Split(name, "/")
+	if strip >= len(parts) {
+		return parts[len(parts)-1]
+	}
+	return strings.Join(parts[strip:], "/")
+}
+
+func plural(n int, word string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
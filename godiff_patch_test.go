@@ -0,0 +1,164 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPatchFile = "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+
+const testPatchDiff = `--- a/f.txt
++++ b/f.txt
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+@@ -8,3 +8,4 @@
+ 8
+ 9
++9.5
+ 10
+`
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		args   []string
+		diff   string
+		want   map[string]string
+		output string
+		status int
+	}{
+		{
+			name:   "apply",
+			files:  map[string]string{"f.txt": testPatchFile},
+			diff:   testPatchDiff,
+			want:   map[string]string{"f.txt": "1\n2\nthree\n4\n5\n6\n7\n8\n9\n9.5\n10\n"},
+			output: "patching file f.txt\n",
+		},
+		{
+			name:   "reverse",
+			files:  map[string]string{"f.txt": "1\n2\nthree\n4\n5\n6\n7\n8\n9\n9.5\n10\n"},
+			args:   []string{"-R"},
+			diff:   testPatchDiff,
+			want:   map[string]string{"f.txt": testPatchFile},
+			output: "patching file f.txt\n",
+		},
+		{
+			name:   "dry run",
+			files:  map[string]string{"f.txt": testPatchFile},
+			args:   []string{"-dry-run"},
+			diff:   testPatchDiff,
+			want:   map[string]string{"f.txt": testPatchFile},
+			output: "checking file f.txt\n",
+		},
+		{
+			name:  "reject",
+			files: map[string]string{"f.txt": strings.Replace(testPatchFile, "3\n", "x\n", 1)},
+			diff:  testPatchDiff,
+			want: map[string]string{
+				"f.txt":     "1\n2\nx\n4\n5\n6\n7\n8\n9\n9.5\n10\n",
+				"f.txt.rej": "--- a/f.txt\n+++ b/f.txt\n@@ -2,3 +2,3 @@\n 2\n-3\n+three\n 4\n",
+			},
+			output: "patching file f.txt\nHunk #1 FAILED at 2.\n1 out of 2 hunks FAILED -- saving rejects to file f.txt.rej\n",
+			status: 1,
+		},
+		{
+			name:   "new file in a new directory",
+			diff:   "--- /dev/null\n+++ b/new/dir/g.txt\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+			want:   map[string]string{"new/dir/g.txt": "a\nb\n"},
+			output: "patching file new/dir/g.txt\n",
+		},
+		{
+			name:   "delete",
+			files:  map[string]string{"g.txt": "a\nb\n"},
+			diff:   "--- a/g.txt\n+++ b/g.txt\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+			want:   map[string]string{"g.txt": "<missing>"},
+			output: "patching file g.txt\n",
+		},
+		{
+			name:   "name outside of the directory",
+			diff:   "--- /dev/null\n+++ b/../g.txt\n@@ -0,0 +1 @@\n+a\n",
+			want:   map[string]string{"../g.txt": "<missing>"},
+			status: 1,
+		},
+		{
+			name:   "absolute name",
+			args:   []string{"-p", "0"},
+			diff:   "--- /dev/null\n+++ /g.txt\n@@ -0,0 +1 @@\n+a\n",
+			want:   map[string]string{"g.txt": "<missing>"},
+			status: 1,
+		},
+		{
+			name:   "garbage",
+			diff:   "not a diff\n",
+			status: 2,
+		},
+	}
+
+	for _, test := range tests {
+		dir := filepath.Join(t.TempDir(), "d")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, dir, test.files)
+
+		output, _, status := runGodiff(t, dir, test.diff, append(append([]string{"patch"}, test.args...), ".")...)
+		if status != test.status {
+			t.Errorf("%s: exit status %d, want %d", test.name, status, test.status)
+		}
+		if filepath.FromSlash(test.output) != output {
+			t.Errorf("%s: got output %q, want %q", test.name, output, test.output)
+		}
+		for name, want := range test.want {
+			if got := readFile(t, filepath.Join(dir, name)); got != want {
+				t.Errorf("%s: %s is %q, want %q", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+// The unified diff of two directories, applied to the first one, gives the second one.
+// Files only in one directory are not in the diff.
+func TestPatchRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/same.txt":    "x\n",
+		"a/changed.txt": testPatchFile,
+		"a/crlf.txt":    "1\r\n2\r\n3\r\n",
+		"b/same.txt":    "x\n",
+		"b/changed.txt": "0\n1\n2\n3\n4\nfive\n6\n7\n8\n9\n10",
+		"b/crlf.txt":    "1\r\ntwo\r\n3\r\n",
+	})
+
+	diff, _, status := runGodiff(t, dir, "", "-n", "-u", "a", "b")
+	if status != 1 {
+		t.Fatalf("diff exit status %d, want 1", status)
+	}
+	if _, stderr, status := runGodiff(t, filepath.Join(dir, "a"), diff, "patch"); status != 0 {
+		t.Fatalf("patch exit status %d: %s", status, stderr)
+	}
+	if output, _, status := runGodiff(t, dir, "", "-n", "-u", "a", "b"); status != 0 {
+		t.Errorf("patched directory is different:\n%s", output)
+	}
+}
@@ -0,0 +1,243 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// OrphanFile a file that exists on one side only
+type OrphanFile struct {
+	name   string // file name
+	other  string // name of the missing file on the other side
+	info   os.FileInfo
+	hashes []uint32 // sorted line hashes, for computing similarity
+}
+
+// RenameList files missing on the other side, collected while comparing directories.
+// Once all directories are compared, they are matched up by content to find the renamed files.
+type RenameList struct {
+	root1, root2 string
+	orphans      [2][]*OrphanFile
+}
+
+// RenamePair a file in each directory, with similar content
+type RenamePair struct {
+	file1, file2 *OrphanFile
+	similarity   int
+}
+
+func newRenameList(dirname1, dirname2 string) *RenameList {
+	return &RenameList{
+		root1: strings.TrimRight(dirname1, PathSeparator),
+		root2: strings.TrimRight(dirname2, PathSeparator),
+	}
+}
+
+// Add a file that only exists on one side (0 or 1)
+func (r *RenameList) add(side int, name, other string, info os.FileInfo) {
+	r.orphans[side] = append(r.orphans[side], &OrphanFile{name: name, other: other, info: info})
+}
+
+// Add all files in a directory that only exists on one side (0 or 1)
+func (r *RenameList) addDir(cfg *DiffConfig, side int, dirname, other string) {
+
+	dir, err := readSortedDir(cfg, dirname)
+	if err != nil {
+		cfg.setExitStatus(ExitTrouble)
+		if side == 0 {
+			outputDiffMessage(cfg, dirname, other, nil, nil, err.Error(), MsgDirNotExists, true)
+		} else {
+			outputDiffMessage(cfg, other, dirname, nil, nil, MsgDirNotExists, err.Error(), true)
+		}
+		return
+	}
+
+	for _, info := range dir {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if info.IsDir() {
+			r.addDir(cfg, side, dirname+PathSeparator+name, other+PathSeparator+name)
+		} else {
+			r.add(side, dirname+PathSeparator+name, other+PathSeparator+name, info)
+		}
+	}
+}
+
+// Compute the line hashes of the file on one side (0 or 1). Binary files are hashed as a single line.
+func (f *OrphanFile) computeHashes(cfg *DiffConfig, side int) {
+
+	// large files are hashed one line at a time, binary files are not compared
+	if cfg.isLargeFile(f.name, f.info) {
+		file := openLargeFile(f.name, f.info, 0, false, func(line []byte) {
+			f.hashes = append(f.hashes, diff.LineHashes([][]byte{line}, &cfg.cmpOptions)...)
+		})
+		file.closeFile()
+		if file.errorMsg != "" || file.isBinary {
+			f.hashes = nil
+		}
+		sort.Slice(f.hashes, func(i, j int) bool { return f.hashes[i] < f.hashes[j] })
+		return
+	}
+
+	file := openFile(cfg, f.name, f.info)
+	defer file.closeFile()
+
+	file.decode(cfg, side)
+	if file.errorMsg != "" || len(file.data) == 0 {
+		return
+	}
+
+	if bytes.IndexByte(file.data[0:minInt(len(file.data), BinaryCheckSize)], 0) >= 0 {
+		f.hashes = diff.LineHashes([][]byte{file.data}, &diff.Options{})
+	} else {
+		f.hashes = diff.LineHashes(file.splitLines(), &cfg.cmpOptions)
+	}
+}
+
+// Find pairs of files with similar content, each file can only be in one pair.
+func (r *RenameList) match(cfg *DiffConfig) []RenamePair {
+
+	for side, orphans := range r.orphans {
+		for _, f := range orphans {
+			f.computeHashes(cfg, side)
+		}
+	}
+
+	var pairs []RenamePair
+	for _, f1 := range r.orphans[0] {
+		for _, f2 := range r.orphans[1] {
+			if len(f1.hashes) == 0 || len(f2.hashes) == 0 || diff.MaxSimilarity(len(f1.hashes), len(f2.hashes)) < cfg.renameThreshold {
+				continue
+			}
+			if s := diff.Similarity(f1.hashes, f2.hashes); s >= cfg.renameThreshold {
+				pairs = append(pairs, RenamePair{file1: f1, file2: f2, similarity: s})
+			}
+		}
+	}
+
+	// best matches first, prefer files with the same name (moved files)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].similarity != pairs[j].similarity {
+			return pairs[i].similarity > pairs[j].similarity
+		}
+		return pairs[i].sameName() && !pairs[j].sameName()
+	})
+
+	used := make(map[*OrphanFile]bool)
+	matched := pairs[:0]
+	for _, p := range pairs {
+		if !used[p.file1] && !used[p.file2] {
+			used[p.file1], used[p.file2] = true, true
+			matched = append(matched, p)
+		}
+	}
+
+	// remove the matched files from the list of orphans
+	for side, orphans := range r.orphans {
+		remain := orphans[:0]
+		for _, f := range orphans {
+			if !used[f] {
+				remain = append(remain, f)
+			}
+		}
+		r.orphans[side] = remain
+	}
+
+	return matched
+}
+
+func (p *RenamePair) sameName() bool {
+	return filepath.Base(p.file1.name) == filepath.Base(p.file2.name)
+}
+
+// file name relative to the directory being compared
+func relativeName(root, name string) string {
+	if rel, err := filepath.Rel(root, name); err == nil {
+		return rel
+	}
+	return name
+}
+
+// Report the renamed files and their differences, then the remaining files that only exist on one side.
+func diffRenames(cfg *DiffConfig) {
+
+	// the differences of a renamed file must follow its rename message,
+	// so they are compared here once the other files are done.
+	jobQueueFinish(cfg)
+
+	r := cfg.renames
+	for _, p := range r.match(cfg) {
+		// a renamed file is a difference, even when its content is the same
+		cfg.setExitStatus(ExitDifferent)
+		if cfg.stats == nil {
+			outputRenameMessage(cfg, p)
+		}
+		diffFile(cfg, p.file1.name, p.file2.name, p.file1.info, p.file2.info)
+	}
+
+	for _, f := range r.orphans[0] {
+		diffMissingFile(cfg, f.name, f.other, f.info, nil)
+	}
+	for _, f := range r.orphans[1] {
+		diffMissingFile(cfg, f.other, f.name, nil, f.info)
+	}
+}
+
+// Report a renamed file, the unified text format uses the same headers as git.
+// In brief mode, a single line is printed, the content is reported as for other files.
+func outputRenameMessage(cfg *DiffConfig, p RenamePair) {
+
+	name1 := relativeName(cfg.renames.root1, p.file1.name)
+	name2 := relativeName(cfg.renames.root2, p.file2.name)
+
+	switch {
+	case cfg.outputFormat == FormatJson:
+		outputJsonRecord(cfg, &JsonRecord{
+			Status:     JsonStatusRenamed,
+			File1:      newJsonFile(p.file1.name, p.file1.info, ""),
+			File2:      newJsonFile(p.file2.name, p.file2.info, ""),
+			Similarity: p.similarity,
+		})
+		return
+
+	case cfg.brief:
+		cfg.outAcquireLock()
+		fmt.Fprintf(out, "File %s renamed to %s\n", p.file1.name, p.file2.name)
+		cfg.outReleaseLock()
+		return
+
+	case cfg.outputFormat == FormatText && cfg.unifiedContext:
+		cfg.outAcquireLock()
+		fmt.Fprintf(out, "similarity index %d%%\n", p.similarity)
+		fmt.Fprintf(out, "rename from %s\n", name1)
+		fmt.Fprintf(out, "rename to %s\n", name2)
+		cfg.outReleaseLock()
+		return
+	}
+
+	outputDiffMessage(cfg, p.file1.name, p.file2.name, p.file1.info, p.file2.info, "", fmt.Sprintf(MsgRenamedFrom, name1, p.similarity), false)
+}
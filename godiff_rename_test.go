@@ -0,0 +1,90 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The rename message is followed by the differences of the same files, with several goroutines
+func TestRenameOutput(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 1; i <= 5; i++ {
+		var lines strings.Builder
+		for n := 1; n < 20; n++ {
+			fmt.Fprintf(&lines, "%d\n", n)
+		}
+		files[fmt.Sprintf("a/old%d.txt", i)] = lines.String() + "20\n"
+		files[fmt.Sprintf("b/new%d.txt", i)] = lines.String() + fmt.Sprintf("x%d\n", i)
+	}
+	for i := 1; i <= 30; i++ {
+		files[fmt.Sprintf("a/f%d", i)] = fmt.Sprintf("%d\n", i)
+		files[fmt.Sprintf("b/f%d", i)] = fmt.Sprintf("y%d\n", i)
+	}
+	writeFiles(t, dir, files)
+
+	name := func(dir, format string, i int) string {
+		return filepath.Join(dir, fmt.Sprintf(format, i))
+	}
+
+	for _, test := range []struct {
+		args   []string
+		rename func(i int) string
+		follow func(i int) string
+	}{
+		{
+			args:   []string{"-n", "-u"},
+			rename: func(i int) string { return fmt.Sprintf("rename to new%d.txt", i) },
+			follow: func(i int) string { return "--- " + name("a", "old%d.txt", i) + "\t" },
+		},
+		{
+			args: []string{"-q"},
+			rename: func(i int) string {
+				return fmt.Sprintf("File %s renamed to %s", name("a", "old%d.txt", i), name("b", "new%d.txt", i))
+			},
+			follow: func(i int) string {
+				return fmt.Sprintf("Files %s and %s differ", name("a", "old%d.txt", i), name("b", "new%d.txt", i))
+			},
+		},
+	} {
+		output, _, status := runGodiff(t, dir, "", append(test.args, "-rename", "-g", "4", "a", "b")...)
+		if status != 1 {
+			t.Errorf("%v: exit status %d, want 1", test.args, status)
+		}
+
+		lines := strings.Split(output, "\n")
+		found := 0
+		for n, line := range lines {
+			for i := 1; i <= 5; i++ {
+				if line != test.rename(i) {
+					continue
+				}
+				found++
+				if n+1 >= len(lines) || !strings.HasPrefix(lines[n+1], test.follow(i)) {
+					t.Errorf("%v: %q is not followed by %q", test.args, line, test.follow(i))
+				}
+			}
+		}
+		if found != 5 {
+			t.Errorf("%v: found %d renamed files, want 5:\n%s", test.args, found, output)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+// RuleSeparator separates the pattern and the replacement in the rules file
+const RuleSeparator = "=>"
+
+// Load the substitutions applied to the lines before they are compared, from the -rules file.
+// Each line of the file is a rule: pattern => replacement.
+// Empty lines, and lines starting with # are ignored.
+func loadRules(fileName string) ([]diff.Substitution, error) {
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []diff.Substitution
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the replacement is after the last separator, the pattern may contain it
+		i := strings.LastIndex(line, RuleSeparator)
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: missing %s in rule", fileName, lineno, RuleSeparator)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, lineno, err.Error())
+		}
+		rules = append(rules, diff.Substitution{Pattern: re, Replacement: []byte(strings.TrimSpace(line[i+len(RuleSeparator):]))})
+	}
+	return rules, scanner.Err()
+}
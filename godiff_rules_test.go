@@ -0,0 +1,90 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"rules": "# volatile tokens\n\n" +
+			"[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12} => <uuid>\n" +
+			"  0x[0-9a-f]+  =>  <addr>  \n" +
+			"a=>b => $1\n",
+		"missing":  "[0-9]+\n",
+		"badregex": "([0-9] => x\n",
+	})
+
+	rules, err := loadRules(filepath.Join(dir, "rules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Pattern.String()+" "+string(r.Replacement))
+	}
+	want := []string{
+		"[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12} <uuid>",
+		"0x[0-9a-f]+ <addr>",
+		"a=>b $1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, name := range []string{"missing", "badregex", "nofile"} {
+		if _, err := loadRules(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// Files differing only by the replaced text are the same, and the diffs show the original lines
+func TestRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"rules": "0x[0-9a-f]+ => <addr>\n/tmp/build-[0-9]+ => <tmp>\n",
+		"a/log": "start /tmp/build-123/out\nobject at 0x7f00\ndone\n",
+		"b/log": "start /tmp/build-456/out\nobject at 0x7fa8\ndone\n",
+		"c/log": "start /tmp/build-456/out\nobject at 0x7fa8\nfailed\n",
+	})
+
+	for _, args := range [][]string{{"-n"}, {"-n", "-q"}, {"-n", "-u"}, {"-n", "-max-size", "1"}, {"-format", "json"}} {
+		output, _, status := runGodiff(t, dir, "", append(args, "-rules", "rules", "a", "b")...)
+		if status != 0 {
+			t.Errorf("%v: got %q, exit status %d, want the same files", args, output, status)
+		}
+		if _, _, status := runGodiff(t, dir, "", append(args, "a", "b")...); status != 1 {
+			t.Errorf("%v without -rules: exit status %d, want 1", args, status)
+		}
+	}
+
+	for _, maxSize := range []string{"0", "1"} {
+		output, _, _ := runGodiff(t, dir, "", "-n", "-u", "-max-size", maxSize, "-rules", "rules", "a/log", "c/log")
+		if got, want := unifiedHunks(output), "@@ -1,3 +1,3 @@\n start /tmp/build-123/out\n object at 0x7f00\n-done\n+failed\n"; got != want {
+			t.Errorf("-max-size %s: got %q, want %q", maxSize, got, want)
+		}
+	}
+
+	if _, stderr, status := runGodiff(t, dir, "", "-n", "-rules", "nofile", "a", "b"); status != ExitTrouble || stderr == "" {
+		t.Errorf("missing rules file: exit status %d, stderr %q", status, stderr)
+	}
+}
@@ -0,0 +1,225 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/GoToUse/godiff/diff"
+)
+
+const (
+	// DefaultWidth default width of the side by side output, same as gnu diff
+	DefaultWidth = 130
+
+	// TabWidth tab stops in the side by side output
+	TabWidth = 8
+)
+
+// DiffChangerSideBySide changes to be output side by side in a terminal
+type DiffChangerSideBySide struct {
+	DiffChangerData
+}
+
+// A line in one of the side by side columns.
+// Tokens line[pos[i]:pos[i+1]] with change[i] set are highlighted.
+type sideCell struct {
+	line   []byte
+	lineno int // 0 if there is no line number
+	pos    []int
+	change []bool
+	color  string
+}
+
+// Number of terminal columns used by a rune.
+// East Asian wide and fullwidth runes use 2 columns, combining marks do not use any.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0:
+		return 0
+	case r < 0x1100:
+		if r >= 0x300 && unicode.In(r, unicode.Mn, unicode.Me) {
+			return 0
+		}
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r <= 0x115F, // Hangul Jamo
+		r == 0x2329 || r == 0x232A,
+		r >= 0x2E80 && r <= 0xA4CF && r != 0x303F, // CJK ... Yi
+		r >= 0xAC00 && r <= 0xD7A3,                // Hangul Syllables
+		r >= 0xF900 && r <= 0xFAFF,                // CJK Compatibility Ideographs
+		r >= 0xFE10 && r <= 0xFE19,                // Vertical forms
+		r >= 0xFE30 && r <= 0xFE6F,                // CJK Compatibility Forms
+		r >= 0xFF00 && r <= 0xFF60,                // Fullwidth Forms
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F, // Emoji
+		r >= 0x1F900 && r <= 0x1F9FF,
+		r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
+
+// Write a cell into a column of this display width, expanding tabs and padding with spaces if required.
+func writeSideCell(buf *bytes.Buffer, cell *sideCell, width int, pad, color bool) {
+
+	col := 0
+	if color && cell.color != "" {
+		buf.WriteString(cell.color)
+	}
+
+	inChg := false
+tokens:
+	for t := 0; t+1 < len(cell.pos) && col < width; t++ {
+		if chg := cell.change != nil && cell.change[t]; color && chg != inChg {
+			if chg {
+				buf.WriteString(AnsiChg)
+			} else {
+				buf.WriteString(AnsiChgOff)
+			}
+			inChg = chg
+		}
+
+		for i, end := cell.pos[t], cell.pos[t+1]; i < end; {
+			r, size := utf8.DecodeRune(cell.line[i:end])
+			if r == '\t' {
+				n := minInt(TabWidth-col%TabWidth, width-col)
+				buf.WriteString(strings.Repeat(" ", n))
+				col += n
+			} else {
+				w := runeWidth(r)
+				if col+w > width {
+					break tokens
+				}
+				if r == utf8.RuneError || unicode.IsControl(r) {
+					buf.WriteByte('?')
+				} else {
+					buf.Write(cell.line[i : i+size])
+				}
+				col += w
+			}
+			i += size
+		}
+	}
+
+	if color && (cell.color != "" || inChg) {
+		buf.WriteString(AnsiReset)
+	}
+	if pad && col < width {
+		buf.WriteString(strings.Repeat(" ", width-col))
+	}
+}
+
+// Output one row of the side by side columns, with a gutter marker in between
+func (chg *DiffChangerSideBySide) writeRow(cell1 *sideCell, gutter byte, cell2 *sideCell) {
+
+	color := chg.cfg.color
+	textWidth := maxInt((chg.cfg.width-3)/2-chg.linenoWidth-1, 1)
+
+	chg.buf1.Reset()
+	for i, cell := range [2]*sideCell{cell1, cell2} {
+		if i > 0 {
+			chg.buf1.WriteByte(' ')
+			chg.buf1.WriteByte(gutter)
+			chg.buf1.WriteByte(' ')
+		}
+		switch {
+		case cell.lineno > 0:
+			fmt.Fprintf(&chg.buf1, "%*d ", chg.linenoWidth, cell.lineno)
+			writeSideCell(&chg.buf1, cell, textWidth, i == 0, color)
+		case cell.line != nil:
+			chg.buf1.WriteString(strings.Repeat(" ", chg.linenoWidth+1))
+			writeSideCell(&chg.buf1, cell, textWidth, i == 0, color)
+		case i == 0:
+			chg.buf1.WriteString(strings.Repeat(" ", chg.linenoWidth+1+textWidth))
+		}
+	}
+	out.Write(bytes.TrimRight(chg.buf1.Bytes(), " "))
+	out.WriteByte('\n')
+}
+
+// A cell for the whole line, without changes within the line
+func newSideCell(lines [][]byte, first, i int, color string) *sideCell {
+	line := lines[i-first]
+	return &sideCell{line: line, lineno: i + 1, pos: []int{0, len(line)}, color: color}
+}
+
+func (chg *DiffChangerSideBySide) DiffLines(ops []diff.DiffOp) {
+
+	if !chg.headerPrinted {
+		chg.cfg.outAcquireLock()
+		chg.headerPrinted = true
+		name1 := []byte(chg.name1)
+		name2 := []byte(chg.name2)
+		chg.writeRow(&sideCell{line: name1, pos: []int{0, len(name1)}, color: AnsiBold}, ' ',
+			&sideCell{line: name2, pos: []int{0, len(name2)}, color: AnsiBold})
+		writeTextNote(chg.cfg, chg.note)
+	} else {
+		// separate each group of changes
+		out.WriteString(strings.Repeat("-", maxInt(chg.cfg.width, 1)))
+		out.WriteByte('\n')
+	}
+
+	empty := &sideCell{}
+
+	for _, v := range ops {
+		switch v.Op {
+		case diff.DiffOpInsert:
+			for i := v.Start2; i < v.End2; i++ {
+				chg.writeRow(empty, '>', newSideCell(chg.file2, chg.first2, i, AnsiAdd))
+			}
+
+		case diff.DiffOpRemove:
+			for i := v.Start1; i < v.End1; i++ {
+				chg.writeRow(newSideCell(chg.file1, chg.first1, i, AnsiDel), '<', empty)
+			}
+
+		case diff.DiffOpModify:
+			i1, i2 := v.Start1, v.Start2
+			for ; i1 < v.End1 && i2 < v.End2; i1, i2 = i1+1, i2+1 {
+				cell1, cell2 := newSideCell(chg.file1, chg.first1, i1, AnsiUpd), newSideCell(chg.file2, chg.first2, i2, AnsiUpd)
+				if !chg.cfg.suppressLineChanges {
+					cell1.pos, cell1.change, cell2.pos, cell2.change = lineChanges(chg.cfg, cell1.line, cell2.line)
+				}
+				chg.writeRow(cell1, '|', cell2)
+			}
+			for ; i1 < v.End1; i1++ {
+				chg.writeRow(newSideCell(chg.file1, chg.first1, i1, AnsiDel), '<', empty)
+			}
+			for ; i2 < v.End2; i2++ {
+				chg.writeRow(empty, '>', newSideCell(chg.file2, chg.first2, i2, AnsiAdd))
+			}
+
+		default:
+			for i1, i2 := v.Start1, v.Start2; i1 < v.End1 || i2 < v.End2; i1, i2 = i1+1, i2+1 {
+				cell1, cell2 := empty, empty
+				if i1 < v.End1 {
+					cell1 = newSideCell(chg.file1, chg.first1, i1, "")
+				}
+				if i2 < v.End2 {
+					cell2 = newSideCell(chg.file2, chg.first2, i2, "")
+				}
+				chg.writeRow(cell1, ' ', cell2)
+			}
+		}
+	}
+}
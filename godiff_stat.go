@@ -0,0 +1,181 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// StatWidth width of the --stat output, same as git
+const StatWidth = 80
+
+// Output formats for the diffstat
+const (
+	StatFormatStat    = "stat"
+	StatFormatNumstat = "numstat"
+)
+
+// StatEntry number of lines changed in a pair of files
+type StatEntry struct {
+	name         string
+	added        int
+	removed      int
+	binary       bool
+	size1, size2 int64
+	message      string // reported instead of the number of changes, e.g. for directories
+}
+
+// DiffStat collect the number of changes for each pair of files, instead of displaying the changes
+type DiffStat struct {
+	format       string // stat or numstat
+	root1, root2 string // the directories being compared, file names are shown relative to them
+	entries      []*StatEntry
+}
+
+// Name of the files, relative to the directories being compared.
+// Renamed files are shown as old => new.
+func (s *DiffStat) statName(filename1, filename2 string) string {
+	name1, name2 := filename1, filename2
+	if s.root1 != "" {
+		name1 = relativeName(s.root1, filename1)
+		name2 = relativeName(s.root2, filename2)
+	}
+	if name1 == name2 {
+		return name1
+	}
+	return name1 + " => " + name2
+}
+
+// Add the number of changed lines for a pair of files
+func (cfg *DiffConfig) addStat(e *StatEntry) {
+	cfg.outAcquireLock()
+	cfg.stats.entries = append(cfg.stats.entries, e)
+	cfg.outReleaseLock()
+}
+
+// Add a pair of files that were reported with a message.
+// Missing files count as all lines added or removed.
+func (cfg *DiffConfig) addStatMessage(filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, numLines int) {
+
+	if msg1 == MsgFileIdentical && msg2 == MsgFileIdentical {
+		return
+	}
+
+	e := &StatEntry{name: cfg.stats.statName(filename1, filename2)}
+	if info1 != nil {
+		e.size1 = info1.Size()
+	}
+	if info2 != nil {
+		e.size2 = info2.Size()
+	}
+
+	switch {
+	case msg1 == MsgBinFileDiffers || msg2 == MsgBinFileDiffers || msg1 == MsgFileIsBinary || msg2 == MsgFileIsBinary:
+		e.binary = true
+	case info1 == nil && info2 != nil && !info2.IsDir():
+		e.added = numLines
+	case info2 == nil && info1 != nil && !info1.IsDir():
+		e.removed = numLines
+	default:
+		e.message = joinMessages(msg1, msg2)
+	}
+
+	cfg.addStat(e)
+}
+
+// Scale the number of changes to the width of the graph, same as git
+func scaleStat(n, width, maxChange int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/maxChange
+}
+
+// Write out the diffstat
+func (s *DiffStat) write(cfg *DiffConfig) {
+
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+
+	if s.format == StatFormatNumstat {
+		for _, e := range s.entries {
+			switch {
+			case e.binary:
+				fmt.Fprintf(out, "-\t-\t%s\n", e.name)
+			case e.message == "":
+				fmt.Fprintf(out, "%d\t%d\t%s\n", e.added, e.removed, e.name)
+			}
+		}
+		return
+	}
+
+	nameWidth, maxChange, files, added, removed := 0, 0, 0, 0, 0
+	for _, e := range s.entries {
+		nameWidth = maxInt(nameWidth, len(e.name))
+		maxChange = maxInt(maxChange, e.added+e.removed)
+		if e.message == "" {
+			files++
+		}
+		added += e.added
+		removed += e.removed
+	}
+
+	numberWidth := len(fmt.Sprintf("%d", maxChange))
+	graphWidth := maxInt(StatWidth-nameWidth-numberWidth-6, 6)
+
+	for _, e := range s.entries {
+		switch {
+		case e.binary:
+			fmt.Fprintf(out, " %-*s | %*s %d -> %d bytes\n", nameWidth, e.name, numberWidth, "Bin", e.size1, e.size2)
+		case e.message != "":
+			fmt.Fprintf(out, " %-*s | %s\n", nameWidth, e.name, e.message)
+		default:
+			plus, minus := e.added, e.removed
+			if maxChange > graphWidth {
+				total := scaleStat(plus+minus, graphWidth, maxChange)
+				if total < 2 && plus > 0 && minus > 0 {
+					total = 2
+				}
+				if plus < minus {
+					plus = scaleStat(plus, graphWidth, maxChange)
+					minus = total - plus
+				} else {
+					minus = scaleStat(minus, graphWidth, maxChange)
+					plus = total - minus
+				}
+			}
+			fmt.Fprintf(out, " %-*s | %*d", nameWidth, e.name, numberWidth, e.added+e.removed)
+			if plus+minus > 0 {
+				out.WriteByte(' ')
+				out.WriteString(cfg.colored(AnsiAdd, strings.Repeat("+", plus)))
+				out.WriteString(cfg.colored(AnsiDel, strings.Repeat("-", minus)))
+			}
+			out.WriteByte('\n')
+		}
+	}
+
+	fmt.Fprintf(out, " %s changed", plural(files, "file"))
+	if added > 0 {
+		fmt.Fprintf(out, ", %s(+)", plural(added, "insertion"))
+	}
+	if removed > 0 {
+		fmt.Fprintf(out, ", %s(-)", plural(removed, "deletion"))
+	}
+	out.WriteByte('\n')
+}
@@ -0,0 +1,206 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The tests run godiff in a child process, the test binary itself with this variable set
+const testMainEnv = "GODIFF_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) != "" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// Run godiff in dir, with the arguments and stdin given.
+// Returns the output, the messages written to stderr, and the exit status.
+func runGodiff(t *testing.T, dir, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), testMainEnv+"=1", "NO_COLOR=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return stdout.String(), stderr.String(), 0
+	case errors.As(err, &exitErr):
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	t.Fatalf("godiff %s: %v", strings.Join(args, " "), err)
+	return "", "", 0
+}
+
+// Create the files in dir, the names are relative to dir and can contain directories
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		fName := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fName), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fName, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Content of a file, or "<missing>" if it does not exist
+func readFile(t *testing.T, fName string) string {
+	t.Helper()
+	data, err := os.ReadFile(fName)
+	if errors.Is(err, os.ErrNotExist) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// The lines after the --- and +++ headers of the unified output
+func unifiedHunks(output string) string {
+	lines := strings.SplitAfter(output, "\n")
+	for len(lines) > 0 && (strings.HasPrefix(lines[0], "--- ") || strings.HasPrefix(lines[0], "+++ ")) {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "")
+}
+
+func TestWordDiff(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"file1": "if (a == b) {\n\treturn x\n}\n",
+		"file2": "if (a != c) {\n\treturn x\n}\n",
+	})
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-u", "-granularity", "word"}, "@@ -1,3 +1,3 @@\nif (a [-==-]{+!=+} [-b-]{+c+}) {\n\treturn x\n}\n"},
+		{[]string{"-u", "-granularity", "char"}, "@@ -1,3 +1,3 @@\n-if (a == b) {\n+if (a != c) {\n \treturn x\n }\n"},
+	}
+	for _, test := range tests {
+		output, _, status := runGodiff(t, dir, "", append(append([]string{"-n"}, test.args...), "file1", "file2")...)
+		if status != 1 {
+			t.Errorf("%v: exit status %d, want 1", test.args, status)
+		}
+		if got := unifiedHunks(output); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// Same as diff, the exit status is 0 if there are no differences, 1 if there are some, 2 on trouble.
+func TestExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"same1":         "a\nb\n",
+		"same2":         "a\nb\n",
+		"changed":       "a\nB\n",
+		"d1/f.txt":      "a\n",
+		"d2/f.txt":      "a\n",
+		"d3/f.txt":      "b\n",
+		"r1/old.txt":    "1\n2\n3\n",
+		"r2/new.txt":    "1\n2\n3\n",
+		"base":          "a\nb\nc\n",
+		"mine":          "A\nb\nc\n",
+		"theirs":        "a\nb\nC\n",
+		"sub/base.txt":  "a\n",
+		"sub/other.txt": "b\n",
+	})
+
+	formats := [][]string{nil, {"-n"}, {"-n", "-u"}, {"-format", "json"}, {"-q"}, {"-stat"}, {"-n", "-y"}}
+
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"same1", "same2"}, 0},
+		{[]string{"same1", "changed"}, 1},
+		{[]string{"same1", "missing"}, 2},
+		{[]string{"d1", "d2"}, 0},
+		{[]string{"d1", "d3"}, 1},
+		{[]string{"d1", "sub"}, 1},
+		// a renamed file is a difference, even with the same content
+		{[]string{"-rename", "r1", "r2"}, 1},
+		{[]string{"-3", "base", "base", "base"}, 0},
+		{[]string{"-3", "base", "mine", "theirs"}, 1},
+		{[]string{"-3", "base", "mine", "missing"}, 2},
+	}
+
+	for _, test := range tests {
+		for _, format := range formats {
+			if test.args[0] == "-3" && len(format) > 0 && (format[0] != "-n" || len(format) > 1) {
+				continue
+			}
+			args := append(append([]string{}, format...), test.args...)
+			if _, _, status := runGodiff(t, dir, "", args...); status != test.status {
+				t.Errorf("%v: exit status %d, want %d", args, status, test.status)
+			}
+		}
+	}
+}
+
+// "-" reads the file from stdin
+func TestStdin(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": "a\nb\n"})
+
+	tests := []struct {
+		stdin  string
+		args   []string
+		want   string
+		status int
+	}{
+		{"a\nB\n", []string{"-n", "-u", "-", "file"}, "@@ -1,2 +1,2 @@\n a\n-B\n+b\n", 1},
+		{"a\nB\n", []string{"-n", "file", "-"}, "<<< file\n>>> -\n2c2\n< b\n---\n> B\n", 1},
+		{"a\nb\n", []string{"-n", "file", "-"}, "", 0},
+		{"a\nb\n", []string{"-q", "file", "-"}, "", 0},
+		// stdin is only read once
+		{"a\nb\n", []string{"-n", "-", "-"}, "", 0},
+		{"a\nb\nc\n", []string{"-n", "-max-size", "4", "file", "-"}, "--- file: \n+++ -: File too big\n\n", 2},
+	}
+	for _, test := range tests {
+		output, _, status := runGodiff(t, dir, test.stdin, test.args...)
+		if status != test.status {
+			t.Errorf("%v: exit status %d, want %d", test.args, status, test.status)
+		}
+		if test.args[1] == "-u" {
+			output = unifiedHunks(output)
+		}
+		if got := output; got != test.want {
+			t.Errorf("%v: got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
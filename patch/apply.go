@@ -0,0 +1,231 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package patch
+
+import (
+	"bytes"
+)
+
+// DefaultFuzz max number of context lines that can be ignored, same as gnu patch
+const DefaultFuzz = 2
+
+// Options for applying hunks
+type Options struct {
+	Fuzz int // Max number of context lines to ignore at the start and end of a hunk, when it does not match
+}
+
+// HunkResult where and how a hunk is applied
+type HunkResult struct {
+	Applied bool
+	Line    int // line number where the hunk is applied, or expected to be applied if it failed
+	Offset  int // number of lines the hunk has moved from the position given in its header
+	Fuzz    int // number of context lines ignored
+}
+
+// Apply the hunks to the file content in data, and return the new content.
+// Hunks that can not be applied are skipped, see the Applied field in the results.
+func Apply(data []byte, hunks []*Hunk, opts *Options) ([]byte, []HunkResult) {
+
+	lines, crlf, noNewline := splitFile(data)
+	results := make([]HunkResult, len(hunks))
+
+	newLines := make([][]byte, 0, len(lines))
+	pos, offset := 0, 0
+
+	for n, h := range hunks {
+
+		// expected position of the first old line
+		expect := h.OldStart - 1
+		if h.OldLines == 0 {
+			expect = h.OldStart
+		}
+		results[n].Line = expect + offset + 1
+
+		for fuzz := 0; fuzz <= opts.Fuzz; fuzz++ {
+			front, back, ok := h.trimContext(fuzz)
+			if !ok {
+				break
+			}
+			find := h.side('-', front, back)
+
+			at, found := findLines(lines, find, pos, expect+offset+front)
+			if !found {
+				continue
+			}
+
+			newLines = append(newLines, lines[pos:at]...)
+			newLines = append(newLines, h.replace(lines[at:at+len(find)], front, back, crlf)...)
+			pos = at + len(find)
+
+			// the hunk is at the end of file, the last line may have gained or lost its newline
+			if pos == len(lines) && back == 0 {
+				noNewline = h.endsWithoutNewline('+')
+			}
+
+			results[n] = HunkResult{
+				Applied: true,
+				Line:    at - front + 1,
+				Offset:  at - front - expect,
+				Fuzz:    fuzz,
+			}
+			offset = results[n].Offset
+			break
+		}
+	}
+
+	newLines = append(newLines, lines[pos:]...)
+
+	return joinFile(newLines, noNewline), results
+}
+
+// Number of context lines to ignore at the beginning and end of the hunk, for the fuzz factor.
+// Returns false if there are not enough context lines.
+func (h *Hunk) trimContext(fuzz int) (int, int, bool) {
+	if fuzz == 0 {
+		return 0, 0, true
+	}
+	front, back := 0, 0
+	for front < len(h.Lines) && h.Lines[front].Op == ' ' {
+		front++
+	}
+	for back < len(h.Lines)-front && h.Lines[len(h.Lines)-back-1].Op == ' ' {
+		back++
+	}
+	if front == 0 && back == 0 {
+		return 0, 0, false
+	}
+	return minInt(front, fuzz), minInt(back, fuzz), true
+}
+
+// old lines ('-') or new lines ('+') of the hunk, including context lines
+func (h *Hunk) side(op byte, front, back int) [][]byte {
+	lines := make([][]byte, 0, len(h.Lines))
+	for _, line := range h.Lines[front : len(h.Lines)-back] {
+		if line.Op == op || line.Op == ' ' {
+			lines = append(lines, trimCR(line.Text))
+		}
+	}
+	return lines
+}
+
+// New lines of the hunk, to replace the old lines found in the file.
+// The context lines are kept as they are in the file, and the new lines get the line ending
+// of the old line they replace, or of the line before them.
+func (h *Hunk) replace(old [][]byte, front, back int, crlf bool) [][]byte {
+	lines := make([][]byte, 0, len(h.Lines))
+	var removed []bool
+	i := 0
+	for _, line := range h.Lines[front : len(h.Lines)-back] {
+		switch line.Op {
+		case ' ':
+			crlf = hasCR(old[i])
+			removed = removed[:0]
+			lines = append(lines, old[i])
+			i++
+		case '-':
+			crlf = hasCR(old[i])
+			removed = append(removed, crlf)
+			i++
+		case '+':
+			if len(removed) > 0 {
+				crlf, removed = removed[0], removed[1:]
+			}
+			text := trimCR(line.Text)
+			if crlf {
+				text = append(text[:len(text):len(text)], '\r')
+			}
+			lines = append(lines, text)
+		}
+	}
+	return lines
+}
+
+// check if the last line on one side of the hunk has no newline
+func (h *Hunk) endsWithoutNewline(op byte) bool {
+	for i := len(h.Lines) - 1; i >= 0; i-- {
+		if h.Lines[i].Op == op || h.Lines[i].Op == ' ' {
+			return h.Lines[i].NoNewline
+		}
+	}
+	return false
+}
+
+// Search for the lines in file, starting at the expected position and moving outwards.
+// Lines before 'start' have already been changed by earlier hunks, and will not be searched.
+func findLines(lines, find [][]byte, start, expect int) (int, bool) {
+	last := len(lines) - len(find)
+	for d := 0; expect-d >= start || expect+d <= last; d++ {
+		if at := expect - d; at >= start && at <= last && matchLines(lines[at:], find) {
+			return at, true
+		}
+		if at := expect + d; d > 0 && at >= start && at <= last && matchLines(lines[at:], find) {
+			return at, true
+		}
+	}
+	return 0, false
+}
+
+func matchLines(lines, find [][]byte) bool {
+	for i, line := range find {
+		if !bytes.Equal(trimCR(lines[i]), line) {
+			return false
+		}
+	}
+	return true
+}
+
+// split up file into lines, and note the type of newline of the first line.
+// The lines keep their CR, so that each line is written back with its own line ending.
+func splitFile(data []byte) ([][]byte, bool, bool) {
+	if len(data) == 0 {
+		return nil, false, false
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	noNewline := len(lines[len(lines)-1]) > 0
+	if !noNewline {
+		lines = lines[:len(lines)-1]
+	}
+	crlf := len(lines) > 0 && hasCR(lines[0])
+	return lines, crlf, noNewline
+}
+
+// join the lines back into a file
+func joinFile(lines [][]byte, noNewline bool) []byte {
+	var buf bytes.Buffer
+	for i, line := range lines {
+		buf.Write(line)
+		if i < len(lines)-1 || !noNewline {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func hasCR(line []byte) bool {
+	return bytes.HasSuffix(line, []byte("\r"))
+}
+
+func trimCR(line []byte) []byte {
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package patch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		patch   string
+		want    string
+		results []HunkResult
+	}{
+		{
+			name:    "exact",
+			file:    testFile,
+			patch:   testPatch,
+			want:    "1\n2\nthree\n4\n5\n6\n7\n8\n9\n9.5\n10\n",
+			results: []HunkResult{{true, 2, 0, 0}, {true, 8, 0, 0}},
+		},
+		{
+			// Hunk #1 succeeded at 4 (offset 2 lines).
+			name:    "offset",
+			file:    "a\nb\n" + testFile,
+			patch:   testPatch,
+			want:    "a\nb\n1\n2\nthree\n4\n5\n6\n7\n8\n9\n9.5\n10\n",
+			results: []HunkResult{{true, 4, 2, 0}, {true, 10, 2, 0}},
+		},
+		{
+			// Hunk #1 succeeded at 2 with fuzz 1.
+			name:    "fuzz",
+			file:    strings.Replace(testFile, "2\n", "two\n", 1),
+			patch:   testPatch,
+			want:    "1\ntwo\nthree\n4\n5\n6\n7\n8\n9\n9.5\n10\n",
+			results: []HunkResult{{true, 2, 0, 1}, {true, 8, 0, 0}},
+		},
+		{
+			// Hunk #1 FAILED at 2.
+			name:    "reject",
+			file:    strings.Replace(testFile, "3\n", "x\n", 1),
+			patch:   testPatch,
+			want:    "1\n2\nx\n4\n5\n6\n7\n8\n9\n9.5\n10\n",
+			results: []HunkResult{{false, 2, 0, 0}, {true, 8, 0, 0}},
+		},
+		{
+			name:    "crlf",
+			file:    strings.ReplaceAll(testFile, "\n", "\r\n"),
+			patch:   testPatch,
+			want:    "1\r\n2\r\nthree\r\n4\r\n5\r\n6\r\n7\r\n8\r\n9\r\n9.5\r\n10\r\n",
+			results: []HunkResult{{true, 2, 0, 0}, {true, 8, 0, 0}},
+		},
+		{
+			// the new lines get the line ending of the line they replace, or of the line before them
+			name:    "mixed line endings",
+			file:    "1\r\n2\n3\r\n4\n5\n6\n7\n8\n9\n10\r\n",
+			patch:   testPatch,
+			want:    "1\r\n2\nthree\r\n4\n5\n6\n7\n8\n9\n9.5\n10\r\n",
+			results: []HunkResult{{true, 2, 0, 0}, {true, 8, 0, 0}},
+		},
+		{
+			name:    "no newline",
+			file:    "a\nb",
+			patch:   testPatchNoNewline,
+			want:    "a\nb\n",
+			results: []HunkResult{{true, 1, 0, 0}},
+		},
+		{
+			name:    "create",
+			file:    "",
+			patch:   "--- /dev/null\n+++ b/h\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+			want:    "a\nb\n",
+			results: []HunkResult{{true, 1, 0, 0}},
+		},
+		{
+			name:    "delete",
+			file:    "a\nb\n",
+			patch:   "--- a/h\n+++ b/h\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+			want:    "",
+			results: []HunkResult{{true, 1, 0, 0}},
+		},
+	}
+
+	for _, test := range tests {
+		fd := parseTest(t, test.patch)
+		result, results := Apply([]byte(test.file), fd.Hunks, &Options{Fuzz: DefaultFuzz})
+		if string(result) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, result, test.want)
+		}
+		for i, r := range results {
+			if r != test.results[i] {
+				t.Errorf("%s: hunk %d got %+v, want %+v", test.name, i+1, r, test.results[i])
+			}
+		}
+
+		// without fuzz, the hunk is rejected
+		if test.name == "fuzz" {
+			_, results = Apply([]byte(test.file), fd.Hunks, &Options{})
+			if results[0].Applied {
+				t.Errorf("%s: hunk 1 applied without fuzz", test.name)
+			}
+		}
+	}
+}
+
+// Applying the reversed diff to the patched file gives back the original file
+func TestApplyReverse(t *testing.T) {
+	for _, test := range []struct{ file, patch string }{
+		{testFile, testPatch},
+		{"a\nb", testPatchNoNewline},
+		{"", "--- /dev/null\n+++ b/h\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+	} {
+		fd := parseTest(t, test.patch)
+		patched, _ := Apply([]byte(test.file), fd.Hunks, &Options{})
+
+		result, results := Apply(patched, fd.Reverse().Hunks, &Options{})
+		for i, r := range results {
+			if !r.Applied || r.Offset != 0 || r.Fuzz != 0 {
+				t.Errorf("%q: reversed hunk %d %+v", test.patch, i+1, r)
+			}
+		}
+		if string(result) != test.file {
+			t.Errorf("%q: got %q, want %q", test.patch, result, test.file)
+		}
+	}
+}
+
+func TestDeletesFile(t *testing.T) {
+	for _, test := range []struct {
+		patch string
+		want  bool
+	}{
+		{testPatch, false},
+		{"--- a/h\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-a\n-b\n", true},
+		{"--- a/h\n+++ b/h\n@@ -1,2 +0,0 @@\n-a\n-b\n", true},
+		{"--- /dev/null\n+++ b/h\n@@ -0,0 +1,2 @@\n+a\n+b\n", false},
+	} {
+		fd := parseTest(t, test.patch)
+		if got := fd.DeletesFile(); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.patch, got, test.want)
+		}
+	}
+
+	// reversing a file creation deletes the file
+	fd := parseTest(t, "--- a/h\n+++ b/h\n@@ -0,0 +1,2 @@\n+a\n+b\n").Reverse()
+	if !fd.DeletesFile() {
+		t.Errorf("reversed file creation does not delete the file")
+	}
+}
@@ -0,0 +1,287 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package patch reads unified diffs, such as the output of "godiff -n -u",
+// and applies them to text files.
+//
+// Hunks that no longer match exactly can still be applied when the lines
+// have moved (offset), or by ignoring some of the context lines (fuzz),
+// in a similar way to gnu patch.
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DevNull name used in the ---/+++ header when a file is created or deleted
+const DevNull = "/dev/null"
+
+// Line a single line in a hunk
+type Line struct {
+	Op        byte   // ' ' context line, '-' removed line, '+' added line
+	Text      []byte // line content, without the newline
+	NoNewline bool   // last line of the file, and it does not end with a newline
+}
+
+// Hunk a group of changes, with the header @@ -OldStart,OldLines +NewStart,NewLines @@
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Section            string // text after the header, e.g. function name
+	Lines              []Line
+}
+
+// FileDiff all changes to a single file
+type FileDiff struct {
+	OldName, NewName string // names from the ---/+++ header, without the timestamps
+	Hunks            []*Hunk
+}
+
+// Parse read the unified diffs for all files from r.
+// Any text outside of the diffs is ignored, like "Only in ..." messages.
+func Parse(r io.Reader) ([]*FileDiff, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimSuffix(line, []byte("\r"))
+	}
+
+	var files []*FileDiff
+	var cur *FileDiff
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case bytes.HasPrefix(line, []byte("--- ")) && i+1 < len(lines) && bytes.HasPrefix(lines[i+1], []byte("+++ ")):
+			cur = &FileDiff{
+				OldName: parseName(line[4:]),
+				NewName: parseName(lines[i+1][4:]),
+			}
+			files = append(files, cur)
+			i++
+
+		case bytes.HasPrefix(line, []byte("@@ ")) && cur != nil:
+			hunk, err := parseHunkHeader(string(line))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+
+			// read the lines in the hunk, until all old and new lines are found
+			nOld, nNew := hunk.OldLines, hunk.NewLines
+			for nOld > 0 || nNew > 0 {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("line %d: unexpected end of hunk", i)
+				}
+				line = lines[i]
+				op := byte(' ')
+				if len(line) > 0 {
+					op, line = line[0], line[1:]
+				}
+				switch op {
+				case ' ':
+					nOld, nNew = nOld-1, nNew-1
+				case '-':
+					nOld--
+				case '+':
+					nNew--
+				case '\\':
+					hunk.markNoNewline()
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: malformed hunk", i+1)
+				}
+				if nOld < 0 || nNew < 0 {
+					return nil, fmt.Errorf("line %d: hunk has more lines than its header", i+1)
+				}
+				hunk.Lines = append(hunk.Lines, Line{Op: op, Text: line})
+			}
+
+			// the last line may not have a newline
+			if i+1 < len(lines) && bytes.HasPrefix(lines[i+1], []byte("\\")) {
+				hunk.markNoNewline()
+				i++
+			}
+
+			cur.Hunks = append(cur.Hunks, hunk)
+		}
+	}
+
+	return files, nil
+}
+
+// name in ---/+++ header, remove the timestamp after the tab
+func parseName(b []byte) string {
+	name := string(b)
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimRight(name, " ")
+}
+
+// parse the hunk header: @@ -l,s +l,s @@ section
+func parseHunkHeader(line string) (*Hunk, error) {
+
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return nil, fmt.Errorf("invalid hunk header %q", line)
+	}
+
+	hunk := &Hunk{}
+	var err1, err2 error
+	hunk.OldStart, hunk.OldLines, err1 = parseRange(fields[1][1:])
+	hunk.NewStart, hunk.NewLines, err2 = parseRange(fields[2][1:])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid hunk header %q", line)
+	}
+	if len(fields) == 5 {
+		hunk.Section = fields[4]
+	}
+	return hunk, nil
+}
+
+// parse the line range "start,count", count is 1 if not given
+func parseRange(s string) (int, int, error) {
+	start, count, found := strings.Cut(s, ",")
+	n1, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	n2 := 1
+	if found {
+		n2, err = strconv.Atoi(count)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return n1, n2, nil
+}
+
+// format the line range, the same way as in the header produced by gnu diff
+func formatRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// A "\ No newline at end of file" applies to the line just before it
+func (h *Hunk) markNoNewline() {
+	if len(h.Lines) > 0 {
+		h.Lines[len(h.Lines)-1].NoNewline = true
+	}
+}
+
+// Reverse swap the old and new side of the hunk, so it will undo the changes
+func (h *Hunk) Reverse() *Hunk {
+	r := &Hunk{
+		OldStart: h.NewStart,
+		OldLines: h.NewLines,
+		NewStart: h.OldStart,
+		NewLines: h.OldLines,
+		Section:  h.Section,
+		Lines:    make([]Line, 0, len(h.Lines)),
+	}
+
+	// removed lines must still come before the added lines
+	for i := 0; i < len(h.Lines); {
+		if h.Lines[i].Op == ' ' {
+			r.Lines = append(r.Lines, h.Lines[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(h.Lines) && h.Lines[j].Op != ' ' {
+			j++
+		}
+		for _, op := range []byte{'+', '-'} {
+			for _, line := range h.Lines[i:j] {
+				if line.Op == op {
+					line.Op = '+' + '-' - op
+					r.Lines = append(r.Lines, line)
+				}
+			}
+		}
+		i = j
+	}
+	return r
+}
+
+// Reverse swap the old and new files, so the diff will undo the changes
+func (fd *FileDiff) Reverse() *FileDiff {
+	r := &FileDiff{OldName: fd.NewName, NewName: fd.OldName}
+	for _, h := range fd.Hunks {
+		r.Hunks = append(r.Hunks, h.Reverse())
+	}
+	return r
+}
+
+// DeletesFile reports if the file is removed by the diff: the new file is /dev/null,
+// or the only hunk leaves it empty (+0,0), as in diff -N or when a file creation is reversed.
+func (fd *FileDiff) DeletesFile() bool {
+	if fd.NewName == DevNull {
+		return true
+	}
+	return len(fd.Hunks) == 1 && fd.Hunks[0].NewStart == 0 && fd.Hunks[0].NewLines == 0
+}
+
+// WriteTo write the hunk in unified diff format
+func (h *Hunk) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "@@ -%s +%s @@", formatRange(h.OldStart, h.OldLines), formatRange(h.NewStart, h.NewLines))
+	if h.Section != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(h.Section)
+	}
+	buf.WriteByte('\n')
+	for _, line := range h.Lines {
+		buf.WriteByte(line.Op)
+		buf.Write(line.Text)
+		buf.WriteByte('\n')
+		if line.NoNewline {
+			buf.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// WriteTo write the file header and all hunks in unified diff format
+func (fd *FileDiff) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	n, _ := fmt.Fprintf(bw, "--- %s\n+++ %s\n", fd.OldName, fd.NewName)
+	total := int64(n)
+	for _, h := range fd.Hunks {
+		m, err := h.WriteTo(bw)
+		total += m
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, bw.Flush()
+}
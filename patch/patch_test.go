@@ -0,0 +1,162 @@
+// File/Directory diff tool with HTML output
+// Copyright (C) 2012   Siu Pin Chao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package patch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// lines 1 to 10 of a file, one number per line
+const testFile = "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+
+// two hunks for testFile, as produced by diff -u
+const testPatch = `--- a/f.txt	2024-01-01 00:00:00.000000000 +0000
++++ b/f.txt	2024-01-01 00:00:00.000000000 +0000
+@@ -2,3 +2,3 @@ func
+ 2
+-3
++three
+ 4
+@@ -8,3 +8,4 @@
+ 8
+ 9
++9.5
+ 10
+`
+
+// the last line gains a newline
+const testPatchNoNewline = `--- a/g
++++ b/g
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`
+
+func parseTest(t *testing.T, s string) *FileDiff {
+	t.Helper()
+	files, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	return files[0]
+}
+
+// One line for each line of the hunk, with the op and text
+func hunkLines(h *Hunk) string {
+	var s []string
+	for _, line := range h.Lines {
+		text := string(line.Op) + string(line.Text)
+		if line.NoNewline {
+			text += "\\"
+		}
+		s = append(s, text)
+	}
+	return strings.Join(s, "|")
+}
+
+func TestParse(t *testing.T) {
+	fd := parseTest(t, "Only in a: x\n"+testPatch)
+
+	if fd.OldName != "a/f.txt" || fd.NewName != "b/f.txt" {
+		t.Errorf("names %q %q, want a/f.txt b/f.txt", fd.OldName, fd.NewName)
+	}
+	if len(fd.Hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2", len(fd.Hunks))
+	}
+
+	tests := []struct {
+		h                                      *Hunk
+		oldStart, oldLines, newStart, newLines int
+		section, lines                         string
+	}{
+		{fd.Hunks[0], 2, 3, 2, 3, "func", " 2|-3|+three| 4"},
+		{fd.Hunks[1], 8, 3, 8, 4, "", " 8| 9|+9.5| 10"},
+	}
+	for i, test := range tests {
+		h := test.h
+		if h.OldStart != test.oldStart || h.OldLines != test.oldLines || h.NewStart != test.newStart || h.NewLines != test.newLines {
+			t.Errorf("hunk %d: header -%d,%d +%d,%d", i+1, h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+		}
+		if h.Section != test.section {
+			t.Errorf("hunk %d: section %q, want %q", i+1, h.Section, test.section)
+		}
+		if got := hunkLines(h); got != test.lines {
+			t.Errorf("hunk %d: lines %q, want %q", i+1, got, test.lines)
+		}
+	}
+
+	fd = parseTest(t, testPatchNoNewline)
+	if got, want := hunkLines(fd.Hunks[0]), " a|-b\\|+b"; got != want {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n",
+		"--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n*b\n",
+		"--- a\n+++ b\n@@ -1,1 +1,1 @@\n-a\n-b\n+b\n",
+		"--- a\n+++ b\n@@ -x +1 @@\n",
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("no error for %q", s)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	for _, s := range []string{testPatch, testPatchNoNewline} {
+		var buf bytes.Buffer
+		if _, err := parseTest(t, s).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		// the timestamps are not kept
+		want := strings.ReplaceAll(s, "\t2024-01-01 00:00:00.000000000 +0000", "")
+		if buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	fd := parseTest(t, testPatch).Reverse()
+
+	if fd.OldName != "b/f.txt" || fd.NewName != "a/f.txt" {
+		t.Errorf("names %q %q, want b/f.txt a/f.txt", fd.OldName, fd.NewName)
+	}
+	h := fd.Hunks[1]
+	if h.OldStart != 8 || h.OldLines != 4 || h.NewStart != 8 || h.NewLines != 3 {
+		t.Errorf("header -%d,%d +%d,%d, want -8,4 +8,3", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	}
+	if got, want := hunkLines(fd.Hunks[0]), " 2|-three|+3| 4"; got != want {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+
+	// removed lines still come first
+	fd = parseTest(t, "--- a\n+++ b\n@@ -1,2 +1,3 @@\n-a\n+b\n+c\n d\n").Reverse()
+	if got, want := hunkLines(fd.Hunks[0]), "-b|-c|+a| d"; got != want {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+}